@@ -1,13 +1,28 @@
 package acl
 
 import (
+	"context"
 	"database/sql"
+	"sort"
+	"time"
 )
 
 const (
 	EMPTY_RESOURCE = "00000000-0000-0000-0000-000000000000"
 )
 
+// Querier is the subset of database/sql used by the ACL, it is satisfied by
+// *sql.DB, *sql.Conn and *sql.Tx.
+//
+// Native pgx connections and pools are not supported yet since they return
+// their own row types, until then a pgx pool has to be used through the
+// database/sql adapter in github.com/jackc/pgx/v5/stdlib (stdlib.OpenDBFromPool)
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 // Resource represents an object requesting to perform an action or an object acted upon
 type Resource interface {
 	GetId() string
@@ -22,196 +37,378 @@ func (n NilResource) GetId() string {
 }
 
 // ActionAuthorizer is an interface which contains the methods to test authorization,
-// useful for providing test-stubs instead of a full ACL-implementation
+// useful for providing test-stubs instead of a full ACL-implementation. The
+// Querier can be a transaction, a connection or a whole connection pool
 type ActionAuthorizer interface {
-	AllowsAction(tx *sql.Tx, actor Resource, action string) (bool, error)
-	AllowsActionOn(tx *sql.Tx, actor Resource, action string, target Resource) (bool, error)
+	AllowsAction(q Querier, actor Resource, action string) (bool, error)
+	AllowsActionOn(q Querier, actor Resource, action string, target Resource) (bool, error)
+}
+
+// ContextActionAuthorizer contains the methods to test authorization using a
+// context, allowing cancellation and deadlines to reach the queries
+type ContextActionAuthorizer interface {
+	AllowsActionContext(ctx context.Context, q Querier, actor Resource, action string) (bool, error)
+	AllowsActionOnContext(ctx context.Context, q Querier, actor Resource, action string, target Resource) (bool, error)
+}
+
+// querier converts the transaction to a Querier, keeping a nil transaction nil
+func querier(tx *sql.Tx) Querier {
+	if tx == nil {
+		return nil
+	}
+
+	return tx
 }
 
 // ACL is an object managing permissions for ACO which ARO act upon
 type ACL struct {
-	table      string
-	treeTable  string
+	store      Store
 	bypassFunc func(actor Resource, action string, target Resource) bool
+	implies    map[string]map[string]struct{}
+	strategy   Strategy
+	logger     DecisionLogger
+	sampleRate float64
 }
 
+var (
+	_ ActionAuthorizer        = (*ACL)(nil)
+	_ ContextActionAuthorizer = (*ACL)(nil)
+)
+
 // NewACL creates a new ACL instance without any bypassFunc
 func New(treeTable string, table string) *ACL {
-	service := &ACL{treeTable: treeTable, table: table}
-
-	return service
+	return NewWithStore(NewPostgresStore(treeTable, table), nil)
 }
 
 // NewACLWithBypass creates a new ACL instance with a bypassFunc
 // The bypassFunc can short-circuit access control to allow actions which
 // the ACL otherwise would have disallowed (eg. editing the user's own message)
 func NewWithBypass(treeTable string, table string, bypassFunc func(actor Resource, action string, target Resource) bool) *ACL {
-	service := &ACL{treeTable: treeTable, table: table, bypassFunc: bypassFunc}
+	return NewWithStore(NewPostgresStore(treeTable, table), bypassFunc)
+}
+
+// NewWithTargetTree creates a new ACL instance without any bypassFunc which
+// also resolves permissions through the target tree in targetTreeTable, see
+// EnsureTargetTreeExists
+func NewWithTargetTree(treeTable string, targetTreeTable string, table string) *ACL {
+	return NewWithStore(NewPostgresStoreWithTargetTree(treeTable, targetTreeTable, table), nil)
+}
+
+// NewWithStore creates a new ACL instance using the given Store, bypassFunc
+// can be nil. The errors of the store are wrapped in an *Error when their kind
+// is known, see ErrCycle
+func NewWithStore(store Store, bypassFunc func(actor Resource, action string, target Resource) bool) *ACL {
+	if _, ok := store.(errorStore); !ok {
+		store = errorStore{store}
+	}
+
+	service := &ACL{store: store, bypassFunc: bypassFunc}
 
 	return service
 }
 
 // SetActionAllowed stores in the ACL if the Access Request Object is allowed to
-// perform the given action or not
-func (acl *ACL) SetActionAllowed(tx *sql.Tx, actor Resource, action string, allowed bool) error {
-	_, err := tx.Exec("INSERT INTO \""+acl.table+"\" (actor_id, action, target_id, allowed) VALUES($1, $2, $3, $4)", actor.GetId(), action, EMPTY_RESOURCE, allowed)
+// perform the given action or not. An existing setting keeps its window and
+// condition, unset it first to make it permanent
+func (acl *ACL) SetActionAllowed(q Querier, actor Resource, action string, allowed bool) error {
+	return acl.SetActionAllowedContext(context.Background(), q, actor, action, allowed)
+}
 
-	return err
+// SetActionAllowedContext is like SetActionAllowed but uses the supplied context
+func (acl *ACL) SetActionAllowedContext(ctx context.Context, q Querier, actor Resource, action string, allowed bool) error {
+	return acl.store.SetGrant(ctx, q, Grant{ActorId: actor.GetId(), Action: action, TargetId: EMPTY_RESOURCE, Allowed: allowed})
 }
 
 // UnsetActionAllowed removes access setting for the user and action, if any
-func (acl *ACL) UnsetActionAllowed(tx *sql.Tx, actor Resource, action string) error {
-	_, err := tx.Exec("DELETE FROM \""+acl.table+"\" WHERE actor_id = $1 AND action = $2 AND target_id = $3", actor.GetId(), action, EMPTY_RESOURCE)
+func (acl *ACL) UnsetActionAllowed(q Querier, actor Resource, action string) error {
+	return acl.UnsetActionAllowedContext(context.Background(), q, actor, action)
+}
 
-	return err
+// UnsetActionAllowedContext is like UnsetActionAllowed but uses the supplied context
+func (acl *ACL) UnsetActionAllowedContext(ctx context.Context, q Querier, actor Resource, action string) error {
+	return acl.store.UnsetGrant(ctx, q, actor.GetId(), action, EMPTY_RESOURCE)
 }
 
 // SetActionAllowedOn stores in the ACL if the Access Request Object is allowed to
-// perform the given action on a specific Access Control Object or not
-func (acl *ACL) SetActionAllowedOn(tx *sql.Tx, actor Resource, action string, target Resource, allowed bool) error {
-	_, err := tx.Exec("INSERT INTO \""+acl.table+"\" (actor_id, action, target_id, allowed) VALUES($1, $2, $3, $4)", actor.GetId(), action, target.GetId(), allowed)
+// perform the given action on a specific Access Control Object or not. An
+// existing setting keeps its window and condition, unset it first to make it
+// permanent
+func (acl *ACL) SetActionAllowedOn(q Querier, actor Resource, action string, target Resource, allowed bool) error {
+	return acl.SetActionAllowedOnContext(context.Background(), q, actor, action, target, allowed)
+}
 
-	return err
+// SetActionAllowedOnContext is like SetActionAllowedOn but uses the supplied context
+func (acl *ACL) SetActionAllowedOnContext(ctx context.Context, q Querier, actor Resource, action string, target Resource, allowed bool) error {
+	return acl.store.SetGrant(ctx, q, Grant{ActorId: actor.GetId(), Action: action, TargetId: target.GetId(), Allowed: allowed})
 }
 
 // UnsetActionAllowed removes access setting for the ARO and action on the
 // specific ACO, if any setting is present
-func (acl *ACL) UnsetActionAllowedOn(tx *sql.Tx, actor Resource, action string, target Resource) error {
-	_, err := tx.Exec("DELETE FROM \""+acl.table+"\" WHERE actor_id = $1 AND action = $2 AND target_id = $3", actor.GetId(), action, target.GetId())
+func (acl *ACL) UnsetActionAllowedOn(q Querier, actor Resource, action string, target Resource) error {
+	return acl.UnsetActionAllowedOnContext(context.Background(), q, actor, action, target)
+}
 
-	return err
+// UnsetActionAllowedOnContext is like UnsetActionAllowedOn but uses the supplied context
+func (acl *ACL) UnsetActionAllowedOnContext(ctx context.Context, q Querier, actor Resource, action string, target Resource) error {
+	return acl.store.UnsetGrant(ctx, q, actor.GetId(), action, target.GetId())
 }
 
 // AllowsAction returns true if the given ARO is allowed to perform action
-func (acl *ACL) AllowsAction(tx *sql.Tx, actor Resource, action string) (bool, error) {
-	target := &NilResource{}
-
-	if acl.bypassFunc != nil && acl.bypassFunc(actor, action, target) {
-		return true, nil
-	}
+func (acl *ACL) AllowsAction(q Querier, actor Resource, action string) (bool, error) {
+	return acl.AllowsActionContext(context.Background(), q, actor, action)
+}
 
-	row := tx.QueryRow(`WITH RECURSIVE q AS (
-	SELECT "parent_id", ARRAY["id"] "path", 1 "level"
-	FROM "`+acl.treeTable+`"
-	WHERE "id" = $1
-UNION ALL
-	SELECT t."parent_id", q."path" || t."id", q."level" + 1
-	FROM q
-	JOIN "`+acl.treeTable+`" t ON t."id" = q."parent_id"
-	WHERE NOT t."id" = ANY(q."path")
-)
-SELECT a."allowed"
-FROM (
-	SELECT $1 AS "id", 0 "level"
-UNION ALL
-	SELECT q."parent_id" AS "id", q."level"
-	FROM q
-) h
-JOIN "`+acl.table+`" a ON a."actor_id" = h.id
-WHERE a."action" = $2 AND a."target_id" = $3
-ORDER BY h."level" ASC, a."allowed" ASC
-LIMIT 1`, actor.GetId(), action, EMPTY_RESOURCE)
-
-	allowed := false
-	err := row.Scan(&allowed)
-
-	/* No rows is not an error, just means no permissions set */
-	if err != nil && err.Error() != "sql: no rows in result set" {
-		return false, err
-	}
+// AllowsActionContext is like AllowsAction but uses the supplied context
+func (acl *ACL) AllowsActionContext(ctx context.Context, q Querier, actor Resource, action string) (bool, error) {
+	decision, err := acl.checkAt(ctx, q, Query{}, actor, action, nil, nil)
 
-	return allowed, nil
+	return decision.Allowed, err
 }
 
 // AllowsActionOn returns true if the given ARO is allowed to perform action
 // on the given ACO
-func (acl *ACL) AllowsActionOn(tx *sql.Tx, actor Resource, action string, target Resource) (bool, error) {
-	if acl.bypassFunc != nil && acl.bypassFunc(actor, action, target) {
-		return true, nil
+func (acl *ACL) AllowsActionOn(q Querier, actor Resource, action string, target Resource) (bool, error) {
+	return acl.AllowsActionOnContext(context.Background(), q, actor, action, target)
+}
+
+// AllowsActionOnContext is like AllowsActionOn but uses the supplied context
+func (acl *ACL) AllowsActionOnContext(ctx context.Context, q Querier, actor Resource, action string, target Resource) (bool, error) {
+	decision, err := acl.checkAt(ctx, q, Query{}, actor, action, target, nil)
+
+	return decision.Allowed, err
+}
+
+// check is the path shared by the permission checks, it consults the
+// bypassFunc before calling decide and logs the outcome, see
+// WithDecisionLogger. A nil target checks the actor-wide settings
+func (acl *ACL) check(actor Resource, action string, target Resource, decide func(targetId string) (Decision, error)) (Decision, error) {
+	start := time.Now()
+	targetId := EMPTY_RESOURCE
+	loggedId := ""
+
+	if target == nil {
+		target = &NilResource{}
+	} else {
+		targetId = target.GetId()
+		loggedId = targetId
 	}
 
-	row := tx.QueryRow(`WITH RECURSIVE q AS (
-	SELECT "parent_id", ARRAY["id"] "path", 1 "level"
-	FROM "`+acl.treeTable+`"
-	WHERE "id" = $1
-UNION ALL
-	SELECT t."parent_id", q."path" || t."id", q."level" + 1
-	FROM q
-	JOIN "`+acl.treeTable+`" t ON t."id" = q."parent_id"
-	WHERE NOT t."id" = ANY(q."path")
-)
-SELECT a."allowed"
-FROM (
-	SELECT $1 AS "id", 0 "level"
-UNION ALL
-	SELECT q."parent_id" AS "id", q."level"
-	FROM q
-) h
-JOIN "`+acl.table+`" a ON a."actor_id" = h.id
-WHERE a."action" = $2 AND (a."target_id" = $3 OR target_id = $4)
-ORDER BY h."level" ASC, a."target_id" DESC, a."allowed" ASC
-LIMIT 1`, actor.GetId(), action, target.GetId(), EMPTY_RESOURCE)
-
-	allowed := false
-	err := row.Scan(&allowed)
-
-	/* No rows is not an error, just means no permissions set */
-	if err != nil && err.Error() != "sql: no rows in result set" {
-		return false, err
+	if acl.bypassFunc != nil && acl.bypassFunc(actor, action, target) {
+		acl.logDecision(start, actor.GetId(), action, loggedId, true, true, nil)
+
+		return Decision{Allowed: true, Bypassed: true}, nil
 	}
 
-	return allowed, nil
+	decision, err := decide(targetId)
+
+	acl.logDecision(start, actor.GetId(), action, loggedId, decision.Allowed, false, err)
+
+	return decision, err
 }
 
-func (acl *ACL) SetActorInherits(tx *sql.Tx, actor Resource, parentActor Resource) error {
-	/* Conditional insert, in case we have an exact duplicate row */
-	_, err := tx.Exec(`INSERT INTO "`+acl.treeTable+`" ("id", "parent_id") SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM "`+acl.treeTable+`" WHERE "id" = $3 AND "parent_id" = $4)`, actor.GetId(), parentActor.GetId(), actor.GetId(), parentActor.GetId())
+// checkAt is like check resolving the candidates at the time of the query, a
+// zero Query.At checks the current settings, see decideAt
+func (acl *ACL) checkAt(ctx context.Context, q Querier, at Query, actor Resource, action string, target Resource, attrs Attributes) (Decision, error) {
+	return acl.check(actor, action, target, func(targetId string) (Decision, error) {
+		if at.At.IsZero() {
+			at.At = time.Now()
+		}
 
-	return err
+		return acl.decideAt(ctx, q, at, actor.GetId(), action, targetId, attrs)
+	})
 }
 
-func (acl *ACL) RemoveActorInherits(tx *sql.Tx, actor Resource, parentActor Resource) error {
-	_, err := tx.Exec(`DELETE FROM "`+acl.treeTable+`" WHERE ("id", "parent_id") = ($1, $2)`, actor.GetId(), parentActor.GetId())
+// allows resolves the candidates for the check without any attributes, see
+// decide
+func (acl *ACL) allows(ctx context.Context, q Querier, actorId string, action string, targetId string) (bool, error) {
+	decision, err := acl.decide(ctx, q, actorId, action, targetId, nil)
+
+	return decision.Allowed, err
+}
 
-	return err
+// decide resolves the candidates for the check on targetId and its ancestors
+// in the target tree, EMPTY_RESOURCE as targetId checks only the actor-wide
+// rows. No candidates is not an error, just means no permissions set. The
+// conditions of the candidates are evaluated against the attributes
+func (acl *ACL) decide(ctx context.Context, q Querier, actorId string, action string, targetId string, attrs Attributes) (Decision, error) {
+	return acl.decideAt(ctx, q, Query{At: time.Now()}, actorId, action, targetId, attrs)
 }
 
-func (acl *ACL) GetActorInherits(tx *sql.Tx, actor Resource) ([]string, error) {
-	rows, err := tx.Query(`SELECT "parent_id" FROM "`+acl.treeTable+`" WHERE "id" = $1 ORDER BY "id"`, actor.GetId())
+// decideAt is like decide at the time of the query, see Query.At and
+// Query.AsOf
+func (acl *ACL) decideAt(ctx context.Context, q Querier, at Query, actorId string, action string, targetId string, attrs Attributes) (Decision, error) {
+	targets, err := acl.levelsOf(ctx, q, at, targetId)
 	if err != nil {
-		return []string{}, err
+		return Decision{}, err
 	}
 
-	var ret []string
+	return acl.decideLevels(ctx, q, at, actorId, action, targets, attrs.with(actorId, targetId))
+}
 
-	for rows.Next() {
-		str := ""
+// levelsOf maps targetId and its ancestors in the target tree to their
+// level, EMPTY_RESOURCE only maps itself, see TargetLevels
+func (acl *ACL) levelsOf(ctx context.Context, q Querier, at Query, targetId string) (map[string]int, error) {
+	if targetId == EMPTY_RESOURCE {
+		return map[string]int{EMPTY_RESOURCE: 0}, nil
+	}
 
-		rows.Scan(&str)
+	levels, err := acl.store.TargetLevels(ctx, q, []string{targetId})
+	if err != nil {
+		return nil, err
+	}
 
-		ret = append(ret, str)
+	/* Only the current target tree is known, which might not be the one of
+	   the time of the query */
+	if !at.AsOf.IsZero() && len(levels[targetId]) > 1 {
+		return nil, ErrTargetTreeUnversioned
 	}
 
-	rows.Close()
-	return ret, err
+	return levels[targetId], nil
 }
 
-func (acl *ACL) GetActorChildren(tx *sql.Tx, actor Resource) ([]string, error) {
-	rows, err := tx.Query(`SELECT "id" FROM "`+acl.treeTable+`" WHERE "parent_id" = $1 ORDER BY "id"`, actor.GetId())
+// decideLevels is like decideAt for a target with known target levels, the
+// attributes must already include actor.id and target.id
+func (acl *ACL) decideLevels(ctx context.Context, q Querier, at Query, actorId string, action string, targets map[string]int, attrs Attributes) (Decision, error) {
+	candidates, err := acl.store.Candidates(ctx, q, acl.candidatesQuery(at, actorId, action, targets))
 	if err != nil {
-		return []string{}, err
+		return Decision{}, err
+	}
+
+	return acl.resolve(candidates, action, targets, attrs)
+}
+
+// candidatesQuery returns the query of the candidates deciding the check of
+// the action on the targets at the time of at
+func (acl *ACL) candidatesQuery(at Query, actorId string, action string, targets map[string]int) Query {
+	return Query{ActorId: actorId, Actions: acl.actions(action), TargetIds: targetIds(targets), At: at.At, AsOf: at.AsOf}
+}
+
+// resolve picks the candidate deciding if action is allowed on the target
+// with the given ancestors mapped to their target level, see TargetLevels.
+// The strategy of the ACL decides between the candidates, see NearestWins.
+// Candidates for other targets and actions which do not apply through
+// implications or wildcards are skipped, as are candidates whose condition
+// does not hold for the attributes. Allows whose condition is unknown due to a
+// missing attribute are skipped too, while such denies apply
+func (acl *ACL) resolve(candidates []Candidate, action string, targets map[string]int, attrs Attributes) (Decision, error) {
+	var matching []Candidate
+
+	for _, c := range candidates {
+		actionLevel, ok := acl.actionLevel(c, action)
+		if !ok {
+			continue
+		}
+
+		c.ActionLevel = actionLevel
+
+		if level, ok := targets[c.TargetId]; ok {
+			c.TargetLevel = level
+		} else if c.TargetId != EMPTY_RESOURCE {
+			continue
+		}
+
+		holds, err := evalCondition(c.Condition, attrs)
+		if err != nil {
+			return Decision{}, err
+		}
+
+		if holds == isFalse || holds == isUnknown && c.Allowed {
+			continue
+		}
+
+		matching = append(matching, c)
 	}
 
-	var ret []string
+	if len(matching) == 0 {
+		return Decision{}, nil
+	}
 
-	for rows.Next() {
-		str := ""
+	sortCandidates(matching)
 
-		rows.Scan(&str)
+	if acl.strategy == nil {
+		return NearestWins.Resolve(matching), nil
+	}
+
+	return acl.strategy.Resolve(matching), nil
+}
 
-		ret = append(ret, str)
+// targetIds returns the ids of the target levels along with EMPTY_RESOURCE
+func targetIds(levels ...map[string]int) []string {
+	seen := map[string]struct{}{EMPTY_RESOURCE: {}}
+	ret := []string{EMPTY_RESOURCE}
+
+	for _, targets := range levels {
+		for id := range targets {
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				ret = append(ret, id)
+			}
+		}
 	}
 
-	rows.Close()
-	return ret, err
+	sort.Strings(ret)
+
+	return ret
+}
+
+// SetActorInherits makes actor inherit the permissions of parentActor, an
+// existing inheritance keeps its window
+func (acl *ACL) SetActorInherits(q Querier, actor Resource, parentActor Resource) error {
+	return acl.SetActorInheritsContext(context.Background(), q, actor, parentActor)
+}
+
+// SetActorInheritsContext is like SetActorInherits but uses the supplied context
+func (acl *ACL) SetActorInheritsContext(ctx context.Context, q Querier, actor Resource, parentActor Resource) error {
+	return acl.store.SetParent(ctx, q, actor.GetId(), parentActor.GetId(), time.Time{}, time.Time{})
+}
+
+// RemoveActorInherits removes the inheritance of parentActor from actor
+func (acl *ACL) RemoveActorInherits(q Querier, actor Resource, parentActor Resource) error {
+	return acl.RemoveActorInheritsContext(context.Background(), q, actor, parentActor)
+}
+
+// RemoveActorInheritsContext is like RemoveActorInherits but uses the supplied context
+func (acl *ACL) RemoveActorInheritsContext(ctx context.Context, q Querier, actor Resource, parentActor Resource) error {
+	return acl.store.RemoveParent(ctx, q, actor.GetId(), parentActor.GetId())
+}
+
+// GetActorInherits returns the ids of the actors the given actor directly inherits from
+func (acl *ACL) GetActorInherits(q Querier, actor Resource) ([]string, error) {
+	return acl.GetActorInheritsContext(context.Background(), q, actor)
+}
+
+// GetActorInheritsContext is like GetActorInherits but uses the supplied context
+func (acl *ACL) GetActorInheritsContext(ctx context.Context, q Querier, actor Resource) ([]string, error) {
+	return acl.store.Parents(ctx, q, actor.GetId())
+}
+
+// GetActorChildren returns the ids of the actors directly inheriting from the given actor
+func (acl *ACL) GetActorChildren(q Querier, actor Resource) ([]string, error) {
+	return acl.GetActorChildrenContext(context.Background(), q, actor)
+}
+
+// GetActorChildrenContext is like GetActorChildren but uses the supplied context
+func (acl *ACL) GetActorChildrenContext(ctx context.Context, q Querier, actor Resource) ([]string, error) {
+	return acl.store.Children(ctx, q, actor.GetId())
+}
+
+// SetTargetInherits makes target inherit the permissions set on parentTarget,
+// eg. a document inheriting from its folder
+func (acl *ACL) SetTargetInherits(q Querier, target Resource, parentTarget Resource) error {
+	return acl.SetTargetInheritsContext(context.Background(), q, target, parentTarget)
+}
+
+// SetTargetInheritsContext is like SetTargetInherits but uses the supplied context
+func (acl *ACL) SetTargetInheritsContext(ctx context.Context, q Querier, target Resource, parentTarget Resource) error {
+	return acl.store.SetTargetParent(ctx, q, target.GetId(), parentTarget.GetId())
+}
+
+// RemoveTargetInherits removes the inheritance of parentTarget from target
+func (acl *ACL) RemoveTargetInherits(q Querier, target Resource, parentTarget Resource) error {
+	return acl.RemoveTargetInheritsContext(context.Background(), q, target, parentTarget)
+}
+
+// RemoveTargetInheritsContext is like RemoveTargetInherits but uses the supplied context
+func (acl *ACL) RemoveTargetInheritsContext(ctx context.Context, q Querier, target Resource, parentTarget Resource) error {
+	return acl.store.RemoveTargetParent(ctx, q, target.GetId(), parentTarget.GetId())
 }
@@ -1,8 +1,11 @@
 package acl
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"testing"
+	"time"
 	"os"
 	"fmt"
 
@@ -28,6 +31,8 @@ func WithTransaction(db *sql.DB, f func(tx *sql.Tx)) func() {
 		So(err, ShouldBeNil)
 		_, err = tx.Exec("TRUNCATE \"ACL_TestTree\";")
 		So(err, ShouldBeNil)
+		_, err = tx.Exec("TRUNCATE \"ACL_Test_Roles\", \"ACL_Test_RoleAssignments\";")
+		So(err, ShouldBeNil)
 
 		Reset(func() {
 			defer tx.Rollback()
@@ -50,6 +55,8 @@ func WithTransactionExpectFail(db *sql.DB, f func(tx *sql.Tx)) func() {
 		So(err, ShouldBeNil)
 		_, err = tx.Exec("TRUNCATE \"ACL_TestTree\";")
 		So(err, ShouldBeNil)
+		_, err = tx.Exec("TRUNCATE \"ACL_Test_Roles\", \"ACL_Test_RoleAssignments\";")
+		So(err, ShouldBeNil)
 
 		Reset(func() {
 			defer tx.Rollback()
@@ -80,13 +87,20 @@ func TestAcl(t *testing.T) {
 		panic(err)
 	}
 
+	err = EnsureTargetTreeExists(db, "ACL_TestTargetTree", "ACL_Test", nil)
+	if err != nil {
+		panic(err)
+	}
+
 	testUserAllowed := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
 	testUserForbidden := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
 	dummyUser := idAble{id: "7be24c16-6376-478d-91c9-f879116d1d49"}
 
 	testResourceA := idAble{id: "e74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+	testResourceB := idAble{id: "b5bd4f2e-4c2e-4e5a-9f0c-0d2a3f4e6b71"}
 
 	acl := New("ACL_TestTree", "ACL_Test")
+	targetAcl := NewWithTargetTree("ACL_TestTree", "ACL_TestTargetTree", "ACL_Test")
 	aclWithBypassTrue := NewWithBypass("ACL_TestTree", "ACL_Test", func(actor Resource, action string, target Resource) bool {
 		return true
 	})
@@ -688,13 +702,25 @@ func TestAcl(t *testing.T) {
 		})
 	}))
 
+	Convey("Edges should be set like in the other stores", t, WithTransactionExpectFail(db, func(tx *sql.Tx) {
+		testTreeEdges(acl, tx, testUserAllowed, testUserForbidden)
+	}))
+
+	Convey("Candidates() should list the ancestors like in the other stores", t, WithTransactionExpectFail(db, func(tx *sql.Tx) {
+		testCandidateAncestors(acl, tx, testUserAllowed, testUserForbidden, dummyUser)
+	}))
+
+	Convey("Target tree edges should be recorded like in the other stores", t, WithTransactionExpectFail(db, func(tx *sql.Tx) {
+		testTargetTreeAudit(targetAcl, tx, testResourceA, testResourceB, dummyUser)
+	}))
+
 	Convey("When a relation exists between A -> B", t, WithTransactionExpectFail(db, func(tx *sql.Tx) {
 		err = acl.SetActorInherits(tx, testUserAllowed, testUserForbidden)
 		So(err, ShouldBeNil)
 
 		Convey("Attempting to establish B -> A should return an error", func() {
 			err := acl.SetActorInherits(tx, testUserForbidden, testUserAllowed)
-			So(err, ShouldNotBeNil)
+			So(errors.Is(err, ErrCycle), ShouldEqual, true)
 		})
 
 		Convey("Attempting to establish B -> C -> A should return an error", func() {
@@ -967,6 +993,424 @@ func TestAcl(t *testing.T) {
 		})
 	}))
 
+	Convey("When a target relation exists between A -> B", t, WithTransactionExpectFail(db, func(tx *sql.Tx) {
+		_, err := tx.Exec("TRUNCATE \"ACL_TestTargetTree\";")
+		So(err, ShouldBeNil)
+
+		err = targetAcl.SetTargetInherits(tx, testResourceA, dummyUser)
+		So(err, ShouldBeNil)
+
+		Convey("Attempting to establish B -> A should return an error", func() {
+			err := targetAcl.SetTargetInherits(tx, dummyUser, testResourceA)
+			So(err, ShouldNotBeNil)
+		})
+	}))
+
+	Convey("When a target inherits from another target", t, WithTransaction(db, func(tx *sql.Tx) {
+		/* dummyUser acts as the folder of testResourceA */
+		_, err := tx.Exec("TRUNCATE \"ACL_TestTargetTree\";")
+		So(err, ShouldBeNil)
+
+		So(targetAcl.SetTargetInherits(tx, testResourceA, dummyUser), ShouldBeNil)
+		So(targetAcl.SetActorInherits(tx, testUserAllowed, testUserForbidden), ShouldBeNil)
+
+		Convey("SetActionAllowedOn(true) on the folder should allow the target", func() {
+			So(targetAcl.SetActionAllowedOn(tx, testUserAllowed, "testing", dummyUser, true), ShouldBeNil)
+
+			allowed, err := targetAcl.AllowsActionOn(tx, testUserAllowed, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			Convey("And the ACL without target tree should not", func() {
+				allowed, err := acl.AllowsActionOn(tx, testUserAllowed, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("And SetActionAllowedOn(false) on the target should win over the folder", func() {
+				So(targetAcl.SetActionAllowedOn(tx, testUserAllowed, "testing", testResourceA, false), ShouldBeNil)
+
+				allowed, err := targetAcl.AllowsActionOn(tx, testUserAllowed, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("And SetActionAllowedOn(false) on the target for B should not win over the nearer actor", func() {
+				So(targetAcl.SetActionAllowedOn(tx, testUserForbidden, "testing", testResourceA, false), ShouldBeNil)
+
+				allowed, err := targetAcl.AllowsActionOn(tx, testUserAllowed, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("And RemoveTargetInherits() should remove the permission", func() {
+				So(targetAcl.RemoveTargetInherits(tx, testResourceA, dummyUser), ShouldBeNil)
+
+				allowed, err := targetAcl.AllowsActionOn(tx, testUserAllowed, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+		})
+	}))
+
+	Convey("When actions imply other actions", t, WithTransaction(db, func(tx *sql.Tx) {
+		implyingAcl := acl.WithImplications(Implications{"admin": {"edit"}, "edit": {"view"}})
+
+		So(implyingAcl.SetActionAllowed(tx, testUserAllowed, "admin", true), ShouldBeNil)
+
+		Convey("An allow on admin should allow view", func() {
+			allowed, err := implyingAcl.AllowsActionOn(tx, testUserAllowed, "view", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+
+		Convey("A deny on view should block edit", func() {
+			So(implyingAcl.SetActionAllowed(tx, testUserAllowed, "view", false), ShouldBeNil)
+
+			allowed, err := implyingAcl.AllowsAction(tx, testUserAllowed, "edit")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+	}))
+
+	Convey("When using wildcard actions", t, WithTransaction(db, func(tx *sql.Tx) {
+		So(acl.SetActionAllowed(tx, testUserForbidden, "*", false), ShouldBeNil)
+		So(acl.SetActionAllowed(tx, testUserForbidden, "document:*", true), ShouldBeNil)
+		So(acl.SetActionAllowed(tx, testUserForbidden, "document:delete", false), ShouldBeNil)
+
+		Convey("The most specific action should win on the same level", func() {
+			for action, expected := range map[string]bool{"document:edit": true, "document:delete": false, "billing:read": false} {
+				allowed, err := acl.AllowsActionOn(tx, testUserForbidden, action, testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, expected)
+			}
+		})
+	}))
+
+	Convey("When using the context-aware methods", t, WithTransaction(db, func(tx *sql.Tx) {
+		ctx := context.Background()
+
+		err := acl.SetActorInheritsContext(ctx, tx, testUserAllowed, testUserForbidden)
+		So(err, ShouldBeNil)
+
+		err = acl.SetActionAllowedContext(ctx, tx, testUserForbidden, "testing", true)
+		So(err, ShouldBeNil)
+
+		err = acl.SetActionAllowedOnContext(ctx, tx, testUserAllowed, "testing", testResourceA, false)
+		So(err, ShouldBeNil)
+
+		Convey("They should behave like the plain methods", func() {
+			allowed, err := acl.AllowsActionContext(ctx, tx, testUserAllowed, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionOnContext(ctx, tx, testUserAllowed, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			parents, err := acl.GetActorInheritsContext(ctx, tx, testUserAllowed)
+			So(err, ShouldBeNil)
+			So(parents, ShouldResemble, []string{testUserForbidden.GetId()})
+
+			children, err := acl.GetActorChildrenContext(ctx, tx, testUserForbidden)
+			So(err, ShouldBeNil)
+			So(children, ShouldResemble, []string{testUserAllowed.GetId()})
+		})
+
+		Convey("A cancelled context should return false and error", func() {
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+
+			allowed, err := acl.AllowsActionContext(cancelled, tx, testUserAllowed, "testing")
+			So(err, ShouldEqual, context.Canceled)
+			So(allowed, ShouldEqual, false)
+
+			allowed, err = acl.AllowsActionOnContext(cancelled, tx, testUserAllowed, "testing", testResourceA)
+			So(err, ShouldEqual, context.Canceled)
+			So(allowed, ShouldEqual, false)
+		})
+	}))
+
+	Convey("When explaining a decision", t, WithTransaction(db, func(tx *sql.Tx) {
+		ctx := context.Background()
+
+		So(acl.SetActorInherits(tx, testUserAllowed, testUserForbidden), ShouldBeNil)
+		So(acl.SetActorInherits(tx, testUserForbidden, dummyUser), ShouldBeNil)
+		So(acl.SetActionAllowed(tx, dummyUser, "testing", true), ShouldBeNil)
+		So(acl.SetActionAllowedOn(tx, testUserForbidden, "testing", testResourceA, false), ShouldBeNil)
+
+		Convey("ExplainActionOn() should return the deciding row and its path", func() {
+			decision, err := acl.ExplainActionOn(ctx, tx, testUserAllowed, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(decision.Allowed, ShouldEqual, false)
+			So(decision.Bypassed, ShouldEqual, false)
+			So(decision.Source, ShouldResemble, &Candidate{
+				Grant: Grant{ActorId: testUserForbidden.GetId(), Action: "testing", TargetId: testResourceA.GetId(), Allowed: false},
+				Level: 1,
+				Path:  []string{testUserAllowed.GetId(), testUserForbidden.GetId()},
+			})
+			So(decision.Shadowed, ShouldResemble, []Candidate{{
+				Grant: Grant{ActorId: dummyUser.GetId(), Action: "testing", TargetId: EMPTY_RESOURCE, Allowed: true},
+				Level: 2,
+				Path:  []string{testUserAllowed.GetId(), testUserForbidden.GetId(), dummyUser.GetId()},
+			}})
+		})
+
+		Convey("ExplainAction() should report the bypassFunc", func() {
+			decision, err := aclWithBypassTrue.ExplainAction(ctx, tx, testUserAllowed, "testing")
+			So(err, ShouldBeNil)
+			So(decision.Allowed, ShouldEqual, true)
+			So(decision.Bypassed, ShouldEqual, true)
+			So(decision.Source, ShouldBeNil)
+		})
+	}))
+
+	Convey("When checking many targets at once", t, WithTransaction(db, func(tx *sql.Tx) {
+		ctx := context.Background()
+
+		So(acl.SetActorInherits(tx, testUserAllowed, testUserForbidden), ShouldBeNil)
+		So(acl.SetActionAllowed(tx, testUserForbidden, "testing", true), ShouldBeNil)
+		So(acl.SetActionAllowedOn(tx, testUserAllowed, "testing", testResourceA, false), ShouldBeNil)
+
+		Convey("AllowsActionOnMany() should resolve every target in one query", func() {
+			allowed, err := acl.AllowsActionOnMany(ctx, tx, testUserAllowed, "testing", []Resource{testResourceA, dummyUser})
+			So(err, ShouldBeNil)
+			So(allowed, ShouldResemble, map[string]bool{testResourceA.GetId(): false, dummyUser.GetId(): true})
+		})
+
+		Convey("AllowsActionsOnMany() should resolve every action and target pair", func() {
+			allowed, err := acl.AllowsActionsOnMany(ctx, tx, testUserAllowed, []string{"testing", "other"}, []Resource{testResourceA, dummyUser})
+			So(err, ShouldBeNil)
+			So(allowed, ShouldResemble, map[string]map[string]bool{
+				testResourceA.GetId(): {"testing": false, "other": false},
+				dummyUser.GetId():     {"testing": true, "other": false},
+			})
+		})
+	}))
+
+	Convey("When listing the targets of an actor", t, WithTransaction(db, func(tx *sql.Tx) {
+		ctx := context.Background()
+
+		So(acl.SetActorInherits(tx, testUserAllowed, testUserForbidden), ShouldBeNil)
+		So(acl.SetActionAllowedOn(tx, testUserForbidden, "testing", testResourceA, true), ShouldBeNil)
+		So(acl.SetActionAllowedOn(tx, testUserForbidden, "testing", dummyUser, true), ShouldBeNil)
+		So(acl.SetActionAllowedOn(tx, testUserAllowed, "testing", dummyUser, false), ShouldBeNil)
+
+		Convey("ListTargets() should list the allowed targets", func() {
+			list, err := acl.ListTargets(ctx, tx, testUserAllowed, "testing", Page{})
+			So(err, ShouldBeNil)
+			So(list, ShouldResemble, TargetList{Ids: []string{testResourceA.GetId()}})
+		})
+
+		Convey("ListTargets() should list the exceptions of an actor-wide allow", func() {
+			So(acl.SetActionAllowed(tx, testUserForbidden, "testing", true), ShouldBeNil)
+
+			list, err := acl.ListTargets(ctx, tx, testUserAllowed, "testing", Page{})
+			So(err, ShouldBeNil)
+			So(list, ShouldResemble, TargetList{All: true, Except: []string{dummyUser.GetId()}})
+		})
+
+		Convey("ListActors() should list the actors allowed through inheritance", func() {
+			actors, err := acl.ListActors(ctx, tx, dummyUser, "testing", false)
+			So(err, ShouldBeNil)
+			So(actors, ShouldResemble, []string{testUserForbidden.GetId()})
+
+			actors, err = acl.ListActors(ctx, tx, testResourceA, "testing", true)
+			So(err, ShouldBeNil)
+			So(actors, ShouldResemble, []string{testUserAllowed.GetId()})
+		})
+	}))
+
+	Convey("When listing the effective permissions of an actor", t, WithTransaction(db, func(tx *sql.Tx) {
+		So(acl.SetActorInherits(tx, testUserAllowed, testUserForbidden), ShouldBeNil)
+		So(acl.SetActionAllowed(tx, testUserForbidden, "testing", true), ShouldBeNil)
+		So(acl.SetActionAllowedOn(tx, testUserAllowed, "testing", testResourceA, false), ShouldBeNil)
+
+		Convey("EffectivePermissions() should combine the group grant and the override", func() {
+			permissions, err := acl.EffectivePermissions(context.Background(), tx, testUserAllowed)
+			So(err, ShouldBeNil)
+			So(len(permissions), ShouldEqual, 2)
+			So(permissions[0].TargetId, ShouldEqual, EMPTY_RESOURCE)
+			So(permissions[0].Allowed, ShouldEqual, true)
+			So(permissions[0].Source.Path, ShouldResemble, []string{testUserAllowed.GetId(), testUserForbidden.GetId()})
+			So(permissions[1].TargetId, ShouldEqual, testResourceA.GetId())
+			So(permissions[1].Allowed, ShouldEqual, false)
+			So(permissions[1].Source.ActorId, ShouldEqual, testUserAllowed.GetId())
+		})
+	}))
+
+	Convey("When assigning roles", t, WithTransaction(db, func(tx *sql.Tx) {
+		So(acl.SetActorInherits(tx, testUserAllowed, testUserForbidden), ShouldBeNil)
+		So(acl.SetRoleActions(tx, "editor", []string{"view", "edit"}), ShouldBeNil)
+		So(acl.AssignRole(tx, testUserForbidden, "editor", testResourceA), ShouldBeNil)
+		So(acl.AssignRole(tx, testUserForbidden, "editor", testResourceA), ShouldBeNil)
+
+		Convey("AllowsActionOn() should allow the actions of the role through the parent", func() {
+			allowed, err := acl.AllowsActionOn(tx, testUserAllowed, "edit", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionOn(tx, testUserAllowed, "delete", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("A direct deny on the same actor should take precedence over the role", func() {
+			So(acl.SetActionAllowedOn(tx, testUserForbidden, "edit", testResourceA, false), ShouldBeNil)
+
+			decision, err := acl.ExplainActionOn(context.Background(), tx, testUserAllowed, "edit", testResourceA)
+			So(err, ShouldBeNil)
+			So(decision.Allowed, ShouldEqual, false)
+			So(decision.Shadowed[0].Role, ShouldEqual, "editor")
+		})
+
+		Convey("SetRoleActions() should change the actions of every assignment", func() {
+			So(acl.SetRoleActions(tx, "editor", []string{"view"}), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOn(tx, testUserAllowed, "edit", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("RevokeRole() should remove the actions", func() {
+			So(acl.RevokeRole(tx, testUserForbidden, "editor", testResourceA), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOn(tx, testUserAllowed, "view", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+	}))
+
+	Convey("When settings and inheritance expire", t, WithTransaction(db, func(tx *sql.Tx) {
+		So(acl.SetActionAllowed(tx, testUserForbidden, "testing", true), ShouldBeNil)
+		So(acl.SetActorInheritsUntil(tx, testUserAllowed, testUserForbidden, time.Now().Add(time.Hour)), ShouldBeNil)
+
+		Convey("AllowsAction() should follow the inheritance while it is valid", func() {
+			allowed, err := acl.AllowsAction(tx, testUserAllowed, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+
+		Convey("AllowsAction() should ignore the inheritance once it expired", func() {
+			So(acl.SetActorInheritsUntil(tx, testUserAllowed, testUserForbidden, time.Now().Add(-time.Hour)), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(tx, testUserAllowed, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("An expired deny should not shadow the inherited allow", func() {
+			So(acl.SetActionAllowedOnUntil(tx, testUserAllowed, "testing", testResourceA, false, time.Now().Add(-time.Hour)), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOn(tx, testUserAllowed, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			Convey("And RemoveExpired() should remove it", func() {
+				n, err := acl.RemoveExpired(context.Background(), tx)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, int64(1))
+			})
+		})
+
+		Convey("A setting which is not yet valid should be ignored", func() {
+			So(acl.SetActionAllowedBetween(tx, testUserAllowed, "testing", false, time.Now().Add(time.Hour), time.Time{}), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(tx, testUserAllowed, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+	}))
+
+	Convey("When a setting has a condition", t, WithTransaction(db, func(tx *sql.Tx) {
+		So(acl.SetActionAllowed(tx, testUserForbidden, "testing", true), ShouldBeNil)
+		So(acl.SetActorInherits(tx, testUserAllowed, testUserForbidden), ShouldBeNil)
+		So(acl.SetActionAllowedOnIf(tx, testUserAllowed, "testing", testResourceA, false, `request.ip in 192.168.0.0/16`), ShouldBeNil)
+
+		Convey("AllowsActionOnWith() should apply it when the condition holds", func() {
+			allowed, err := acl.AllowsActionOnWith(context.Background(), tx, testUserAllowed, "testing", testResourceA, Attributes{"request.ip": "192.168.1.10"})
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("AllowsActionOnWith() should fall back to the inherited allow when it does not", func() {
+			allowed, err := acl.AllowsActionOnWith(context.Background(), tx, testUserAllowed, "testing", testResourceA, Attributes{"request.ip": "10.0.0.1"})
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+	}))
+
+	Convey("When changing the ACL with WithChangedBy()", t, WithTransaction(db, func(tx *sql.Tx) {
+		start := time.Now().Add(-time.Second)
+		ctx := WithChangedBy(context.Background(), testUserAllowed)
+
+		So(acl.SetActionAllowedOnContext(ctx, tx, testUserForbidden, "testing", testResourceA, true), ShouldBeNil)
+		So(acl.UnsetActionAllowedOnContext(ctx, tx, testUserForbidden, "testing", testResourceA), ShouldBeNil)
+
+		Convey("AuditLog() should return the changes along with the actor making them", func() {
+			entries, err := acl.AuditLog(context.Background(), tx, AuditQuery{ActorId: testUserForbidden.GetId(), TargetId: testResourceA.GetId(), From: start})
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 2)
+			So(entries[0].Operation, ShouldEqual, "INSERT")
+			So(entries[0].ChangedBy, ShouldEqual, testUserAllowed.GetId())
+			So(entries[0].New["allowed"], ShouldEqual, true)
+			So(entries[1].Operation, ShouldEqual, "DELETE")
+			So(entries[1].Old["action"], ShouldEqual, "testing")
+		})
+
+		Convey("Changes without WithChangedBy() should not record the actor", func() {
+			So(acl.SetActionAllowed(tx, testUserForbidden, "testing", true), ShouldBeNil)
+
+			entries, err := acl.AuditLog(context.Background(), tx, AuditQuery{ActorId: testUserForbidden.GetId(), From: start})
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 3)
+			So(entries[2].ChangedBy, ShouldEqual, "")
+		})
+	}))
+
+	Convey("When a setting changes within the transaction", t, WithTransaction(db, func(tx *sql.Tx) {
+		So(acl.SetActionAllowedOn(tx, testUserAllowed, "delete", testResourceA, true), ShouldBeNil)
+		allowedAt := time.Now()
+		So(acl.SetActionAllowedOn(tx, testUserAllowed, "delete", testResourceA, false), ShouldBeNil)
+
+		Convey("AllowsActionOnAt() should use the version current at the time", func() {
+			allowed, err := acl.AllowsActionOnAt(tx, testUserAllowed, "delete", testResourceA, allowedAt)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionOnAt(tx, testUserAllowed, "delete", testResourceA, time.Now())
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+	}))
+
+	Convey("When not using a transaction", t, func() {
+		Convey("AllowsAction() and AllowsActionOn() should accept a *sql.DB", func() {
+			allowed, err := acl.AllowsAction(db, dummyUser, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			allowed, err = acl.AllowsActionOn(db, dummyUser, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("AllowsAction() and AllowsActionOn() should accept a *sql.Conn", func() {
+			conn, err := db.Conn(context.Background())
+			So(err, ShouldBeNil)
+
+			defer conn.Close()
+
+			allowed, err := acl.AllowsAction(conn, dummyUser, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			allowed, err = acl.AllowsActionOn(conn, dummyUser, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+	})
+
 	/* TODO: Tests for 3 levels of permissions, to make sure intermediate levels are taken into account */
 	/* TODO: More for ARO hierarchy, combine levels with generic and resource specific permissions */
 }
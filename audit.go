@@ -0,0 +1,279 @@
+package acl
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	// AuditGrant is the kind of the audit entries of rows in the ACL table
+	AuditGrant = "grant"
+	// AuditInheritance is the kind of the audit entries of actor inheritances
+	AuditInheritance = "inheritance"
+	// AuditRoleAction is the kind of the audit entries of the actions of roles
+	AuditRoleAction = "role_action"
+	// AuditRoleAssignment is the kind of the audit entries of role assignments
+	AuditRoleAssignment = "role_assignment"
+	// AuditTargetInheritance is the kind of the audit entries of target
+	// inheritances
+	AuditTargetInheritance = "target_inheritance"
+)
+
+// AuditEntry is a change to the ACL recorded in the audit log
+type AuditEntry struct {
+	// Id orders the entries, later changes have greater ids
+	Id int64
+	// ChangedAt is when the change was made
+	ChangedAt time.Time
+	// ChangedBy is the id of the actor which made the change, see
+	// WithChangedBy, empty if unknown
+	ChangedBy string
+	// Kind is the kind of the changed row, eg. AuditGrant
+	Kind string
+	// Operation is INSERT, UPDATE or DELETE
+	Operation string
+	// ActorId is the actor of the changed row, the inheriting actor for
+	// inheritances and empty for the actions of roles and target inheritances
+	ActorId string
+	// Action is the action of the changed row, empty for inheritances and
+	// role assignments
+	Action string
+	// TargetId is the target of the changed row, the inheriting target for
+	// target inheritances and empty for inheritances and the actions of roles
+	TargetId string
+	// Old is the row before the change by column, nil for an INSERT
+	Old map[string]interface{}
+	// New is the row after the change by column, nil for a DELETE
+	New map[string]interface{}
+}
+
+// AuditQuery selects entries of the audit log, empty fields match any entry
+type AuditQuery struct {
+	ActorId  string
+	Action   string
+	TargetId string
+	// From selects the entries changed at or after the time
+	From time.Time
+	// Until selects the entries changed before the time
+	Until time.Time
+	// After selects the entries with a greater id, for pagination
+	After int64
+	// Limit is the maximum number of entries, 0 means no limit
+	Limit int
+}
+
+// AuditLog returns the entries of the audit log matching the query, ordered
+// by id. The log is filled by triggers, so it also contains the changes made
+// to the tables by other means than the ACL
+func (acl *ACL) AuditLog(ctx context.Context, q Querier, query AuditQuery) ([]AuditEntry, error) {
+	return acl.store.AuditLog(ctx, q, query)
+}
+
+type changedByKey struct{}
+
+// WithChangedBy returns a copy of the context which makes the changes to the
+// ACL using it recorded as made by actor in the audit log. Recording it
+// requires a transaction, changes made with a *sql.DB or *sql.Conn are made in
+// a transaction of their own
+func WithChangedBy(ctx context.Context, actor Resource) context.Context {
+	return context.WithValue(ctx, changedByKey{}, actor.GetId())
+}
+
+// changedBy returns the id of the actor set by WithChangedBy, if any
+func changedBy(ctx context.Context) string {
+	id, _ := ctx.Value(changedByKey{}).(string)
+
+	return id
+}
+
+// withChangedBy runs f, recording the actor set by WithChangedBy in the
+// transaction for the duration of f by running set with the id of the actor,
+// and then with an empty string
+func withChangedBy(ctx context.Context, q Querier, set string, f func(q Querier) error) error {
+	id := changedBy(ctx)
+	if id == "" {
+		return f(q)
+	}
+
+	run := func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, set, id)
+		if err != nil {
+			return err
+		}
+
+		err = f(tx)
+		if err != nil {
+			/* Best effort, the transaction might be aborted */
+			tx.ExecContext(ctx, set, "")
+
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, set, "")
+
+		return err
+	}
+
+	if tx, ok := q.(*sql.Tx); ok {
+		return run(tx)
+	}
+
+	db, ok := q.(interface {
+		BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+	})
+	if !ok {
+		return errors.New("acl: recording the changed by requires a *sql.Tx, *sql.Conn or *sql.DB")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	err = run(tx)
+	if err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// auditedTable is a table recorded in the audit log and the history tables,
+// actor, action and target name the columns of the entry fields of the same
+// name, if any, and keys the columns of the primary key
+type auditedTable struct {
+	table   string
+	kind    string
+	actor   string
+	action  string
+	target  string
+	columns []string
+	keys    []string
+}
+
+// auditedTables returns the tables recorded in the audit log of the ACL table
+func auditedTables(treeTable string, table string) []auditedTable {
+	return []auditedTable{
+		{table, AuditGrant, "actor_id", "action", "target_id", []string{"actor_id", "action", "target_id", "allowed", "valid_from", "valid_until", "condition"}, []string{"actor_id", "action", "target_id"}},
+		{treeTable, AuditInheritance, "id", "", "", []string{"id", "parent_id", "valid_from", "valid_until"}, []string{"id", "parent_id"}},
+		{table + "_Roles", AuditRoleAction, "", "action", "", []string{"role", "action"}, []string{"role", "action"}},
+		{table + "_RoleAssignments", AuditRoleAssignment, "actor_id", "", "target_id", []string{"actor_id", "role", "target_id"}, []string{"actor_id", "role", "target_id"}},
+	}
+}
+
+// targetTreeAudit returns the target tree table as recorded in the audit log
+// of the ACL table, it has no history as the target tree is not versioned
+func targetTreeAudit(targetTreeTable string) auditedTable {
+	return auditedTable{targetTreeTable, AuditTargetInheritance, "", "", "id", []string{"id", "parent_id"}, []string{"id", "parent_id"}}
+}
+
+// auditRow decodes a row of the audit log from JSON, normalizing the allowed
+// column to a bool as not every database has JSON booleans
+func auditRow(data sql.NullString) (map[string]interface{}, error) {
+	if !data.Valid {
+		return nil, nil
+	}
+
+	var ret map[string]interface{}
+
+	err := json.Unmarshal([]byte(data.String), &ret)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, ok := ret["allowed"].(float64); ok {
+		ret["allowed"] = n != 0
+	}
+
+	return ret, nil
+}
+
+// auditWhere returns an SQL condition matching the entries selected by the
+// query along with its arguments, using the placeholder function for the
+// n:th argument and the id and time functions to convert ids and times to
+// arguments
+func auditWhere(query AuditQuery, placeholder func(n int) string, idArg func(id string) interface{}, timeArg func(t time.Time) interface{}) (string, []interface{}) {
+	where := "TRUE"
+	args := []interface{}{}
+
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		where += " AND " + condition + placeholder(len(args))
+	}
+
+	if query.ActorId != "" {
+		add("actor_id = ", idArg(query.ActorId))
+	}
+
+	if query.Action != "" {
+		add("action = ", query.Action)
+	}
+
+	if query.TargetId != "" {
+		add("target_id = ", idArg(query.TargetId))
+	}
+
+	if !query.From.IsZero() {
+		add("changed_at >= ", timeArg(query.From))
+	}
+
+	if !query.Until.IsZero() {
+		add("changed_at < ", timeArg(query.Until))
+	}
+
+	if query.After != 0 {
+		add("id > ", query.After)
+	}
+
+	return where, args
+}
+
+// queryAuditLog runs the query selecting the columns of the audit log in the
+// order of AuditEntry, parsing changed_at with parseTime
+func queryAuditLog(ctx context.Context, q Querier, query string, parseTime func(s string) (time.Time, error), args ...interface{}) ([]AuditEntry, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ret := []AuditEntry{}
+
+	for rows.Next() {
+		var e AuditEntry
+		var changedAt string
+		var changedBy, actorId, action, targetId, oldRow, newRow sql.NullString
+
+		err = rows.Scan(&e.Id, &changedAt, &changedBy, &e.Kind, &e.Operation, &actorId, &action, &targetId, &oldRow, &newRow)
+		if err != nil {
+			return nil, err
+		}
+
+		e.ChangedAt, err = parseTime(changedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		e.ChangedBy, e.ActorId, e.Action, e.TargetId = changedBy.String, actorId.String, action.String, targetId.String
+
+		e.Old, err = auditRow(oldRow)
+		if err != nil {
+			return nil, err
+		}
+
+		e.New, err = auditRow(newRow)
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, e)
+	}
+
+	return ret, rows.Err()
+}
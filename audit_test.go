@@ -0,0 +1,150 @@
+package acl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+	testResourceB := idAble{id: "b5bd4f2e-4c2e-4e5a-9f0c-0d2a3f4e6b71"}
+
+	Convey("When an admin changes the ACL", t, func() {
+		acl := NewMemory()
+		adminCtx := WithChangedBy(ctx, userB)
+
+		So(acl.SetActionAllowedOnContext(adminCtx, nil, userA, "testing", testResourceA, true), ShouldBeNil)
+		So(acl.SetActionAllowedOnContext(adminCtx, nil, userA, "testing", testResourceA, false), ShouldBeNil)
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+		So(acl.SetRoleActions(nil, "editor", []string{"edit"}), ShouldBeNil)
+		So(acl.AssignRole(nil, userA, "editor", testResourceA), ShouldBeNil)
+
+		Convey("AuditLog() should return every change in order", func() {
+			entries, err := acl.AuditLog(ctx, nil, AuditQuery{})
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 5)
+
+			for i, expected := range []struct{ kind, operation string }{
+				{AuditGrant, "INSERT"},
+				{AuditGrant, "UPDATE"},
+				{AuditInheritance, "INSERT"},
+				{AuditRoleAction, "INSERT"},
+				{AuditRoleAssignment, "INSERT"},
+			} {
+				So(entries[i].Id, ShouldEqual, int64(i+1))
+				So(entries[i].Kind, ShouldEqual, expected.kind)
+				So(entries[i].Operation, ShouldEqual, expected.operation)
+			}
+		})
+
+		Convey("AuditLog() should record the actor making the change along with the rows", func() {
+			entries, err := acl.AuditLog(ctx, nil, AuditQuery{Action: "testing"})
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 2)
+			So(entries[1].ChangedBy, ShouldEqual, userB.GetId())
+			So(entries[1].ActorId, ShouldEqual, userA.GetId())
+			So(entries[1].TargetId, ShouldEqual, testResourceA.GetId())
+			So(entries[1].Old["allowed"], ShouldEqual, true)
+			So(entries[1].New["allowed"], ShouldEqual, false)
+		})
+
+		Convey("AuditLog() should not record an actor without WithChangedBy()", func() {
+			entries, err := acl.AuditLog(ctx, nil, AuditQuery{ActorId: userA.GetId(), After: 2, Limit: 1})
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Kind, ShouldEqual, AuditInheritance)
+			So(entries[0].ChangedBy, ShouldEqual, "")
+			So(entries[0].New["parent_id"], ShouldEqual, userB.GetId())
+		})
+
+		Convey("AuditLog() should filter by the time of the change", func() {
+			entries, err := acl.AuditLog(ctx, nil, AuditQuery{Until: time.Now().Add(-time.Hour)})
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 0)
+
+			entries, err = acl.AuditLog(ctx, nil, AuditQuery{From: time.Now().Add(-time.Hour)})
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 5)
+		})
+
+		Convey("RemoveExpired() should record the removed rows", func() {
+			So(acl.SetActionAllowedUntil(nil, userB, "testing", true, time.Now().Add(-time.Minute)), ShouldBeNil)
+
+			_, err := acl.RemoveExpired(ctx, nil)
+			So(err, ShouldBeNil)
+
+			entries, err := acl.AuditLog(ctx, nil, AuditQuery{ActorId: userB.GetId()})
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 2)
+			So(entries[1].Operation, ShouldEqual, "DELETE")
+		})
+	})
+
+	Convey("Edges should be set like in the SQL stores", t, func() {
+		testTreeEdges(NewMemory(), nil, userA, userB)
+	})
+
+	Convey("Target tree edges should be recorded like in the SQL stores", t, func() {
+		testTargetTreeAudit(NewMemory(), nil, testResourceA, testResourceB, userB)
+	})
+}
+
+// testTreeEdges checks the edges like every Store has to treat them: setting
+// an existing edge again is not recorded and an actor cannot inherit from
+// itself. The latter is checked last as it aborts a Postgres transaction
+func testTreeEdges(acl *ACL, q Querier, actor Resource, parent Resource) {
+	ctx := context.Background()
+
+	So(acl.SetActorInherits(q, actor, parent), ShouldBeNil)
+
+	before, err := acl.AuditLog(ctx, q, AuditQuery{ActorId: actor.GetId()})
+	So(err, ShouldBeNil)
+
+	So(acl.SetActorInherits(q, actor, parent), ShouldBeNil)
+
+	after, err := acl.AuditLog(ctx, q, AuditQuery{ActorId: actor.GetId()})
+	So(err, ShouldBeNil)
+	So(len(after), ShouldEqual, len(before))
+
+	err = acl.SetActorInherits(q, actor, actor)
+	So(errors.Is(err, ErrCycle), ShouldEqual, true)
+}
+
+// testTargetTreeAudit checks that the changes of the target tree are recorded
+// like every Store has to record them, setting an existing edge again is not
+func testTargetTreeAudit(acl *ACL, q Querier, target Resource, parent Resource, admin Resource) {
+	ctx := WithChangedBy(context.Background(), admin)
+
+	before, err := acl.AuditLog(ctx, q, AuditQuery{TargetId: target.GetId()})
+	So(err, ShouldBeNil)
+
+	So(acl.SetTargetInheritsContext(ctx, q, target, parent), ShouldBeNil)
+	So(acl.SetTargetInheritsContext(ctx, q, target, parent), ShouldBeNil)
+	So(acl.RemoveTargetInheritsContext(ctx, q, target, parent), ShouldBeNil)
+
+	after, err := acl.AuditLog(ctx, q, AuditQuery{TargetId: target.GetId()})
+	So(err, ShouldBeNil)
+	So(len(after), ShouldEqual, len(before)+2)
+
+	entries := after[len(before):]
+
+	for i, operation := range []string{"INSERT", "DELETE"} {
+		So(entries[i].Kind, ShouldEqual, AuditTargetInheritance)
+		So(entries[i].Operation, ShouldEqual, operation)
+		So(entries[i].ChangedBy, ShouldEqual, admin.GetId())
+		So(entries[i].ActorId, ShouldEqual, "")
+		So(entries[i].TargetId, ShouldEqual, target.GetId())
+	}
+
+	So(entries[0].New["parent_id"], ShouldEqual, parent.GetId())
+	So(entries[1].Old["parent_id"], ShouldEqual, parent.GetId())
+}
@@ -0,0 +1,72 @@
+package acl
+
+import (
+	"context"
+	"time"
+)
+
+// AllowsActionOnMany is like AllowsActionOnContext for a list of targets, the
+// result is keyed by target id. All targets are resolved together, see
+// AllowsActionsOnMany
+func (acl *ACL) AllowsActionOnMany(ctx context.Context, q Querier, actor Resource, action string, targets []Resource) (map[string]bool, error) {
+	allowed, err := acl.AllowsActionsOnMany(ctx, q, actor, []string{action}, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]bool, len(allowed))
+
+	for targetId, actions := range allowed {
+		ret[targetId] = actions[action]
+	}
+
+	return ret, nil
+}
+
+// AllowsActionsOnMany is like AllowsActionOnMany for a list of actions, the
+// result is keyed by target id and then by action. All pairs are resolved
+// using a single query, while every pair is still checked like
+// AllowsActionOnContext by the bypassFunc and logged as a decision, see
+// WithDecisionLogger
+func (acl *ACL) AllowsActionsOnMany(ctx context.Context, q Querier, actor Resource, actions []string, targets []Resource) (map[string]map[string]bool, error) {
+	ids := make([]string, 0, len(targets))
+
+	for _, target := range targets {
+		ids = append(ids, target.GetId())
+	}
+
+	var candidates []Candidate
+	var levels map[string]map[string]int
+	fetched := false
+
+	ret := make(map[string]map[string]bool, len(targets))
+
+	for _, target := range targets {
+		ret[target.GetId()] = make(map[string]bool, len(actions))
+
+		for _, action := range actions {
+			decision, err := acl.check(actor, action, target, func(targetId string) (Decision, error) {
+				/* Fetched for the first pair which is not bypassed */
+				if !fetched {
+					var err error
+
+					candidates, levels, err = acl.store.TargetCandidates(ctx, q, Query{ActorId: actor.GetId(), Actions: acl.actions(actions...), TargetIds: ids, At: time.Now()})
+					if err != nil {
+						return Decision{}, err
+					}
+
+					fetched = true
+				}
+
+				return acl.resolve(candidates, action, levels[targetId], Attributes(nil).with(actor.GetId(), targetId))
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			ret[target.GetId()][action] = decision.Allowed
+		}
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,108 @@
+package acl
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "e74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+	testResourceB := idAble{id: "5c5a6a1e-7a1b-4b3d-9a55-3f0f1c1f9f10"}
+	testResourceC := idAble{id: "b1d0f7a4-0f3e-4c1b-8f0e-2d4c6a8e9b21"}
+
+	Convey("When A inherits from B", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+		So(acl.SetActionAllowed(nil, userB, "read", true), ShouldBeNil)
+		So(acl.SetActionAllowedOn(nil, userB, "read", testResourceB, false), ShouldBeNil)
+		So(acl.SetActionAllowedOn(nil, userA, "edit", testResourceA, true), ShouldBeNil)
+
+		Convey("AllowsActionOnMany() should resolve every target like AllowsActionOn()", func() {
+			allowed, err := acl.AllowsActionOnMany(ctx, nil, userA, "read", []Resource{testResourceA, testResourceB, testResourceC})
+			So(err, ShouldBeNil)
+			So(allowed, ShouldResemble, map[string]bool{
+				testResourceA.GetId(): true,
+				testResourceB.GetId(): false,
+				testResourceC.GetId(): true,
+			})
+
+			for _, target := range []Resource{testResourceA, testResourceB, testResourceC} {
+				single, err := acl.AllowsActionOn(nil, userA, "read", target)
+				So(err, ShouldBeNil)
+				So(allowed[target.GetId()], ShouldEqual, single)
+			}
+		})
+
+		Convey("AllowsActionsOnMany() should resolve every action and target pair", func() {
+			allowed, err := acl.AllowsActionsOnMany(ctx, nil, userA, []string{"read", "edit"}, []Resource{testResourceA, testResourceB})
+			So(err, ShouldBeNil)
+			So(allowed, ShouldResemble, map[string]map[string]bool{
+				testResourceA.GetId(): {"read": true, "edit": true},
+				testResourceB.GetId(): {"read": false, "edit": false},
+			})
+		})
+
+		Convey("Every pair should be resolved from a single query", func() {
+			store := &queryCountingStore{Store: acl.store}
+
+			_, err := NewWithStore(store, nil).AllowsActionsOnMany(ctx, nil, userA, []string{"read", "edit"}, []Resource{testResourceA, testResourceB, testResourceC})
+			So(err, ShouldBeNil)
+			So(store.queries, ShouldEqual, 1)
+		})
+
+		Convey("An empty list of targets should return an empty result", func() {
+			allowed, err := acl.AllowsActionOnMany(ctx, nil, userA, "read", nil)
+			So(err, ShouldBeNil)
+			So(len(allowed), ShouldEqual, 0)
+		})
+
+		Convey("The bypassFunc should be called for every pair", func() {
+			calls := 0
+			acl := NewWithStore(acl.store, func(actor Resource, action string, target Resource) bool {
+				calls++
+
+				return target.GetId() == testResourceB.GetId()
+			})
+
+			allowed, err := acl.AllowsActionsOnMany(ctx, nil, userA, []string{"read", "edit"}, []Resource{testResourceA, testResourceB})
+			So(err, ShouldBeNil)
+			So(calls, ShouldEqual, 4)
+			So(allowed, ShouldResemble, map[string]map[string]bool{
+				testResourceA.GetId(): {"read": true, "edit": true},
+				testResourceB.GetId(): {"read": true, "edit": true},
+			})
+		})
+	})
+}
+
+// queryCountingStore counts the queries resolving permission checks
+type queryCountingStore struct {
+	Store
+	queries int
+}
+
+func (s *queryCountingStore) Candidates(ctx context.Context, q Querier, query Query) ([]Candidate, error) {
+	s.queries++
+
+	return s.Store.Candidates(ctx, q, query)
+}
+
+func (s *queryCountingStore) TargetCandidates(ctx context.Context, q Querier, query Query) ([]Candidate, map[string]map[string]int, error) {
+	s.queries++
+
+	return s.Store.TargetCandidates(ctx, q, query)
+}
+
+func (s *queryCountingStore) TargetLevels(ctx context.Context, q Querier, ids []string) (map[string]map[string]int, error) {
+	s.queries++
+
+	return s.Store.TargetLevels(ctx, q, ids)
+}
@@ -0,0 +1,370 @@
+package acl
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStats are the statistics of a Cache
+type CacheStats struct {
+	// Hits is the number of checks answered from the cache
+	Hits uint64
+	// Misses is the number of checks resolved by the ACL
+	Misses uint64
+	// Evictions is the number of decisions evicted to respect the size
+	Evictions uint64
+	// Size is the number of cached decisions
+	Size int
+}
+
+// Cache is an ActionAuthorizer caching the decisions of an ACL along
+// with the ancestors of their actors, evicting the least recently used
+// decisions beyond its size. A shared Cache should not be used for checks in
+// transactions changing the ACL, as it could remember uncommitted changes.
+//
+// Changes are applied through Invalidate, with Postgres the triggers created
+// by EnsureTablesAndRulesExist notify the channel named after the ACL table
+// of every change and those created by EnsureTargetTreeExists the channel
+// named after the target tree table, eg. using github.com/lib/pq:
+//
+//	listener := pq.NewListener(dsn, time.Second, time.Minute, nil)
+//	listener.Listen(table)
+//	listener.Listen(targetTreeTable)
+//
+//	payloads := make(chan string)
+//
+//	go func() {
+//		for n := range listener.Notify {
+//			if n == nil {
+//				payloads <- "" /* Reconnected, notifications may be lost */
+//			} else {
+//				payloads <- n.Extra
+//			}
+//		}
+//	}()
+//
+//	go cache.Listen(ctx, payloads)
+type Cache struct {
+	acl  *ACL
+	size int
+	ttl  time.Duration
+
+	mu         sync.Mutex
+	generation uint64
+	order      *list.List
+	decisions  map[cacheKey]*list.Element
+	byActor    map[string]map[cacheKey]struct{}
+	chains     map[string][]string
+	dependents map[string]map[string]struct{}
+	stats      CacheStats
+}
+
+type cacheKey struct {
+	actorId  string
+	action   string
+	targetId string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	allowed bool
+	expires time.Time
+}
+
+// NewCache creates a Cache of the decisions of acl holding at most size
+// decisions, a size of 0 does not bound the cache. Decisions expire after ttl
+// unless it is 0, which keeps them until invalidated. Settings and
+// inheritances with validity windows start and stop applying without any
+// notification, the ttl bounds how long the cache takes to notice
+func NewCache(acl *ACL, size int, ttl time.Duration) *Cache {
+	c := &Cache{acl: acl, size: size, ttl: ttl}
+
+	c.reset()
+
+	return c
+}
+
+// reset empties the cache
+func (c *Cache) reset() {
+	c.order = list.New()
+	c.decisions = make(map[cacheKey]*list.Element)
+	c.byActor = make(map[string]map[cacheKey]struct{})
+	c.chains = make(map[string][]string)
+	c.dependents = make(map[string]map[string]struct{})
+}
+
+// AllowsAction is like ACL.AllowsAction but uses the cache
+func (c *Cache) AllowsAction(q Querier, actor Resource, action string) (bool, error) {
+	return c.AllowsActionContext(context.Background(), q, actor, action)
+}
+
+// AllowsActionContext is like ACL.AllowsActionContext but uses the cache
+func (c *Cache) AllowsActionContext(ctx context.Context, q Querier, actor Resource, action string) (bool, error) {
+	return c.check(ctx, q, actor, action, nil)
+}
+
+// AllowsActionOn is like ACL.AllowsActionOn but uses the cache
+func (c *Cache) AllowsActionOn(q Querier, actor Resource, action string, target Resource) (bool, error) {
+	return c.AllowsActionOnContext(context.Background(), q, actor, action, target)
+}
+
+// AllowsActionOnContext is like ACL.AllowsActionOnContext but uses the cache
+func (c *Cache) AllowsActionOnContext(ctx context.Context, q Querier, actor Resource, action string, target Resource) (bool, error) {
+	return c.check(ctx, q, actor, action, target)
+}
+
+// check is like ACL.check using the cache, logging both hits and misses
+func (c *Cache) check(ctx context.Context, q Querier, actor Resource, action string, target Resource) (bool, error) {
+	decision, err := c.acl.check(actor, action, target, func(targetId string) (Decision, error) {
+		allowed, err := c.allows(ctx, q, actor.GetId(), action, targetId)
+
+		return Decision{Allowed: allowed}, err
+	})
+
+	return decision.Allowed, err
+}
+
+// allows returns the cached decision, resolving and caching it on a miss. A
+// decision resolved while the cache was invalidated is not cached, it might
+// predate the change. The ancestors of the actor are listed by the query of
+// the candidates when none of its decisions is cached, an expired decision is
+// replaced rather than removed to keep them
+func (c *Cache) allows(ctx context.Context, q Querier, actorId string, action string, targetId string) (bool, error) {
+	key := cacheKey{actorId, action, targetId}
+
+	c.mu.Lock()
+
+	if e, ok := c.decisions[key]; ok {
+		entry := e.Value.(*cacheEntry)
+
+		if c.ttl == 0 || time.Now().Before(entry.expires) {
+			c.order.MoveToFront(e)
+			c.stats.Hits++
+			c.mu.Unlock()
+
+			return entry.allowed, nil
+		}
+	}
+
+	c.stats.Misses++
+	generation := c.generation
+	_, hasChain := c.chains[actorId]
+
+	c.mu.Unlock()
+
+	at := Query{At: time.Now()}
+
+	targets, err := c.acl.levelsOf(ctx, q, at, targetId)
+	if err != nil {
+		return false, err
+	}
+
+	query := c.acl.candidatesQuery(at, actorId, action, targets)
+	query.Ancestors = !hasChain
+
+	candidates, err := c.acl.store.Candidates(ctx, q, query)
+	if err != nil {
+		return false, err
+	}
+
+	var chain []string
+
+	if !hasChain {
+		candidates, chain = splitAncestors(candidates)
+	}
+
+	decision, err := c.acl.resolve(candidates, action, targets, Attributes(nil).with(actorId, targetId))
+	if err != nil {
+		return false, err
+	}
+
+	allowed := decision.Allowed
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation == generation {
+		c.add(key, allowed, chain)
+	}
+
+	return allowed, nil
+}
+
+// splitAncestors removes the entries listed by Query.Ancestors from the
+// candidates, returning the ids on the paths of all of them: the actor and
+// every ancestor its decisions depend on
+func splitAncestors(candidates []Candidate) ([]Candidate, []string) {
+	var grants []Candidate
+
+	seen := make(map[string]struct{})
+	chain := []string{}
+
+	for _, c := range candidates {
+		if c.Action != "" {
+			grants = append(grants, c)
+		}
+
+		for _, id := range c.Path {
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				chain = append(chain, id)
+			}
+		}
+	}
+
+	return grants, chain
+}
+
+// add caches the decision, chain is nil if the ancestors of the actor are
+// already cached
+func (c *Cache) add(key cacheKey, allowed bool, chain []string) {
+	if _, ok := c.chains[key.actorId]; !ok {
+		if chain == nil {
+			/* The ancestors were evicted meanwhile */
+			return
+		}
+
+		c.chains[key.actorId] = chain
+
+		for _, id := range chain {
+			if c.dependents[id] == nil {
+				c.dependents[id] = make(map[string]struct{})
+			}
+
+			c.dependents[id][key.actorId] = struct{}{}
+		}
+	}
+
+	if e, ok := c.decisions[key]; ok {
+		c.order.Remove(e)
+	}
+
+	entry := &cacheEntry{key: key, allowed: allowed}
+
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+
+	c.decisions[key] = c.order.PushFront(entry)
+
+	if c.byActor[key.actorId] == nil {
+		c.byActor[key.actorId] = make(map[cacheKey]struct{})
+	}
+
+	c.byActor[key.actorId][key] = struct{}{}
+
+	for c.size > 0 && c.order.Len() > c.size {
+		c.remove(c.order.Back().Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// remove removes the decision, along with the ancestors of its actor if it
+// was the last decision of the actor
+func (c *Cache) remove(key cacheKey) {
+	if e, ok := c.decisions[key]; ok {
+		c.order.Remove(e)
+		delete(c.decisions, key)
+	}
+
+	delete(c.byActor[key.actorId], key)
+
+	if len(c.byActor[key.actorId]) > 0 {
+		return
+	}
+
+	delete(c.byActor, key.actorId)
+
+	for _, id := range c.chains[key.actorId] {
+		delete(c.dependents[id], key.actorId)
+
+		if len(c.dependents[id]) == 0 {
+			delete(c.dependents, id)
+		}
+	}
+
+	delete(c.chains, key.actorId)
+}
+
+// InvalidateActor removes the decisions of the actor and of every actor
+// inheriting from it
+func (c *Cache) InvalidateActor(actor Resource) {
+	c.invalidateActor(actor.GetId())
+}
+
+func (c *Cache) invalidateActor(actorId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	var actors []string
+
+	for id := range c.dependents[actorId] {
+		actors = append(actors, id)
+	}
+
+	for _, id := range actors {
+		for key := range c.byActor[id] {
+			c.remove(key)
+		}
+	}
+}
+
+// Invalidate applies the payload of a notification from the triggers created
+// by EnsureTablesAndRulesExist or EnsureTargetTreeExists. A change of the rows
+// of an actor, its inheritance or its roles removes the decisions of the actor
+// and of every actor inheriting from it, any other change, like one of the
+// target tree, or an empty payload, signalling that notifications may have
+// been lost, flushes the cache
+func (c *Cache) Invalidate(payload string) {
+	parts := strings.SplitN(payload, ":", 2)
+
+	if len(parts) == 2 && parts[0] == "actor" {
+		c.invalidateActor(parts[1])
+
+		return
+	}
+
+	c.Flush()
+}
+
+// Listen invalidates the cache with the payloads until the context is done or
+// payloads is closed, see Invalidate
+func (c *Cache) Listen(ctx context.Context, payloads <-chan string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case payload, ok := <-payloads:
+			if !ok {
+				return nil
+			}
+
+			c.Invalidate(payload)
+		}
+	}
+}
+
+// Flush removes every cached decision and ancestor
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.reset()
+}
+
+// Stats returns the statistics of the cache
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ret := c.stats
+	ret.Size = c.order.Len()
+
+	return ret
+}
@@ -0,0 +1,222 @@
+package acl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+	userC := idAble{id: "7be24c16-6376-478d-91c9-f879116d1d49"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	Convey("When A inherits from B and B allows", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+		So(acl.SetActionAllowed(nil, userB, "testing", true), ShouldBeNil)
+
+		cache := NewCache(acl, 2, 0)
+
+		Convey("AllowsAction() should resolve a miss and then hit", func() {
+			for i := 0; i < 2; i++ {
+				allowed, err := cache.AllowsAction(nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			}
+
+			So(cache.Stats(), ShouldResemble, CacheStats{Hits: 1, Misses: 1, Size: 1})
+
+			Convey("And should keep the decision without invalidation", func() {
+				So(acl.SetActionAllowed(nil, userB, "testing", false), ShouldBeNil)
+
+				allowed, err := cache.AllowsAction(nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("And a change of the ancestor should invalidate it", func() {
+				So(acl.SetActionAllowed(nil, userB, "testing", false), ShouldBeNil)
+				cache.Invalidate("actor:" + userB.GetId())
+
+				allowed, err := cache.AllowsAction(nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("And a change of an unrelated actor should not invalidate it", func() {
+				cache.Invalidate("actor:" + userC.GetId())
+
+				So(cache.Stats().Size, ShouldEqual, 1)
+			})
+
+			Convey("And a new inheritance should invalidate it", func() {
+				So(acl.SetActionAllowed(nil, userC, "testing", false), ShouldBeNil)
+				So(acl.SetActorInherits(nil, userA, userC), ShouldBeNil)
+				cache.Invalidate("actor:" + userA.GetId())
+
+				allowed, err := cache.AllowsAction(nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("And a change of a role should flush the cache", func() {
+				cache.Invalidate("role:editor")
+
+				So(cache.Stats().Size, ShouldEqual, 0)
+			})
+
+			Convey("And a change of the target tree should flush the cache", func() {
+				cache.Invalidate("target:" + testResourceA.GetId())
+
+				So(cache.Stats().Size, ShouldEqual, 0)
+			})
+
+			Convey("And Flush() should empty the cache", func() {
+				cache.Flush()
+
+				So(cache.Stats(), ShouldResemble, CacheStats{Hits: 1, Misses: 1, Size: 0})
+			})
+		})
+
+		Convey("The least recently used decision should be evicted beyond the size", func() {
+			for _, action := range []string{"testing", "other", "testing", "third"} {
+				_, err := cache.AllowsActionOn(nil, userA, action, testResourceA)
+				So(err, ShouldBeNil)
+			}
+
+			So(cache.Stats(), ShouldResemble, CacheStats{Hits: 1, Misses: 3, Evictions: 1, Size: 2})
+
+			_, err := cache.AllowsActionOn(nil, userA, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(cache.Stats().Hits, ShouldEqual, uint64(2))
+		})
+
+		Convey("Decisions should expire after the ttl", func() {
+			cache := NewCache(acl, 0, time.Nanosecond)
+
+			_, err := cache.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+
+			time.Sleep(time.Millisecond)
+
+			_, err = cache.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+			So(cache.Stats().Misses, ShouldEqual, uint64(2))
+		})
+
+		Convey("A miss should list the ancestors with the candidates only when none are cached", func() {
+			store := &ancestorCountingStore{Store: NewMemoryStore()}
+			acl := NewWithStore(store, nil)
+
+			So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+			So(acl.SetActorInherits(nil, userB, userC), ShouldBeNil)
+
+			cache := NewCache(acl, 0, time.Nanosecond)
+
+			for _, action := range []string{"testing", "other", "testing"} {
+				time.Sleep(time.Millisecond)
+
+				_, err := cache.AllowsAction(nil, userA, action)
+				So(err, ShouldBeNil)
+			}
+
+			So(cache.Stats().Misses, ShouldEqual, uint64(3))
+			So(store.candidates, ShouldEqual, 3)
+			So(store.withAncestors, ShouldEqual, 1)
+			So(store.ancestors, ShouldEqual, 0)
+			So(store.parents, ShouldEqual, 0)
+
+			Convey("And a change of the furthest ancestor should invalidate the decisions", func() {
+				cache.Invalidate("actor:" + userC.GetId())
+
+				So(cache.Stats().Size, ShouldEqual, 0)
+			})
+		})
+
+		Convey("Candidates() should list the ancestors when asked", func() {
+			testCandidateAncestors(NewMemory(), nil, userA, userB, userC)
+		})
+
+		Convey("Listen() should apply the payloads until the channel is closed", func() {
+			_, err := cache.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+
+			payloads := make(chan string, 1)
+			payloads <- ""
+			close(payloads)
+
+			So(cache.Listen(ctx, payloads), ShouldBeNil)
+			So(cache.Stats().Size, ShouldEqual, 0)
+		})
+	})
+}
+
+// testCandidateAncestors checks the candidates of Query.Ancestors like every
+// Store has to list them, the actor inherits from the parent which inherits
+// from the grandparent and only the parent has a grant
+func testCandidateAncestors(acl *ACL, q Querier, actor Resource, parent Resource, grandparent Resource) {
+	ctx := context.Background()
+
+	So(acl.SetActorInherits(q, actor, parent), ShouldBeNil)
+	So(acl.SetActorInherits(q, parent, grandparent), ShouldBeNil)
+	So(acl.SetActionAllowed(q, parent, "testing", true), ShouldBeNil)
+
+	candidates, err := acl.store.Candidates(ctx, q, Query{ActorId: actor.GetId(), Actions: []string{"testing"}, At: time.Now(), Ancestors: true})
+	So(err, ShouldBeNil)
+
+	grants, chain := splitAncestors(candidates)
+	So(len(grants), ShouldEqual, 1)
+	So(grants[0].ActorId, ShouldEqual, parent.GetId())
+	So(grants[0].Level, ShouldEqual, 1)
+	So(len(chain), ShouldEqual, 3)
+	So(chain, ShouldContain, actor.GetId())
+	So(chain, ShouldContain, parent.GetId())
+	So(chain, ShouldContain, grandparent.GetId())
+
+	for _, c := range candidates {
+		if c.Action == "" {
+			So(c.Path[0], ShouldEqual, actor.GetId())
+			So(c.Path[len(c.Path)-1], ShouldEqual, c.ActorId)
+			So(c.Level, ShouldEqual, len(c.Path)-1)
+		}
+	}
+}
+
+// ancestorCountingStore counts the queries for the ancestors of actors
+type ancestorCountingStore struct {
+	Store
+	parents       int
+	ancestors     int
+	candidates    int
+	withAncestors int
+}
+
+func (s *ancestorCountingStore) Parents(ctx context.Context, q Querier, id string) ([]string, error) {
+	s.parents++
+
+	return s.Store.Parents(ctx, q, id)
+}
+
+func (s *ancestorCountingStore) Ancestors(ctx context.Context, q Querier, id string) ([]string, error) {
+	s.ancestors++
+
+	return s.Store.Ancestors(ctx, q, id)
+}
+
+func (s *ancestorCountingStore) Candidates(ctx context.Context, q Querier, query Query) ([]Candidate, error) {
+	s.candidates++
+
+	if query.Ancestors {
+		s.withAncestors++
+	}
+
+	return s.Store.Candidates(ctx, q, query)
+}
@@ -0,0 +1,573 @@
+package acl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Attributes are the values conditions are evaluated against, keyed by names
+// such as request.ip. The checks set actor.id to the id of the checked actor
+// and target.id to the id of the checked target, if any
+type Attributes map[string]string
+
+// with returns a copy of the attributes including actor.id and target.id
+func (a Attributes) with(actorId string, targetId string) Attributes {
+	ret := make(Attributes, len(a)+2)
+
+	for k, v := range a {
+		ret[k] = v
+	}
+
+	ret["actor.id"] = actorId
+
+	if targetId != EMPTY_RESOURCE {
+		ret["target.id"] = targetId
+	}
+
+	return ret
+}
+
+// SetActionAllowedIf is like SetActionAllowed but the setting only applies
+// when the condition holds, see SetActionAllowedOnIf
+func (acl *ACL) SetActionAllowedIf(q Querier, actor Resource, action string, allowed bool, condition string) error {
+	return acl.SetActionAllowedIfContext(context.Background(), q, actor, action, allowed, condition)
+}
+
+// SetActionAllowedIfContext is like SetActionAllowedIf but uses the supplied context
+func (acl *ACL) SetActionAllowedIfContext(ctx context.Context, q Querier, actor Resource, action string, allowed bool, condition string) error {
+	return acl.setConditionalGrant(ctx, q, Grant{ActorId: actor.GetId(), Action: action, TargetId: EMPTY_RESOURCE, Allowed: allowed, Condition: condition})
+}
+
+// SetActionAllowedOnIf is like SetActionAllowedOn but the setting only
+// applies when the condition holds for the attributes of the check, eg.
+// `request.ip in 10.0.0.0/8` or `target.owner == actor.id`. A setting whose
+// condition does not hold is skipped as if it did not exist, letting the next
+// setting in order of precedence decide. A condition depending on a missing
+// attribute is unknown, a deny with an unknown condition applies while an
+// allow is skipped, so checks without the attributes like AllowsActionOn fail
+// closed.
+//
+// Conditions compare attributes, "quoted strings" and bare literals starting
+// with a digit using ==, !=, <, <=, > and >=, where the last four compare
+// numbers. The in operator tests membership of a [list, of, values] or of a
+// CIDR network. Comparisons are combined with &&, || and ! along with
+// parentheses, and an attribute on its own holds if its value is "true"
+func (acl *ACL) SetActionAllowedOnIf(q Querier, actor Resource, action string, target Resource, allowed bool, condition string) error {
+	return acl.SetActionAllowedOnIfContext(context.Background(), q, actor, action, target, allowed, condition)
+}
+
+// SetActionAllowedOnIfContext is like SetActionAllowedOnIf but uses the supplied context
+func (acl *ACL) SetActionAllowedOnIfContext(ctx context.Context, q Querier, actor Resource, action string, target Resource, allowed bool, condition string) error {
+	return acl.setConditionalGrant(ctx, q, Grant{ActorId: actor.GetId(), Action: action, TargetId: target.GetId(), Allowed: allowed, Condition: condition})
+}
+
+// setConditionalGrant validates the condition of the grant before storing it
+func (acl *ACL) setConditionalGrant(ctx context.Context, q Querier, grant Grant) error {
+	if _, err := compileCondition(grant.Condition); err != nil {
+		return err
+	}
+
+	return acl.store.SetGrant(ctx, q, grant)
+}
+
+// AllowsActionWith is like AllowsActionContext but evaluates the conditions
+// of the settings against the attributes
+func (acl *ACL) AllowsActionWith(ctx context.Context, q Querier, actor Resource, action string, attrs Attributes) (bool, error) {
+	decision, err := acl.checkAt(ctx, q, Query{}, actor, action, nil, attrs)
+
+	return decision.Allowed, err
+}
+
+// AllowsActionOnWith is like AllowsActionOnContext but evaluates the
+// conditions of the settings against the attributes
+func (acl *ACL) AllowsActionOnWith(ctx context.Context, q Querier, actor Resource, action string, target Resource, attrs Attributes) (bool, error) {
+	decision, err := acl.checkAt(ctx, q, Query{}, actor, action, target, attrs)
+
+	return decision.Allowed, err
+}
+
+// truth is the outcome of a condition, unknown when it depends on a missing
+// attribute
+type truth int
+
+const (
+	isFalse truth = iota
+	isTrue
+	isUnknown
+)
+
+// truthOf converts a known outcome to a truth
+func truthOf(b bool) truth {
+	if b {
+		return isTrue
+	}
+
+	return isFalse
+}
+
+// condition is a parsed condition expression
+type condition interface {
+	eval(attrs Attributes) truth
+}
+
+// conditionCacheSize is the number of parsed conditions kept before the
+// cache is emptied
+const conditionCacheSize = 1024
+
+// parsedConditions keeps the parsed conditions by their source, so every
+// condition is parsed once when it is stored or first loaded instead of on
+// every check
+var parsedConditions = struct {
+	sync.RWMutex
+	m map[string]condition
+}{m: make(map[string]condition)}
+
+// compileCondition returns the parsed condition, parsing it on first use
+func compileCondition(src string) (condition, error) {
+	parsedConditions.RLock()
+	c, ok := parsedConditions.m[src]
+	parsedConditions.RUnlock()
+
+	if ok {
+		return c, nil
+	}
+
+	c, err := parseCondition(src)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedConditions.Lock()
+	defer parsedConditions.Unlock()
+
+	if len(parsedConditions.m) >= conditionCacheSize {
+		parsedConditions.m = make(map[string]condition)
+	}
+
+	parsedConditions.m[src] = c
+
+	return c, nil
+}
+
+// parseCondition parses the condition expression, an empty condition always
+// holds
+func parseCondition(src string) (condition, error) {
+	if strings.TrimSpace(src) == "" {
+		return condAnd(nil), nil
+	}
+
+	p := &conditionParser{src: src}
+
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+
+	return c, nil
+}
+
+// evalCondition evaluates the condition against the attributes
+func evalCondition(src string, attrs Attributes) (truth, error) {
+	if src == "" {
+		return isTrue, nil
+	}
+
+	c, err := compileCondition(src)
+	if err != nil {
+		return isFalse, err
+	}
+
+	return c.eval(attrs), nil
+}
+
+type condOr []condition
+
+func (c condOr) eval(attrs Attributes) truth {
+	ret := isFalse
+
+	for _, sub := range c {
+		switch sub.eval(attrs) {
+		case isTrue:
+			return isTrue
+		case isUnknown:
+			ret = isUnknown
+		}
+	}
+
+	return ret
+}
+
+type condAnd []condition
+
+func (c condAnd) eval(attrs Attributes) truth {
+	ret := isTrue
+
+	for _, sub := range c {
+		switch sub.eval(attrs) {
+		case isFalse:
+			return isFalse
+		case isUnknown:
+			ret = isUnknown
+		}
+	}
+
+	return ret
+}
+
+type condNot struct {
+	c condition
+}
+
+func (c condNot) eval(attrs Attributes) truth {
+	switch c.c.eval(attrs) {
+	case isTrue:
+		return isFalse
+	case isFalse:
+		return isTrue
+	}
+
+	return isUnknown
+}
+
+// condOperand is an attribute, a literal or a list of operands
+type condOperand struct {
+	attr  string
+	value string
+	list  []condOperand
+}
+
+// resolve returns the value of the operand, false if it is a missing attribute
+func (o condOperand) resolve(attrs Attributes) (string, bool) {
+	if o.attr == "" {
+		return o.value, true
+	}
+
+	v, ok := attrs[o.attr]
+
+	return v, ok
+}
+
+type condTruthy struct {
+	operand condOperand
+}
+
+func (c condTruthy) eval(attrs Attributes) truth {
+	v, ok := c.operand.resolve(attrs)
+	if !ok {
+		return isUnknown
+	}
+
+	return truthOf(v == "true")
+}
+
+type condCompare struct {
+	left  condOperand
+	op    string
+	right condOperand
+}
+
+func (c condCompare) eval(attrs Attributes) truth {
+	left, ok := c.left.resolve(attrs)
+	if !ok {
+		return isUnknown
+	}
+
+	if c.op == "in" {
+		return c.in(left, attrs)
+	}
+
+	right, ok := c.right.resolve(attrs)
+	if !ok {
+		return isUnknown
+	}
+
+	switch c.op {
+	case "==":
+		return truthOf(left == right)
+	case "!=":
+		return truthOf(left != right)
+	}
+
+	l, err := strconv.ParseFloat(left, 64)
+	if err != nil {
+		return isFalse
+	}
+
+	r, err := strconv.ParseFloat(right, 64)
+	if err != nil {
+		return isFalse
+	}
+
+	switch c.op {
+	case "<":
+		return truthOf(l < r)
+	case "<=":
+		return truthOf(l <= r)
+	case ">":
+		return truthOf(l > r)
+	default:
+		return truthOf(l >= r)
+	}
+}
+
+// in tests membership of the list or the CIDR network of the right operand
+func (c condCompare) in(left string, attrs Attributes) truth {
+	if c.right.list != nil {
+		ret := isFalse
+
+		for _, o := range c.right.list {
+			v, ok := o.resolve(attrs)
+			if !ok {
+				ret = isUnknown
+			} else if v == left {
+				return isTrue
+			}
+		}
+
+		return ret
+	}
+
+	right, ok := c.right.resolve(attrs)
+	if !ok {
+		return isUnknown
+	}
+
+	_, network, err := net.ParseCIDR(right)
+	if err != nil {
+		return isFalse
+	}
+
+	ip := net.ParseIP(left)
+
+	return truthOf(ip != nil && network.Contains(ip))
+}
+
+// conditionToken is a token of a condition expression, kind is one of
+// attr, literal or op
+type conditionToken struct {
+	kind string
+	text string
+}
+
+type conditionParser struct {
+	src    string
+	tokens []conditionToken
+	pos    int
+}
+
+func (p *conditionParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("acl: invalid condition %q: %s", p.src, fmt.Sprintf(format, args...))
+}
+
+// isWordChar returns true for the characters of attributes and bare literals
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("_.:/-", c) >= 0
+}
+
+func (p *conditionParser) tokenize() error {
+	src := p.src
+
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return p.errorf("unterminated string")
+			}
+
+			p.tokens = append(p.tokens, conditionToken{"literal", src[i+1 : i+1+end]})
+			i += end + 2
+		case strings.HasPrefix(src[i:], "==") || strings.HasPrefix(src[i:], "!=") || strings.HasPrefix(src[i:], "<=") || strings.HasPrefix(src[i:], ">=") || strings.HasPrefix(src[i:], "&&") || strings.HasPrefix(src[i:], "||"):
+			p.tokens = append(p.tokens, conditionToken{"op", src[i : i+2]})
+			i += 2
+		case strings.IndexByte("<>!()[],", c) >= 0:
+			p.tokens = append(p.tokens, conditionToken{"op", src[i : i+1]})
+			i++
+		case isWordChar(c):
+			start := i
+
+			for i < len(src) && isWordChar(src[i]) {
+				i++
+			}
+
+			word := src[start:i]
+
+			switch {
+			case word == "in":
+				p.tokens = append(p.tokens, conditionToken{"op", word})
+			case c >= '0' && c <= '9' || c == '-' || word == "true" || word == "false":
+				p.tokens = append(p.tokens, conditionToken{"literal", word})
+			default:
+				p.tokens = append(p.tokens, conditionToken{"attr", word})
+			}
+		default:
+			return p.errorf("unexpected character %q", c)
+		}
+	}
+
+	return nil
+}
+
+// accept consumes the next token if it is the operator op
+func (p *conditionParser) accept(op string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == "op" && p.tokens[p.pos].text == op {
+		p.pos++
+
+		return true
+	}
+
+	return false
+}
+
+func (p *conditionParser) parseOr() (condition, error) {
+	var ret condOr
+
+	for {
+		c, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, c)
+
+		if !p.accept("||") {
+			break
+		}
+	}
+
+	if len(ret) == 1 {
+		return ret[0], nil
+	}
+
+	return ret, nil
+}
+
+func (p *conditionParser) parseAnd() (condition, error) {
+	var ret condAnd
+
+	for {
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, c)
+
+		if !p.accept("&&") {
+			break
+		}
+	}
+
+	if len(ret) == 1 {
+		return ret[0], nil
+	}
+
+	return ret, nil
+}
+
+func (p *conditionParser) parseUnary() (condition, error) {
+	if p.accept("!") {
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return condNot{c}, nil
+	}
+
+	if p.accept("(") {
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.accept(")") {
+			return nil, p.errorf("missing )")
+		}
+
+		return c, nil
+	}
+
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+
+			return condCompare{left, op, right}, nil
+		}
+	}
+
+	if p.accept("in") {
+		if p.accept("[") {
+			list := []condOperand{}
+
+			for !p.accept("]") {
+				if len(list) > 0 && !p.accept(",") {
+					return nil, p.errorf("missing , or ]")
+				}
+
+				o, err := p.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+
+				list = append(list, o)
+			}
+
+			return condCompare{left, "in", condOperand{list: list}}, nil
+		}
+
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+
+		return condCompare{left, "in", right}, nil
+	}
+
+	return condTruthy{left}, nil
+}
+
+func (p *conditionParser) parseOperand() (condOperand, error) {
+	if p.pos >= len(p.tokens) {
+		return condOperand{}, p.errorf("unexpected end")
+	}
+
+	t := p.tokens[p.pos]
+
+	switch t.kind {
+	case "attr":
+		p.pos++
+
+		return condOperand{attr: t.text}, nil
+	case "literal":
+		p.pos++
+
+		return condOperand{value: t.text}, nil
+	}
+
+	return condOperand{}, p.errorf("unexpected %q", t.text)
+}
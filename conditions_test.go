@@ -0,0 +1,163 @@
+package acl
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConditions(t *testing.T) {
+	ctx := context.Background()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	Convey("evalCondition() should evaluate the expressions", t, func() {
+		attrs := Attributes{
+			"request.ip":   "10.1.2.3",
+			"actor.id":     "a",
+			"target.owner": "a",
+			"target.size":  "12",
+			"request.mfa":  "true",
+			"request.role": "admin",
+		}
+
+		for src, expected := range map[string]truth{
+			``:                                                 isTrue,
+			`request.ip in 10.0.0.0/8`:                         isTrue,
+			`request.ip in 192.168.0.0/16`:                     isFalse,
+			`target.owner == actor.id`:                         isTrue,
+			`target.owner != actor.id`:                         isFalse,
+			`target.size > 10 && target.size <= 12`:            isTrue,
+			`target.size < 10 || request.mfa`:                  isTrue,
+			`!request.mfa`:                                     isFalse,
+			`request.role in [admin, "owner"]`:                 isUnknown,
+			`request.role in ["admin", "owner"]`:               isTrue,
+			`request.role in ["user", "owner"]`:                isFalse,
+			`!(request.role == "user") && request.mfa == true`: isTrue,
+			`request.missing == ""`:                            isUnknown,
+			`request.missing != "x"`:                           isUnknown,
+			`!(request.missing == "x")`:                        isUnknown,
+			`request.missing || request.mfa`:                   isTrue,
+			`request.missing && !request.mfa`:                  isFalse,
+			`target.owner > 1`:                                 isFalse,
+		} {
+			holds, err := evalCondition(src, attrs)
+			So(err, ShouldBeNil)
+			So(holds, ShouldEqual, expected)
+		}
+	})
+
+	Convey("evalCondition() should refuse invalid expressions", t, func() {
+		for _, src := range []string{
+			`request.ip in`,
+			`(request.mfa`,
+			`request.role == "admin`,
+			`request.role = "admin"`,
+			`request.mfa request.role`,
+			`request.role in [a b]`,
+		} {
+			_, err := evalCondition(src, Attributes{})
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("With an empty MemoryStore", t, func() {
+		acl := NewMemory()
+
+		Convey("SetActionAllowedOnIf() should refuse an invalid condition", func() {
+			So(acl.SetActionAllowedOnIf(nil, userA, "edit", testResourceA, true, `target.owner ==`), ShouldNotBeNil)
+		})
+
+		Convey("SetActionAllowedOnIf() should only allow when the condition holds", func() {
+			So(acl.SetActionAllowedOnIf(nil, userA, "edit", testResourceA, true, `target.owner == actor.id`), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOnWith(ctx, nil, userA, "edit", testResourceA, Attributes{"target.owner": userA.GetId()})
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionOnWith(ctx, nil, userA, "edit", testResourceA, Attributes{"target.owner": userB.GetId()})
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			allowed, err = acl.AllowsActionOn(nil, userA, "edit", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("When B allows and A inherits from B with a conditional deny on the target", func() {
+			So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userB, "edit", true), ShouldBeNil)
+			So(acl.SetActionAllowedOnIf(nil, userA, "edit", testResourceA, false, `request.ip in 10.0.0.0/8`), ShouldBeNil)
+
+			Convey("AllowsActionOn() should deny without the attribute", func() {
+				allowed, err := acl.AllowsActionOn(nil, userA, "edit", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("AllowsActionOnWith() should allow when the deny does not hold", func() {
+				allowed, err := acl.AllowsActionOnWith(ctx, nil, userA, "edit", testResourceA, Attributes{"request.ip": "203.0.113.7"})
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+		})
+
+		Convey("When B allows and A inherits from B", func() {
+			So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userB, "login", true), ShouldBeNil)
+			So(acl.SetActionAllowedIf(nil, userA, "login", false, `!(request.ip in 10.0.0.0/8)`), ShouldBeNil)
+
+			Convey("A conditional deny on A should apply when it holds", func() {
+				allowed, err := acl.AllowsActionWith(ctx, nil, userA, "login", Attributes{"request.ip": "203.0.113.7"})
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("A conditional deny on A should be skipped when it does not hold", func() {
+				allowed, err := acl.AllowsActionWith(ctx, nil, userA, "login", Attributes{"request.ip": "10.0.0.1"})
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("A conditional deny on A should apply without the attribute", func() {
+				allowed, err := acl.AllowsAction(nil, userA, "login")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("A conditional allow on A should be skipped without the attribute", func() {
+				So(acl.SetActionAllowed(nil, userB, "login", false), ShouldBeNil)
+				So(acl.SetActionAllowedIf(nil, userA, "login", true, `!(request.ip in 10.0.0.0/8)`), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(nil, userA, "login")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+
+				allowed, err = acl.AllowsActionWith(ctx, nil, userA, "login", Attributes{"request.ip": "203.0.113.7"})
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("SetActionAllowed() should keep the condition", func() {
+				So(acl.SetActionAllowed(nil, userA, "login", false), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionWith(ctx, nil, userA, "login", Attributes{"request.ip": "10.0.0.1"})
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("SetActionAllowed() after UnsetActionAllowed() should remove the condition", func() {
+				So(acl.UnsetActionAllowed(nil, userA, "login"), ShouldBeNil)
+				So(acl.SetActionAllowed(nil, userA, "login", false), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionWith(ctx, nil, userA, "login", Attributes{"request.ip": "10.0.0.1"})
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+		})
+	})
+}
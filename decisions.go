@@ -0,0 +1,123 @@
+package acl
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DecisionEvent is the outcome of a permission check
+type DecisionEvent struct {
+	// Time is when the check started
+	Time    time.Time
+	ActorId string
+	Action  string
+	// TargetId is empty for checks without a target, like AllowsAction
+	TargetId string
+	Allowed  bool
+	// Latency is the time the check took
+	Latency time.Duration
+	// Bypassed is true if the bypassFunc allowed the action
+	Bypassed bool
+	// Err is the error of the check, if any
+	Err error
+}
+
+// DecisionLogger receives the decisions of an ACL, see WithDecisionLogger.
+// LogDecision is called synchronously by the checks, possibly concurrently
+type DecisionLogger interface {
+	LogDecision(event DecisionEvent)
+}
+
+// DecisionLoggerFunc is a function used as a DecisionLogger
+type DecisionLoggerFunc func(event DecisionEvent)
+
+// LogDecision calls f
+func (f DecisionLoggerFunc) LogDecision(event DecisionEvent) {
+	f(event)
+}
+
+// WithDecisionLogger returns a copy of the ACL passing the decision of every
+// permission check to the logger, from AllowsAction to ExplainActionOn and
+// AllowsActionsOnMany, including the checks of a Cache or a TxAuthorizer of
+// the copy answered from their memory. Only the fraction sampleRate of the
+// decisions is logged, 1 logs all of them, while failed checks are always
+// logged. A nil logger disables the logging
+func (acl *ACL) WithDecisionLogger(logger DecisionLogger, sampleRate float64) *ACL {
+	ret := *acl
+	ret.logger = logger
+	ret.sampleRate = sampleRate
+
+	return &ret
+}
+
+// logDecision logs the decision of the check started at start, if sampled
+func (acl *ACL) logDecision(start time.Time, actorId string, action string, targetId string, allowed bool, bypassed bool, err error) {
+	if acl.logger == nil {
+		return
+	}
+
+	if err == nil && acl.sampleRate < 1 && rand.Float64() >= acl.sampleRate {
+		return
+	}
+
+	acl.logger.LogDecision(DecisionEvent{
+		Time:     start,
+		ActorId:  actorId,
+		Action:   action,
+		TargetId: targetId,
+		Allowed:  allowed,
+		Latency:  time.Since(start),
+		Bypassed: bypassed,
+		Err:      err,
+	})
+}
+
+// jsonDecisionLogger writes the decisions as JSON lines
+type jsonDecisionLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// jsonDecision is a line written by jsonDecisionLogger
+type jsonDecision struct {
+	Time      string  `json:"time"`
+	ActorId   string  `json:"actor_id"`
+	Action    string  `json:"action"`
+	TargetId  string  `json:"target_id,omitempty"`
+	Allowed   bool    `json:"allowed"`
+	LatencyMs float64 `json:"latency_ms"`
+	Bypassed  bool    `json:"bypassed"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// NewJSONDecisionLogger creates a DecisionLogger writing every decision to w
+// as a line of JSON, with the keys time, actor_id, action, target_id,
+// allowed, latency_ms, bypassed and error. The lines are written one at a
+// time, errors writing them are ignored so logging never fails a check
+func NewJSONDecisionLogger(w io.Writer) DecisionLogger {
+	return &jsonDecisionLogger{enc: json.NewEncoder(w)}
+}
+
+func (l *jsonDecisionLogger) LogDecision(event DecisionEvent) {
+	line := jsonDecision{
+		Time:      event.Time.UTC().Format(time.RFC3339Nano),
+		ActorId:   event.ActorId,
+		Action:    event.Action,
+		TargetId:  event.TargetId,
+		Allowed:   event.Allowed,
+		LatencyMs: float64(event.Latency) / float64(time.Millisecond),
+		Bypassed:  event.Bypassed,
+	}
+
+	if event.Err != nil {
+		line.Error = event.Err.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.enc.Encode(line)
+}
@@ -0,0 +1,160 @@
+package acl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDecisionLogger(t *testing.T) {
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	Convey("When a decision logger is set", t, func() {
+		var events []DecisionEvent
+
+		logger := DecisionLoggerFunc(func(event DecisionEvent) {
+			events = append(events, event)
+		})
+
+		acl := NewMemoryWithBypass(func(actor Resource, action string, target Resource) bool {
+			return actor.GetId() == userB.GetId()
+		})
+
+		So(acl.SetActionAllowedOn(nil, userA, "testing", testResourceA, true), ShouldBeNil)
+
+		Convey("Every decision should be logged with a sample rate of 1", func() {
+			logged := acl.WithDecisionLogger(logger, 1)
+
+			allowed, err := logged.AllowsActionOn(nil, userA, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = logged.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			_, err = logged.AllowsAction(nil, userB, "testing")
+			So(err, ShouldBeNil)
+
+			So(len(events), ShouldEqual, 3)
+			So(events[0].ActorId, ShouldEqual, userA.GetId())
+			So(events[0].Action, ShouldEqual, "testing")
+			So(events[0].TargetId, ShouldEqual, testResourceA.GetId())
+			So(events[0].Allowed, ShouldEqual, true)
+			So(events[0].Bypassed, ShouldEqual, false)
+			So(events[0].Time.IsZero(), ShouldEqual, false)
+			So(events[1].TargetId, ShouldEqual, "")
+			So(events[1].Allowed, ShouldEqual, false)
+			So(events[2].Bypassed, ShouldEqual, true)
+			So(events[2].Allowed, ShouldEqual, true)
+
+			Convey("And the original ACL should not log", func() {
+				_, err := acl.AllowsAction(nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(len(events), ShouldEqual, 3)
+			})
+		})
+
+		Convey("The other checks should log their decisions too", func() {
+			ctx := context.Background()
+			logged := acl.WithDecisionLogger(logger, 1)
+
+			_, err := logged.AllowsActionOnWith(ctx, nil, userA, "testing", testResourceA, Attributes{})
+			So(err, ShouldBeNil)
+
+			_, err = logged.ExplainActionOn(ctx, nil, userA, "testing", testResourceA)
+			So(err, ShouldBeNil)
+
+			_, err = logged.AllowsActionOnAt(nil, userA, "testing", testResourceA, time.Now())
+			So(err, ShouldBeNil)
+
+			_, err = logged.AllowsActionsOnMany(ctx, nil, userA, []string{"testing", "other"}, []Resource{testResourceA})
+			So(err, ShouldBeNil)
+
+			So(len(events), ShouldEqual, 5)
+
+			for _, event := range events {
+				So(event.ActorId, ShouldEqual, userA.GetId())
+				So(event.TargetId, ShouldEqual, testResourceA.GetId())
+			}
+
+			So(events[3].Allowed, ShouldNotEqual, events[4].Allowed)
+
+			Convey("And a Cache should log both misses and hits", func() {
+				cache := NewCache(logged, 0, 0)
+
+				for i := 0; i < 2; i++ {
+					allowed, err := cache.AllowsActionOn(nil, userA, "testing", testResourceA)
+					So(err, ShouldBeNil)
+					So(allowed, ShouldEqual, true)
+				}
+
+				So(len(events), ShouldEqual, 7)
+				So(cache.Stats().Hits, ShouldEqual, uint64(1))
+			})
+
+			Convey("And a TxAuthorizer should log the memoized decisions", func() {
+				authorizer := NewTxAuthorizer(logged, nil)
+
+				for i := 0; i < 2; i++ {
+					_, err := authorizer.AllowsActionOn(nil, userA, "testing", testResourceA)
+					So(err, ShouldBeNil)
+				}
+
+				So(len(events), ShouldEqual, 7)
+			})
+		})
+
+		Convey("Only failed checks should be logged with a sample rate of 0", func() {
+			logged := acl.WithDecisionLogger(logger, 0)
+
+			_, err := logged.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+			So(len(events), ShouldEqual, 0)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err = logged.AllowsActionContext(ctx, nil, userA, "testing")
+			So(err, ShouldNotBeNil)
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Err, ShouldEqual, err)
+		})
+	})
+
+	Convey("NewJSONDecisionLogger() should write a line of JSON per decision", t, func() {
+		var buf bytes.Buffer
+
+		acl := NewMemory().WithDecisionLogger(NewJSONDecisionLogger(&buf), 1)
+
+		So(acl.SetActionAllowed(nil, userA, "testing", true), ShouldBeNil)
+
+		for i := 0; i < 2; i++ {
+			_, err := acl.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		So(len(lines), ShouldEqual, 2)
+
+		var line map[string]interface{}
+
+		So(json.Unmarshal([]byte(lines[0]), &line), ShouldBeNil)
+		So(line["actor_id"], ShouldEqual, userA.GetId())
+		So(line["action"], ShouldEqual, "testing")
+		So(line["allowed"], ShouldEqual, true)
+		So(line["bypassed"], ShouldEqual, false)
+		So(line["time"], ShouldNotBeNil)
+		So(line["latency_ms"], ShouldNotBeNil)
+		So(line["target_id"], ShouldBeNil)
+		So(line["error"], ShouldBeNil)
+	})
+}
@@ -0,0 +1,181 @@
+package acl
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Permission is the effective decision for an action on a target, TargetId
+// being EMPTY_RESOURCE for the actor-wide decision which applies to all other
+// targets. Conditional is true if a row with a condition applies, the decision
+// is the one of a check without attributes and might differ for a check with
+// them, see AllowsActionWith
+type Permission struct {
+	Action      string
+	TargetId    string
+	Conditional bool
+	Decision
+}
+
+// EffectivePermissions lists every action and target pair which has a row
+// applying to actor, directly or through inheritance, along with the decision
+// for it. The targets inheriting from the target of a row in the target tree
+// are listed as well, as are the actions implied by the action of a row and
+// the known actions a wildcard row matches: those of the other rows and of the
+// implications. A wildcard row is also listed under its pattern, with the
+// decision for the actions it matches which are not listed. The list is
+// ordered by action, the EMPTY_RESOURCE target first
+func (acl *ACL) EffectivePermissions(ctx context.Context, q Querier, actor Resource) ([]Permission, error) {
+	candidates, err := acl.store.Candidates(ctx, q, Query{ActorId: actor.GetId(), At: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+
+	seenRoots := make(map[string]struct{})
+	var roots []string
+
+	for _, c := range candidates {
+		if _, ok := seenRoots[c.TargetId]; !ok && c.TargetId != EMPTY_RESOURCE {
+			seenRoots[c.TargetId] = struct{}{}
+			roots = append(roots, c.TargetId)
+		}
+	}
+
+	levels, err := acl.store.TargetDescendants(ctx, q, roots, Page{})
+	if err != nil {
+		return nil, err
+	}
+
+	levels[EMPTY_RESOURCE] = map[string]int{EMPTY_RESOURCE: 0}
+
+	/* The targets covered by the rows on each target */
+	covered := make(map[string][]string)
+
+	for targetId, ancestors := range levels {
+		for id := range ancestors {
+			covered[id] = append(covered[id], targetId)
+		}
+	}
+
+	known := acl.knownActions(candidates)
+
+	type pair struct {
+		action   string
+		targetId string
+	}
+
+	seen := make(map[pair]struct{})
+	var ret []Permission
+
+	for _, c := range candidates {
+		for _, action := range acl.coveredActions(c, known) {
+			for _, targetId := range covered[c.TargetId] {
+				p := pair{action, targetId}
+				if _, ok := seen[p]; ok {
+					continue
+				}
+
+				seen[p] = struct{}{}
+
+				var target Resource = idResource(targetId)
+				if targetId == EMPTY_RESOURCE {
+					target = &NilResource{}
+				}
+
+				if acl.bypassFunc != nil && acl.bypassFunc(actor, action, target) {
+					ret = append(ret, Permission{Action: action, TargetId: targetId, Decision: Decision{Allowed: true, Bypassed: true}})
+
+					continue
+				}
+
+				decision, err := acl.resolve(candidates, action, levels[targetId], Attributes(nil).with(actor.GetId(), targetId))
+				if err != nil {
+					return nil, err
+				}
+
+				ret = append(ret, Permission{Action: action, TargetId: targetId, Conditional: acl.conditional(candidates, action, levels[targetId]), Decision: decision})
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Action != ret[j].Action {
+			return ret[i].Action < ret[j].Action
+		}
+
+		if (ret[i].TargetId == EMPTY_RESOURCE) != (ret[j].TargetId == EMPTY_RESOURCE) {
+			return ret[i].TargetId == EMPTY_RESOURCE
+		}
+
+		return ret[i].TargetId < ret[j].TargetId
+	})
+
+	return ret, nil
+}
+
+// knownActions returns the actions of the candidates and of the implications
+// which are not wildcard patterns
+func (acl *ACL) knownActions(candidates []Candidate) []string {
+	seen := make(map[string]struct{})
+	var ret []string
+
+	add := func(a string) {
+		if _, ok := seen[a]; !ok && !strings.HasSuffix(a, ACTION_WILDCARD) {
+			seen[a] = struct{}{}
+			ret = append(ret, a)
+		}
+	}
+
+	for _, c := range candidates {
+		add(c.Action)
+	}
+
+	for action, implied := range acl.implies {
+		add(action)
+
+		for a := range implied {
+			add(a)
+		}
+	}
+
+	return ret
+}
+
+// coveredActions returns the action of the candidate along with the actions
+// it implies and the known actions it matches as a wildcard pattern
+func (acl *ACL) coveredActions(c Candidate, known []string) []string {
+	ret := []string{c.Action}
+
+	/* A row also covers the actions implied by its action */
+	for a := range acl.implies[c.Action] {
+		ret = append(ret, a)
+	}
+
+	for _, a := range known {
+		for _, pattern := range actionPatterns(a) {
+			if pattern == c.Action {
+				ret = append(ret, a)
+			}
+		}
+	}
+
+	return ret
+}
+
+// conditional returns true if a candidate with a condition applies to the
+// action on the targets
+func (acl *ACL) conditional(candidates []Candidate, action string, targets map[string]int) bool {
+	for _, c := range candidates {
+		if _, ok := targets[c.TargetId]; !ok && c.TargetId != EMPTY_RESOURCE {
+			continue
+		}
+
+		if _, ok := acl.actionLevel(c, action); ok && c.Condition != "" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,118 @@
+package acl
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestEffectivePermissions(t *testing.T) {
+	ctx := context.Background()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+	testResourceB := idAble{id: "b5bd4f2e-4c2e-4e5a-9f0c-0d2a3f4e6b71"}
+
+	Convey("When A inherits from B", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+
+		Convey("Without any grants nothing should be listed", func() {
+			permissions, err := acl.EffectivePermissions(ctx, nil, userA)
+			So(err, ShouldBeNil)
+			So(len(permissions), ShouldEqual, 0)
+		})
+
+		Convey("With grants on both A and B", func() {
+			So(acl.SetActionAllowed(nil, userB, "edit", true), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userB, "read", true), ShouldBeNil)
+			So(acl.SetActionAllowedOn(nil, userA, "edit", testResourceA, false), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userA, "read", false), ShouldBeNil)
+
+			permissions, err := acl.EffectivePermissions(ctx, nil, userA)
+			So(err, ShouldBeNil)
+			So(len(permissions), ShouldEqual, 3)
+
+			Convey("The group grant should apply to every target", func() {
+				So(permissions[0].Action, ShouldEqual, "edit")
+				So(permissions[0].TargetId, ShouldEqual, EMPTY_RESOURCE)
+				So(permissions[0].Allowed, ShouldEqual, true)
+				So(permissions[0].Source.ActorId, ShouldEqual, userB.GetId())
+				So(permissions[0].Source.Path, ShouldResemble, []string{userA.GetId(), userB.GetId()})
+			})
+
+			Convey("The direct override should deny the target and shadow the group grant", func() {
+				So(permissions[1].Action, ShouldEqual, "edit")
+				So(permissions[1].TargetId, ShouldEqual, testResourceA.GetId())
+				So(permissions[1].Allowed, ShouldEqual, false)
+				So(permissions[1].Source.ActorId, ShouldEqual, userA.GetId())
+				So(len(permissions[1].Shadowed), ShouldEqual, 1)
+				So(permissions[1].Shadowed[0].ActorId, ShouldEqual, userB.GetId())
+			})
+
+			Convey("The direct actor-wide deny should override the group grant", func() {
+				So(permissions[2].Action, ShouldEqual, "read")
+				So(permissions[2].TargetId, ShouldEqual, EMPTY_RESOURCE)
+				So(permissions[2].Allowed, ShouldEqual, false)
+				So(permissions[2].Source.ActorId, ShouldEqual, userA.GetId())
+			})
+
+			Convey("Every permission should match AllowsActionOn()", func() {
+				for _, p := range permissions {
+					allowed, err := acl.AllowsActionOn(nil, userA, p.Action, idAble{id: p.TargetId})
+					So(err, ShouldBeNil)
+					So(allowed, ShouldEqual, p.Allowed)
+				}
+			})
+		})
+
+		Convey("A target inheriting from the target of a grant should be listed", func() {
+			So(acl.SetTargetInherits(nil, testResourceB, testResourceA), ShouldBeNil)
+			So(acl.SetActionAllowedOn(nil, userB, "edit", testResourceA, true), ShouldBeNil)
+
+			permissions, err := acl.EffectivePermissions(ctx, nil, userA)
+			So(err, ShouldBeNil)
+			So(len(permissions), ShouldEqual, 2)
+			So(permissions[0].TargetId, ShouldEqual, testResourceA.GetId())
+			So(permissions[1].TargetId, ShouldEqual, testResourceB.GetId())
+			So(permissions[1].Allowed, ShouldEqual, true)
+			So(permissions[1].Source.TargetLevel, ShouldEqual, 1)
+		})
+
+		Convey("A wildcard grant should be expanded into the known actions it matches", func() {
+			acl := acl.WithImplications(Implications{"document:edit": {"document:view"}})
+
+			So(acl.SetActionAllowed(nil, userB, "document:*", true), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userA, "document:delete", false), ShouldBeNil)
+
+			permissions, err := acl.EffectivePermissions(ctx, nil, userA)
+			So(err, ShouldBeNil)
+
+			actions := make(map[string]bool)
+
+			for _, p := range permissions {
+				actions[p.Action] = p.Allowed
+			}
+
+			So(actions, ShouldResemble, map[string]bool{"document:*": true, "document:delete": false, "document:edit": true, "document:view": true})
+		})
+
+		Convey("A conditional grant should be marked", func() {
+			So(acl.SetActionAllowedIf(nil, userB, "edit", true, `request.ip in 10.0.0.0/8`), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userB, "read", true), ShouldBeNil)
+
+			permissions, err := acl.EffectivePermissions(ctx, nil, userA)
+			So(err, ShouldBeNil)
+			So(len(permissions), ShouldEqual, 2)
+			So(permissions[0].Action, ShouldEqual, "edit")
+			So(permissions[0].Allowed, ShouldEqual, false)
+			So(permissions[0].Conditional, ShouldEqual, true)
+			So(permissions[1].Action, ShouldEqual, "read")
+			So(permissions[1].Conditional, ShouldEqual, false)
+		})
+	})
+}
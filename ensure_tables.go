@@ -28,7 +28,7 @@ var tpl_tree_insert_trigger_function = `
 CREATE OR REPLACE FUNCTION $TABLE_PreventCycles()
   RETURNS "trigger" AS $$
 BEGIN
-	IF EXISTS (WITH RECURSIVE q AS (
+	IF NEW."id" = NEW."parent_id" OR EXISTS (WITH RECURSIVE q AS (
 			SELECT q."parent_id", ARRAY[NEW."parent_id"] path
 			FROM "$TABLE" q
 			WHERE q."id" = NEW."parent_id"
@@ -39,7 +39,7 @@ BEGIN
 		)
 		SELECT q.parent_id FROM q
 		WHERE NEW.id = q.parent_id) THEN
-		RAISE EXCEPTION 'Cycles are not allowed in "$TABLE"';
+		RAISE EXCEPTION 'Cycles are not allowed in "$TABLE"' USING ERRCODE = 'AC001';
 	END IF;
 	RETURN NEW;
 END;
@@ -66,32 +66,193 @@ var tpl_acl_table = `CREATE TABLE "$TABLE"
 	PRIMARY KEY ("actor_id", "action", "target_id")
 );`
 
+var tpl_roles_table = `CREATE TABLE "$TABLE_Roles"
+(
+	"role" character varying(255) NOT NULL,
+	"action" character varying(255) NOT NULL,
+	PRIMARY KEY ("role", "action")
+);`
+
+var tpl_role_assignments_table = `CREATE TABLE "$TABLE_RoleAssignments"
+(
+	"actor_id" uuid NOT NULL,
+	"role" character varying(255) NOT NULL,
+	"target_id" uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000',
+	PRIMARY KEY ("actor_id", "role", "target_id")
+);`
+
+var tpl_validity_columns = `
+ALTER TABLE "$TABLE"
+	ADD COLUMN "valid_from" timestamp with time zone,
+	ADD COLUMN "valid_until" timestamp with time zone;`
+
+var tpl_condition_column = `
+ALTER TABLE "$TABLE"
+	ADD COLUMN "condition" text NOT NULL DEFAULT '';`
+
 var tpl_insert_rule = `
-CREATE RULE "$TABLE_INSERT" AS ON INSERT TO "$TABLE"
+CREATE OR REPLACE RULE "$TABLE_INSERT" AS ON INSERT TO "$TABLE"
 	WHERE EXISTS(SELECT 1 FROM "$TABLE"
 		WHERE (actor_id, action, target_id) = (NEW.actor_id, NEW.action, NEW.target_id))
-	DO INSTEAD UPDATE "$TABLE" SET allowed = NEW.allowed WHERE (actor_id, action, target_id) = (NEW.actor_id, NEW.action, NEW.target_id);`
+	DO INSTEAD UPDATE "$TABLE" SET allowed = NEW.allowed,
+		valid_from = CASE WHEN NEW.valid_from IS NULL AND NEW.valid_until IS NULL AND NEW.condition = '' THEN "$TABLE".valid_from ELSE NEW.valid_from END,
+		valid_until = CASE WHEN NEW.valid_from IS NULL AND NEW.valid_until IS NULL AND NEW.condition = '' THEN "$TABLE".valid_until ELSE NEW.valid_until END,
+		condition = CASE WHEN NEW.valid_from IS NULL AND NEW.valid_until IS NULL AND NEW.condition = '' THEN "$TABLE".condition ELSE NEW.condition END
+	WHERE (actor_id, action, target_id) = (NEW.actor_id, NEW.action, NEW.target_id);`
 
 var tpl_link_delete_trigger = `
 CREATE RULE "{aclTable}_{linkType}_{relatedTable}_DELETED" AS ON DELETE TO "{relatedTable}"
 	DO ALSO DELETE FROM "{aclTable}" WHERE "{localKey}" = old."{relatedKey}";`
 
+var tpl_notify_function = `
+CREATE OR REPLACE FUNCTION {channel}_Notify()
+  RETURNS "trigger" AS $$
+BEGIN
+	IF TG_OP <> 'INSERT' THEN
+		PERFORM pg_notify(TG_ARGV[0], TG_ARGV[1] || ':' || COALESCE(row_to_json(OLD)->>TG_ARGV[2], ''));
+	END IF;
+	IF TG_OP <> 'DELETE' THEN
+		PERFORM pg_notify(TG_ARGV[0], TG_ARGV[1] || ':' || COALESCE(row_to_json(NEW)->>TG_ARGV[2], ''));
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE 'plpgsql' VOLATILE;
+`
+
+var tpl_notify_trigger = `
+CREATE TRIGGER {table}_NotifyTrigger
+	AFTER INSERT OR UPDATE OR DELETE
+	ON "{table}"
+	FOR EACH ROW
+	EXECUTE PROCEDURE {channel}_Notify('{channel}', '{kind}', '{column}');`
+
+var tpl_audit_table = `CREATE TABLE "$TABLE_Audit"
+(
+	"id" bigserial NOT NULL,
+	"changed_at" timestamp with time zone NOT NULL DEFAULT clock_timestamp(),
+	"changed_by" text,
+	"kind" character varying(255) NOT NULL,
+	"operation" character varying(6) NOT NULL,
+	"actor_id" uuid,
+	"action" character varying(255),
+	"target_id" uuid,
+	"old_row" jsonb,
+	"new_row" jsonb,
+	PRIMARY KEY ("id")
+);`
+
+var tpl_audit_function = `
+CREATE OR REPLACE FUNCTION $TABLE_Audit()
+  RETURNS "trigger" AS $$
+DECLARE
+	old_row jsonb;
+	new_row jsonb;
+	r jsonb;
+BEGIN
+	IF TG_OP <> 'INSERT' THEN
+		old_row := to_jsonb(OLD);
+	END IF;
+	IF TG_OP <> 'DELETE' THEN
+		new_row := to_jsonb(NEW);
+	END IF;
+	r := COALESCE(new_row, old_row);
+	INSERT INTO "$TABLE_Audit" ("changed_by", "kind", "operation", "actor_id", "action", "target_id", "old_row", "new_row")
+		VALUES (NULLIF(current_setting('acl.changed_by', true), ''), TG_ARGV[0], TG_OP, (r->>TG_ARGV[1])::uuid, r->>TG_ARGV[2], (r->>TG_ARGV[3])::uuid, old_row, new_row);
+	RETURN NULL;
+END;
+$$ LANGUAGE 'plpgsql' VOLATILE;
+`
+
+var tpl_audit_trigger = `
+CREATE TRIGGER {table}_AuditTrigger
+	AFTER INSERT OR UPDATE OR DELETE
+	ON "{table}"
+	FOR EACH ROW
+	EXECUTE PROCEDURE {aclTable}_Audit('{kind}', '{actor}', '{action}', '{target}');`
+
+var tpl_audit_append_only_function = `
+CREATE OR REPLACE FUNCTION $TABLE_AuditAppendOnly()
+  RETURNS "trigger" AS $$
+BEGIN
+	RAISE EXCEPTION 'The audit log "$TABLE_Audit" is append-only' USING ERRCODE = 'AC002';
+END;
+$$ LANGUAGE 'plpgsql' VOLATILE;
+`
+
+var tpl_audit_append_only_trigger = `
+CREATE TRIGGER $TABLE_AuditAppendOnlyTrigger
+	BEFORE UPDATE OR DELETE
+	ON "$TABLE_Audit"
+	FOR EACH ROW
+	EXECUTE PROCEDURE $TABLE_AuditAppendOnly();`
+
+var tpl_history_table = `
+CREATE TABLE "{table}_History" AS
+	SELECT {columns}, NULL::timestamp with time zone "recorded_from", NULL::timestamp with time zone "recorded_until"
+	FROM "{table}";`
+
+var tpl_history_index = `
+CREATE INDEX "{table}_History_Keys" ON "{table}_History" ({keys});`
+
+var tpl_history_function = `
+CREATE OR REPLACE FUNCTION {table}_History()
+  RETURNS "trigger" AS $$
+DECLARE
+	changed_at timestamp with time zone := clock_timestamp();
+BEGIN
+	IF TG_OP = 'TRUNCATE' THEN
+		UPDATE "{table}_History" SET "recorded_until" = changed_at WHERE "recorded_until" IS NULL;
+		RETURN NULL;
+	END IF;
+	IF TG_OP <> 'INSERT' THEN
+		UPDATE "{table}_History" SET "recorded_until" = changed_at WHERE "recorded_until" IS NULL AND ({keys}) = ({oldKeys});
+	END IF;
+	IF TG_OP <> 'DELETE' THEN
+		INSERT INTO "{table}_History" ({columns}, "recorded_from") VALUES ({newColumns}, changed_at);
+	END IF;
+	RETURN NULL;
+END;
+$$ LANGUAGE 'plpgsql' VOLATILE;
+`
+
+var tpl_history_triggers = []string{`
+CREATE TRIGGER {table}_HistoryTrigger
+	AFTER INSERT OR UPDATE OR DELETE
+	ON "{table}"
+	FOR EACH ROW
+	EXECUTE PROCEDURE {table}_History();`, `
+CREATE TRIGGER {table}_HistoryTruncateTrigger
+	AFTER TRUNCATE
+	ON "{table}"
+	FOR EACH STATEMENT
+	EXECUTE PROCEDURE {table}_History();`}
+
 // EnsureTableAndRulesAreCreated checks if the table and rules required to run the ACL exists,
-// if they do not they will be created
+// if they do not they will be created. Changes to the tables are notified on
+// the channel named after table, see Cache, recorded in the audit log, see
+// ACL.AuditLog, and versioned in history tables, see ACL.AllowsActionOnAt
 func EnsureTablesAndRulesExist(db *sql.DB, treeTable string, table string, cascades Cascades) error {
 	t, err := db.Begin()
 	if err != nil {
 		return err
 	}
 
-	exists, err := tableExists(t, treeTable)
+	err = ensureTree(t, treeTable, cascades.Actors)
+	if err != nil {
+		t.Rollback()
+
+		return err
+	}
+
+	exists, err := tableExists(t, table)
 	if err != nil {
 		t.Rollback()
 
 		return err
 	}
 	if ! exists {
-		_, err = t.Exec(strings.Replace(tpl_tree_table, "$TABLE", treeTable, -1))
+		/* No row, insert new table */
+		_, err = t.Exec(strings.Replace(tpl_acl_table, "$TABLE", table, -1))
 		if err != nil {
 			t.Rollback()
 
@@ -99,75 +260,289 @@ func EnsureTablesAndRulesExist(db *sql.DB, treeTable string, table string, casca
 		}
 	}
 
-	_, err = t.Exec(strings.Replace(tpl_tree_insert_trigger_function, "$TABLE", treeTable, -1))
+	err = ensureColumn(t, treeTable, "valid_until", tpl_validity_columns)
 	if err != nil {
-		fmt.Printf("%v\n", err)
 		t.Rollback()
 
 		return err
 	}
 
-	_, err = t.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_PreventCyclesTrigger ON "%s";`, treeTable, treeTable))
+	err = ensureColumn(t, table, "valid_until", tpl_validity_columns)
 	if err != nil {
 		t.Rollback()
 
 		return err
 	}
 
-	_, err = t.Exec(strings.Replace(tpl_tree_insert_trigger, "$TABLE", treeTable, -1))
+	err = ensureColumn(t, table, "condition", tpl_condition_column)
 	if err != nil {
 		t.Rollback()
 
 		return err
 	}
 
-	err = ensureTreeLinks(t, treeTable, cascades.Actors)
+	/* Always replaced, so existing tables get the rule for the current columns */
+	_, err = t.Exec(strings.Replace(tpl_insert_rule, "$TABLE", table, -1))
 	if err != nil {
 		t.Rollback()
 
 		return err
 	}
 
-	exists, err = tableExists(t, table)
+	err = ensureLinks(t, table, cascades.Actors, "ACTOR", "actor_id")
+	if err != nil {
+		t.Rollback()
+
+		return err
+	}
+
+	err = ensureLinks(t, table, cascades.Targets, "TARGET", "target_id")
+	if err != nil {
+		t.Rollback()
+
+		return err
+	}
+
+	err = ensureRoles(t, table, cascades)
+	if err != nil {
+		t.Rollback()
+
+		return err
+	}
+
+	err = ensureNotifications(t, treeTable, table)
+	if err != nil {
+		t.Rollback()
+
+		return err
+	}
+
+	err = ensureAudit(t, treeTable, table)
+	if err != nil {
+		t.Rollback()
+
+		return err
+	}
+
+	err = ensureHistory(t, treeTable, table)
 	if err != nil {
 		t.Rollback()
 
 		return err
 	}
+
+	return t.Commit()
+}
+
+// ensureAudit creates the append-only audit log and the triggers filling it
+func ensureAudit(t *sql.Tx, treeTable string, table string) error {
+	exists, err := tableExists(t, table+"_Audit")
+	if err != nil {
+		return err
+	}
 	if ! exists {
-		/* No row, insert new table */
-		_, err = t.Exec(strings.Replace(tpl_acl_table, "$TABLE", table, -1))
+		_, err = t.Exec(strings.Replace(tpl_audit_table, "$TABLE", table, -1))
 		if err != nil {
-			t.Rollback()
+			return err
+		}
+	}
 
+	for _, tpl := range []string{tpl_audit_function, tpl_audit_append_only_function} {
+		_, err = t.Exec(strings.Replace(tpl, "$TABLE", table, -1))
+		if err != nil {
 			return err
 		}
 	}
 
-	exists, err = ruleExists(t, table, table+"_INSERT")
+	_, err = t.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_AuditAppendOnlyTrigger ON "%s_Audit";`, table, table))
 	if err != nil {
-		t.Rollback()
+		return err
+	}
 
+	_, err = t.Exec(strings.Replace(tpl_audit_append_only_trigger, "$TABLE", table, -1))
+	if err != nil {
 		return err
 	}
-	if ! exists {
-		/* No row, insert new rule */
-		_, err = t.Exec(strings.Replace(tpl_insert_rule, "$TABLE", table, -1))
+
+	for _, audited := range auditedTables(treeTable, table) {
+		err = ensureAuditTrigger(t, table, audited)
 		if err != nil {
-			t.Rollback()
+			return err
+		}
+	}
+
+	return nil
+}
 
+// ensureAuditTrigger creates the trigger recording the changes of the audited
+// table in the audit log of the ACL table
+func ensureAuditTrigger(t *sql.Tx, table string, audited auditedTable) error {
+	replacer := strings.NewReplacer("{table}", audited.table, "{aclTable}", table, "{kind}", audited.kind, "{actor}", audited.actor, "{action}", audited.action, "{target}", audited.target)
+
+	_, err := t.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_AuditTrigger ON "%s";`, audited.table, audited.table))
+	if err != nil {
+		return err
+	}
+
+	_, err = t.Exec(replacer.Replace(tpl_audit_trigger))
+
+	return err
+}
+
+// ensureHistory creates the history tables of the audited tables, recording
+// every version of their rows along with when it was current, and the
+// triggers maintaining them
+func ensureHistory(t *sql.Tx, treeTable string, table string) error {
+	for _, audited := range auditedTables(treeTable, table) {
+		replacer := strings.NewReplacer("{table}", audited.table, "{columns}", historyColumns(audited.columns, `"`, `"`),
+			"{keys}", historyColumns(audited.keys, `"`, `"`), "{oldKeys}", historyColumns(audited.keys, `OLD."`, `"`), "{newColumns}", historyColumns(audited.columns, `NEW."`, `"`))
+
+		exists, err := tableExists(t, audited.table+"_History")
+		if err != nil {
+			return err
+		}
+		if ! exists {
+			/* Rows predating the history are recorded as current since forever */
+			for _, tpl := range []string{tpl_history_table, tpl_history_index} {
+				_, err = t.Exec(replacer.Replace(tpl))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		_, err = t.Exec(replacer.Replace(tpl_history_function))
+		if err != nil {
 			return err
 		}
+
+		for _, trigger := range []string{"HistoryTrigger", "HistoryTruncateTrigger"} {
+			_, err = t.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_%s ON "%s";`, audited.table, trigger, audited.table))
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, tpl := range tpl_history_triggers {
+			_, err = t.Exec(replacer.Replace(tpl))
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	err = ensureLinks(t, table, cascades.Actors, "ACTOR", "actor_id")
+	return nil
+}
+
+// ensureColumn adds the columns of the template to the table if the column is
+// missing, migrating tables created by earlier versions in place
+func ensureColumn(t *sql.Tx, tableName string, columnName string, tpl string) error {
+	exists, err := columnExists(t, tableName, columnName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	_, err = t.Exec(strings.Replace(tpl, "$TABLE", tableName, -1))
+
+	return err
+}
+
+// ensureRoles creates the tables containing the roles and their assignments
+func ensureRoles(t *sql.Tx, table string, cascades Cascades) error {
+	for tableName, tpl := range map[string]string{table+"_Roles": tpl_roles_table, table+"_RoleAssignments": tpl_role_assignments_table} {
+		exists, err := tableExists(t, tableName)
+		if err != nil {
+			return err
+		}
+		if ! exists {
+			_, err = t.Exec(strings.Replace(tpl, "$TABLE", table, -1))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := ensureLinks(t, table+"_RoleAssignments", cascades.Actors, "ACTOR", "actor_id")
+	if err != nil {
+		return err
+	}
+
+	return ensureLinks(t, table+"_RoleAssignments", cascades.Targets, "TARGET", "target_id")
+}
+
+// ensureNotifications creates the triggers notifying the channel named after
+// the ACL table of every change, see Cache
+func ensureNotifications(t *sql.Tx, treeTable string, table string) error {
+	triggers := []struct {
+		table  string
+		kind   string
+		column string
+	}{
+		{table, "actor", "actor_id"},
+		{treeTable, "actor", "id"},
+		{table + "_RoleAssignments", "actor", "actor_id"},
+		{table + "_Roles", "role", "role"},
+	}
+
+	for _, trigger := range triggers {
+		err := ensureNotify(t, trigger.table, table, trigger.kind, trigger.column)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureNotify creates the trigger notifying channel with a payload of kind
+// and the value of column for every changed row of tableName
+func ensureNotify(t *sql.Tx, tableName string, channel string, kind string, column string) error {
+	replacer := strings.NewReplacer("{table}", tableName, "{channel}", channel, "{kind}", kind, "{column}", column)
+
+	_, err := t.Exec(replacer.Replace(tpl_notify_function))
+	if err != nil {
+		return err
+	}
+
+	_, err = t.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_NotifyTrigger ON "%s";`, tableName, tableName))
+	if err != nil {
+		return err
+	}
+
+	_, err = t.Exec(replacer.Replace(tpl_notify_trigger))
+
+	return err
+}
+
+// EnsureTargetTreeExists checks if the table and trigger required for the
+// target tree of NewWithTargetTree exist, if they do not they will be created.
+// The links cascade DELETES of targets into the target tree, changes are
+// notified on the channel named after targetTreeTable, see Cache, and recorded
+// in the audit log of table, which EnsureTablesAndRulesExist must have created
+func EnsureTargetTreeExists(db *sql.DB, targetTreeTable string, table string, links []Link) error {
+	t, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = ensureTree(t, targetTreeTable, links)
 	if err != nil {
 		t.Rollback()
 
 		return err
 	}
 
-	err = ensureLinks(t, table, cascades.Targets, "TARGET", "target_id")
+	err = ensureAuditTrigger(t, table, targetTreeAudit(targetTreeTable))
+	if err != nil {
+		t.Rollback()
+
+		return err
+	}
+
+	err = ensureNotify(t, targetTreeTable, targetTreeTable, "target", "id")
 	if err != nil {
 		t.Rollback()
 
@@ -177,6 +552,37 @@ func EnsureTablesAndRulesExist(db *sql.DB, treeTable string, table string, casca
 	return t.Commit()
 }
 
+// ensureTree creates the tree table and its trigger preventing cycles
+func ensureTree(t *sql.Tx, treeTable string, links []Link) error {
+	exists, err := tableExists(t, treeTable)
+	if err != nil {
+		return err
+	}
+	if ! exists {
+		_, err = t.Exec(strings.Replace(tpl_tree_table, "$TABLE", treeTable, -1))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = t.Exec(strings.Replace(tpl_tree_insert_trigger_function, "$TABLE", treeTable, -1))
+	if err != nil {
+		return err
+	}
+
+	_, err = t.Exec(fmt.Sprintf(`DROP TRIGGER IF EXISTS %s_PreventCyclesTrigger ON "%s";`, treeTable, treeTable))
+	if err != nil {
+		return err
+	}
+
+	_, err = t.Exec(strings.Replace(tpl_tree_insert_trigger, "$TABLE", treeTable, -1))
+	if err != nil {
+		return err
+	}
+
+	return ensureTreeLinks(t, treeTable, links)
+}
+
 // tableExists returns true if the supplied table name exists
 func tableExists(t *sql.Tx, tableName string) (bool, error) {
 	numRows := 0
@@ -190,6 +596,19 @@ func tableExists(t *sql.Tx, tableName string) (bool, error) {
 	return numRows == 1, nil
 }
 
+// columnExists returns true if the supplied table has the column
+func columnExists(t *sql.Tx, tableName string, columnName string) (bool, error) {
+	numRows := 0
+	row := t.QueryRow("SELECT COUNT(1) FROM information_schema.columns WHERE table_name = $1 AND column_name = $2", tableName, columnName)
+
+	err := row.Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+
+	return numRows == 1, nil
+}
+
 // ruleExists returs true if the supplied rule exists on the given table
 func ruleExists(t *sql.Tx, tableName string, ruleName string) (bool, error) {
 	numRows := 0
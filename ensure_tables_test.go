@@ -4,9 +4,11 @@ import (
 	"os"
 	"fmt"
 	"database/sql"
+	"strings"
 	"testing"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -22,7 +24,9 @@ func TestEnsureTablesAndRulesExist(t *testing.T) {
 		requiressl = "require"
 	}
 
-	db, err := sql.Open("postgres", fmt.Sprintf("postgres://%v:%v@%v:%v/%v?sslmode=%v", os.Getenv("PGUSER"), os.Getenv("PGPASSWORD"), os.Getenv("PGHOST"), os.Getenv("PGPORT"), os.Getenv("PGDATABASE"), requiressl))
+	dsn := fmt.Sprintf("postgres://%v:%v@%v:%v/%v?sslmode=%v", os.Getenv("PGUSER"), os.Getenv("PGPASSWORD"), os.Getenv("PGHOST"), os.Getenv("PGPORT"), os.Getenv("PGDATABASE"), requiressl)
+
+	db, err := sql.Open("postgres", dsn)
 
 	if err != nil {
 		panic(err)
@@ -151,7 +155,7 @@ func TestEnsureTablesAndRulesExist(t *testing.T) {
 
 				So(err, ShouldBeNil)
 
-				row = db.QueryRow(`SELECT * FROM "ACLTest"`)
+				row = db.QueryRow(`SELECT "actor_id", "action", "target_id", "allowed" FROM "ACLTest"`)
 
 				actor_id := ""
 				action := ""
@@ -188,7 +192,7 @@ func TestEnsureTablesAndRulesExist(t *testing.T) {
 				So(err, ShouldBeNil)
 				So(numRows, ShouldEqual, 1)
 
-				row = db.QueryRow(`SELECT * FROM "ACLTest"`)
+				row = db.QueryRow(`SELECT "actor_id", "action", "target_id", "allowed" FROM "ACLTest"`)
 
 				actor_id := ""
 				action := ""
@@ -270,7 +274,7 @@ func TestEnsureTablesAndRulesExist(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(numRows, ShouldEqual, 1)
 
-			row = db.QueryRow(`SELECT * FROM "ACLTest"`)
+			row = db.QueryRow(`SELECT "actor_id", "action", "target_id", "allowed" FROM "ACLTest"`)
 
 			actor_id := ""
 			action := ""
@@ -326,7 +330,7 @@ func TestEnsureTablesAndRulesExist(t *testing.T) {
 			So(err, ShouldBeNil)
 			So(numRows, ShouldEqual, 1)
 
-			row = db.QueryRow(`SELECT * FROM "ACLTest"`)
+			row = db.QueryRow(`SELECT "actor_id", "action", "target_id", "allowed" FROM "ACLTest"`)
 
 			actor_id := ""
 			action := ""
@@ -363,6 +367,111 @@ func TestEnsureTablesAndRulesExist(t *testing.T) {
 		})
 	})
 
+	Convey("When the tables were created without validity", t, func() {
+		clean(db)
+
+		_, err := db.Exec(strings.Replace(tpl_acl_table, "$TABLE", "ACLTest", -1))
+		So(err, ShouldBeNil)
+
+		_, err = db.Exec(`INSERT INTO "ACLTest" ("actor_id", "action", "target_id", "allowed") VALUES($1, $2, $3, $4)`, uuid1, "testing", uuid2, true)
+		So(err, ShouldBeNil)
+
+		Convey("EnsureTableAndRulesAreCreated() should add the columns in place", func() {
+			err := EnsureTablesAndRulesExist(db, "ACLTestTree", "ACLTest", Cascades{})
+			So(err, ShouldBeNil)
+
+			for _, table := range []string{"ACLTest", "ACLTestTree"} {
+				row := db.QueryRow("SELECT COUNT(1) FROM information_schema.columns WHERE table_name = $1 AND column_name IN ('valid_from', 'valid_until')", table)
+
+				numRows := 0
+				So(row.Scan(&numRows), ShouldBeNil)
+				So(numRows, ShouldEqual, 2)
+			}
+
+			Convey("And inserts should update the validity of existing rows", func() {
+				_, err := db.Exec(`INSERT INTO "ACLTest" ("actor_id", "action", "target_id", "allowed", "valid_until") VALUES($1, $2, $3, $4, now())`, uuid1, "testing", uuid2, true)
+				So(err, ShouldBeNil)
+
+				row := db.QueryRow(`SELECT COUNT(1) FROM "ACLTest" WHERE "valid_until" IS NOT NULL`)
+
+				numRows := 0
+				So(row.Scan(&numRows), ShouldBeNil)
+				So(numRows, ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("When listening to the channel of the ACL table", t, func() {
+		clean(db)
+
+		So(EnsureTablesAndRulesExist(db, "ACLTestTree", "ACLTest", Cascades{}), ShouldBeNil)
+
+		listener := pq.NewListener(dsn, time.Second, time.Minute, nil)
+		defer listener.Close()
+
+		So(listener.Listen("ACLTest"), ShouldBeNil)
+
+		Convey("Inserts should notify the actor of the row", func() {
+			_, err := db.Exec(`INSERT INTO "ACLTest" ("actor_id", "action", "target_id", "allowed") VALUES($1, $2, $3, $4)`, uuid1, "testing", uuid2, true)
+			So(err, ShouldBeNil)
+
+			select {
+			case n := <-listener.Notify:
+				So(n.Extra, ShouldEqual, "actor:" + uuid1)
+			case <-time.After(5 * time.Second):
+				So("timeout", ShouldBeNil)
+			}
+		})
+
+		Convey("Inheritance should notify the inheriting actor", func() {
+			_, err := db.Exec(`INSERT INTO "ACLTestTree" ("id", "parent_id") VALUES($1, $2)`, uuid3, uuid4)
+			So(err, ShouldBeNil)
+
+			select {
+			case n := <-listener.Notify:
+				So(n.Extra, ShouldEqual, "actor:" + uuid3)
+			case <-time.After(5 * time.Second):
+				So("timeout", ShouldBeNil)
+			}
+		})
+	})
+
+	Convey("When changing the ACL table", t, func() {
+		clean(db)
+
+		So(EnsureTablesAndRulesExist(db, "ACLTestTree", "ACLTest", Cascades{}), ShouldBeNil)
+
+		_, err := db.Exec(`INSERT INTO "ACLTest" ("actor_id", "action", "target_id", "allowed") VALUES($1, $2, $3, $4)`, uuid1, "testing", uuid2, true)
+		So(err, ShouldBeNil)
+
+		_, err = db.Exec(`DELETE FROM "ACLTest" WHERE "actor_id" = $1`, uuid1)
+		So(err, ShouldBeNil)
+
+		Convey("The changes should be recorded in the audit log", func() {
+			rows, err := db.Query(`SELECT "operation", "actor_id", "action", "target_id" FROM "ACLTest_Audit" ORDER BY "id"`)
+			So(err, ShouldBeNil)
+
+			defer rows.Close()
+
+			var entries []string
+
+			for rows.Next() {
+				var operation, actorId, action, targetId string
+
+				So(rows.Scan(&operation, &actorId, &action, &targetId), ShouldBeNil)
+
+				entries = append(entries, strings.Join([]string{operation, actorId, action, targetId}, " "))
+			}
+
+			So(entries, ShouldResemble, []string{"INSERT " + uuid1 + " testing " + uuid2, "DELETE " + uuid1 + " testing " + uuid2})
+		})
+
+		Convey("The audit log should be append-only", func() {
+			_, err := db.Exec(`DELETE FROM "ACLTest_Audit"`)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
 	/* TODO: Tests to make sure that it does not error if rules already exist */
 	/* TODO: Tests for tree table */
 }
@@ -391,6 +500,14 @@ func createResourceTables(db *sql.DB) {
 func clean(db *sql.DB) {
 	queries := []string{
 		`DROP TABLE IF EXISTS "ACLTest" CASCADE`,
+		`DROP TABLE IF EXISTS "ACLTestTree" CASCADE`,
+		`DROP TABLE IF EXISTS "ACLTest_Roles" CASCADE`,
+		`DROP TABLE IF EXISTS "ACLTest_RoleAssignments" CASCADE`,
+		`DROP TABLE IF EXISTS "ACLTest_Audit" CASCADE`,
+		`DROP TABLE IF EXISTS "ACLTest_History" CASCADE`,
+		`DROP TABLE IF EXISTS "ACLTestTree_History" CASCADE`,
+		`DROP TABLE IF EXISTS "ACLTest_Roles_History" CASCADE`,
+		`DROP TABLE IF EXISTS "ACLTest_RoleAssignments_History" CASCADE`,
 		`DROP TABLE IF EXISTS "ACLTestActors" CASCADE`,
 		`DROP TABLE IF EXISTS "ACLTestTargets" CASCADE`}
 
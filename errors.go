@@ -0,0 +1,247 @@
+package acl
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"time"
+)
+
+// The kinds of errors returned by ACL, checked with errors.Is. The errors of
+// the database drivers are wrapped in an *Error of the matching kind, errors
+// of other kinds are returned unchanged
+var (
+	// ErrCycle is returned when an inheritance would create a cycle in the
+	// actor or target tree
+	ErrCycle = errors.New("acl: cycles are not allowed")
+	// ErrAppendOnly is returned when the entries of the audit log are
+	// modified or removed
+	ErrAppendOnly = errors.New("acl: the audit log is append-only")
+	// ErrSchemaMissing is returned when the tables, or the functions of
+	// PostgreSQL, used by the Store do not exist, see
+	// EnsureTablesAndRulesExist
+	ErrSchemaMissing = errors.New("acl: the ACL tables do not exist")
+	// ErrInvalidResourceID is returned when the id of a resource is not a
+	// valid UUID, where the Store requires one
+	ErrInvalidResourceID = errors.New("acl: invalid resource id")
+	// ErrConnection is returned when the connection to the database failed
+	ErrConnection = errors.New("acl: connection to the database failed")
+	// ErrTargetTreeUnversioned is returned when checking the ACL as it was at
+	// a time for a target inheriting from other targets, the target tree has
+	// no history
+	ErrTargetTreeUnversioned = errors.New("acl: the target tree has no history")
+)
+
+// SQLSTATE codes raised by the triggers created by EnsureTablesAndRulesExist
+const (
+	sqlStateCycle      = "AC001"
+	sqlStateAppendOnly = "AC002"
+)
+
+// Error is an error of the kind Kind caused by the error Err, eg. of the
+// database driver. errors.Is matches both the kind and the errors wrapped by
+// Err
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the cause of the error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of the error
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// sqlStateError is implemented by the errors of the PostgreSQL drivers
+// github.com/lib/pq and github.com/jackc/pgx
+type sqlStateError interface {
+	error
+	SQLState() string
+}
+
+// sqlStateKinds maps the SQLSTATE codes of PostgreSQL to the kinds of errors,
+// the codes of the connection exception class 08 are matched separately
+var sqlStateKinds = map[string]error{
+	sqlStateCycle:      ErrCycle,
+	sqlStateAppendOnly: ErrAppendOnly,
+	"22P02":            ErrInvalidResourceID, // invalid_text_representation, eg. of an uuid
+	"3F000":            ErrSchemaMissing,     // invalid_schema_name
+	"42P01":            ErrSchemaMissing,     // undefined_table
+	"42703":            ErrSchemaMissing,     // undefined_column
+	"42883":            ErrSchemaMissing,     // undefined_function
+	"57P01":            ErrConnection,        // admin_shutdown
+	"57P02":            ErrConnection,        // crash_shutdown
+	"57P03":            ErrConnection,        // cannot_connect_now
+}
+
+// messageKinds maps the messages of MySQL and SQLite, which drivers do not
+// expose their error codes through a common interface, to the kinds of errors.
+// MySQL includes the SQLSTATE in its messages
+var messageKinds = []struct {
+	message string
+	kind    error
+}{
+	{"Cycles are not allowed in", ErrCycle},
+	{"is append-only", ErrAppendOnly},
+	{"no such table:", ErrSchemaMissing},
+	{"no such column:", ErrSchemaMissing},
+	{"(42S02)", ErrSchemaMissing},
+	{"(42S22)", ErrSchemaMissing},
+}
+
+// wrapError wraps the error in an *Error of its kind, if it is known
+func wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	for _, kind := range []error{ErrCycle, ErrAppendOnly, ErrSchemaMissing, ErrInvalidResourceID, ErrConnection} {
+		if errors.Is(err, kind) {
+			return err
+		}
+	}
+
+	if errors.Is(err, driver.ErrBadConn) {
+		return &Error{Kind: ErrConnection, Err: err}
+	}
+
+	var stateErr sqlStateError
+
+	if errors.As(err, &stateErr) {
+		code := stateErr.SQLState()
+
+		if kind, ok := sqlStateKinds[code]; ok {
+			return &Error{Kind: kind, Err: err}
+		}
+
+		if strings.HasPrefix(code, "08") {
+			return &Error{Kind: ErrConnection, Err: err}
+		}
+
+		return err
+	}
+
+	for _, m := range messageKinds {
+		if strings.Contains(err.Error(), m.message) {
+			return &Error{Kind: m.kind, Err: err}
+		}
+	}
+
+	return err
+}
+
+// errorStore wraps the errors of the Store, see wrapError
+type errorStore struct {
+	Store
+}
+
+func (s errorStore) SetGrant(ctx context.Context, q Querier, grant Grant) error {
+	return wrapError(s.Store.SetGrant(ctx, q, grant))
+}
+
+func (s errorStore) UnsetGrant(ctx context.Context, q Querier, actorId string, action string, targetId string) error {
+	return wrapError(s.Store.UnsetGrant(ctx, q, actorId, action, targetId))
+}
+
+func (s errorStore) SetParent(ctx context.Context, q Querier, id string, parentId string, validFrom time.Time, validUntil time.Time) error {
+	return wrapError(s.Store.SetParent(ctx, q, id, parentId, validFrom, validUntil))
+}
+
+func (s errorStore) RemoveParent(ctx context.Context, q Querier, id string, parentId string) error {
+	return wrapError(s.Store.RemoveParent(ctx, q, id, parentId))
+}
+
+func (s errorStore) Parents(ctx context.Context, q Querier, id string) ([]string, error) {
+	ret, err := s.Store.Parents(ctx, q, id)
+
+	return ret, wrapError(err)
+}
+
+func (s errorStore) Children(ctx context.Context, q Querier, id string) ([]string, error) {
+	ret, err := s.Store.Children(ctx, q, id)
+
+	return ret, wrapError(err)
+}
+
+func (s errorStore) Ancestors(ctx context.Context, q Querier, id string) ([]string, error) {
+	ret, err := s.Store.Ancestors(ctx, q, id)
+
+	return ret, wrapError(err)
+}
+
+func (s errorStore) RemoveExpired(ctx context.Context, q Querier, at time.Time) (int64, error) {
+	n, err := s.Store.RemoveExpired(ctx, q, at)
+
+	return n, wrapError(err)
+}
+
+func (s errorStore) Candidates(ctx context.Context, q Querier, query Query) ([]Candidate, error) {
+	ret, err := s.Store.Candidates(ctx, q, query)
+
+	return ret, wrapError(err)
+}
+
+func (s errorStore) TargetCandidates(ctx context.Context, q Querier, query Query) ([]Candidate, map[string]map[string]int, error) {
+	ret, levels, err := s.Store.TargetCandidates(ctx, q, query)
+
+	return ret, levels, wrapError(err)
+}
+
+func (s errorStore) Inheritors(ctx context.Context, q Querier, query Query) ([]Inheritor, error) {
+	ret, err := s.Store.Inheritors(ctx, q, query)
+
+	return ret, wrapError(err)
+}
+
+func (s errorStore) Grants(ctx context.Context, q Querier, query Query) ([]Grant, error) {
+	ret, err := s.Store.Grants(ctx, q, query)
+
+	return ret, wrapError(err)
+}
+
+func (s errorStore) SetRoleActions(ctx context.Context, q Querier, role string, actions []string) error {
+	return wrapError(s.Store.SetRoleActions(ctx, q, role, actions))
+}
+
+func (s errorStore) AssignRole(ctx context.Context, q Querier, actorId string, role string, targetId string) error {
+	return wrapError(s.Store.AssignRole(ctx, q, actorId, role, targetId))
+}
+
+func (s errorStore) RevokeRole(ctx context.Context, q Querier, actorId string, role string, targetId string) error {
+	return wrapError(s.Store.RevokeRole(ctx, q, actorId, role, targetId))
+}
+
+func (s errorStore) SetTargetParent(ctx context.Context, q Querier, id string, parentId string) error {
+	return wrapError(s.Store.SetTargetParent(ctx, q, id, parentId))
+}
+
+func (s errorStore) RemoveTargetParent(ctx context.Context, q Querier, id string, parentId string) error {
+	return wrapError(s.Store.RemoveTargetParent(ctx, q, id, parentId))
+}
+
+func (s errorStore) TargetDescendants(ctx context.Context, q Querier, ids []string, page Page) (map[string]map[string]int, error) {
+	ret, err := s.Store.TargetDescendants(ctx, q, ids, page)
+
+	return ret, wrapError(err)
+}
+
+func (s errorStore) TargetLevels(ctx context.Context, q Querier, ids []string) (map[string]map[string]int, error) {
+	ret, err := s.Store.TargetLevels(ctx, q, ids)
+
+	return ret, wrapError(err)
+}
+
+func (s errorStore) AuditLog(ctx context.Context, q Querier, query AuditQuery) ([]AuditEntry, error) {
+	ret, err := s.Store.AuditLog(ctx, q, query)
+
+	return ret, wrapError(err)
+}
@@ -0,0 +1,87 @@
+package acl
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// stateError is an error with a SQLSTATE code, like the errors of lib/pq
+type stateError struct {
+	code string
+}
+
+func (e stateError) Error() string {
+	return "pq: error " + e.code
+}
+
+func (e stateError) SQLState() string {
+	return e.code
+}
+
+func TestErrors(t *testing.T) {
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	Convey("Creating a cycle in the memory store should return ErrCycle", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+
+		err := acl.SetActorInherits(nil, userB, userA)
+		So(errors.Is(err, ErrCycle), ShouldEqual, true)
+	})
+
+	Convey("wrapError() should map the SQLSTATE codes", t, func() {
+		for code, kind := range map[string]error{
+			"AC001": ErrCycle,
+			"AC002": ErrAppendOnly,
+			"42P01": ErrSchemaMissing,
+			"42883": ErrSchemaMissing,
+			"22P02": ErrInvalidResourceID,
+			"08006": ErrConnection,
+		} {
+			cause := stateError{code: code}
+			err := wrapError(fmt.Errorf("query: %w", cause))
+
+			So(errors.Is(err, kind), ShouldEqual, true)
+
+			var stateErr stateError
+
+			So(errors.As(err, &stateErr), ShouldEqual, true)
+			So(stateErr, ShouldResemble, cause)
+		}
+
+		Convey("And leave other codes unchanged", func() {
+			cause := stateError{code: "23505"}
+
+			So(wrapError(cause), ShouldResemble, cause)
+		})
+	})
+
+	Convey("wrapError() should map the messages of MySQL and SQLite", t, func() {
+		err := wrapError(errors.New(`Error 1644 (45000): Cycles are not allowed in "ACLTree"`))
+		So(errors.Is(err, ErrCycle), ShouldEqual, true)
+
+		err = wrapError(errors.New(`no such table: ACL`))
+		So(errors.Is(err, ErrSchemaMissing), ShouldEqual, true)
+
+		err = wrapError(errors.New(`Error 1146 (42S02): Table 'acl.ACL' doesn't exist`))
+		So(errors.Is(err, ErrSchemaMissing), ShouldEqual, true)
+	})
+
+	Convey("wrapError() should map broken connections", t, func() {
+		So(errors.Is(wrapError(driver.ErrBadConn), ErrConnection), ShouldEqual, true)
+		So(errors.Is(wrapError(driver.ErrBadConn), driver.ErrBadConn), ShouldEqual, true)
+	})
+
+	Convey("wrapError() should not wrap errors twice", t, func() {
+		err := &Error{Kind: ErrCycle, Err: errors.New("cycle")}
+
+		So(wrapError(err), ShouldEqual, err)
+		So(wrapError(nil), ShouldBeNil)
+	})
+}
@@ -0,0 +1,33 @@
+package acl
+
+import (
+	"context"
+)
+
+// Decision explains the outcome of a permission check
+type Decision struct {
+	// Allowed is the outcome of the check
+	Allowed bool
+	// Bypassed is true if the bypassFunc allowed the check, no rows are
+	// consulted in that case
+	Bypassed bool
+	// Source is the row which decided the check along with its level and the
+	// inheritance path to its actor, nil if no row applies and the check is
+	// denied by default
+	Source *Candidate
+	// Shadowed are the other rows which applied to the check but lost to
+	// Source, in order of precedence
+	Shadowed []Candidate
+}
+
+// ExplainAction is like AllowsActionContext but explains how the outcome was
+// reached
+func (acl *ACL) ExplainAction(ctx context.Context, q Querier, actor Resource, action string) (Decision, error) {
+	return acl.checkAt(ctx, q, Query{}, actor, action, nil, nil)
+}
+
+// ExplainActionOn is like AllowsActionOnContext but explains how the outcome
+// was reached
+func (acl *ACL) ExplainActionOn(ctx context.Context, q Querier, actor Resource, action string, target Resource) (Decision, error) {
+	return acl.checkAt(ctx, q, Query{}, actor, action, target, nil)
+}
@@ -0,0 +1,89 @@
+package acl
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestExplain(t *testing.T) {
+	ctx := context.Background()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+	userC := idAble{id: "7be24c16-6376-478d-91c9-f879116d1d49"}
+
+	testResourceA := idAble{id: "e74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	Convey("With an empty in-memory ACL", t, func() {
+		acl := NewMemory()
+
+		Convey("ExplainActionOn() should deny by default without a source", func() {
+			decision, err := acl.ExplainActionOn(ctx, nil, userA, "test", testResourceA)
+			So(err, ShouldBeNil)
+			So(decision.Allowed, ShouldEqual, false)
+			So(decision.Bypassed, ShouldEqual, false)
+			So(decision.Source, ShouldBeNil)
+			So(len(decision.Shadowed), ShouldEqual, 0)
+		})
+
+		Convey("ExplainActionOn() should report a bypassFunc giving true", func() {
+			acl := NewMemoryWithBypass(func(actor Resource, action string, target Resource) bool {
+				return true
+			})
+
+			decision, err := acl.ExplainActionOn(ctx, nil, userA, "test", testResourceA)
+			So(err, ShouldBeNil)
+			So(decision.Allowed, ShouldEqual, true)
+			So(decision.Bypassed, ShouldEqual, true)
+			So(decision.Source, ShouldBeNil)
+		})
+	})
+
+	Convey("When A inherits from B and B inherits from C", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+		So(acl.SetActorInherits(nil, userB, userC), ShouldBeNil)
+
+		So(acl.SetActionAllowed(nil, userC, "test", true), ShouldBeNil)
+		So(acl.SetActionAllowedOn(nil, userB, "test", testResourceA, false), ShouldBeNil)
+
+		Convey("ExplainActionOn() should name the row on B and shadow the one on C", func() {
+			decision, err := acl.ExplainActionOn(ctx, nil, userA, "test", testResourceA)
+			So(err, ShouldBeNil)
+			So(decision.Allowed, ShouldEqual, false)
+			So(decision.Source, ShouldResemble, &Candidate{
+				Grant: Grant{ActorId: userB.GetId(), Action: "test", TargetId: testResourceA.GetId(), Allowed: false},
+				Level: 1,
+				Path:  []string{userA.GetId(), userB.GetId()},
+			})
+			So(decision.Shadowed, ShouldResemble, []Candidate{{
+				Grant: Grant{ActorId: userC.GetId(), Action: "test", TargetId: EMPTY_RESOURCE, Allowed: true},
+				Level: 2,
+				Path:  []string{userA.GetId(), userB.GetId(), userC.GetId()},
+			}})
+		})
+
+		Convey("ExplainAction() should name the row on C", func() {
+			decision, err := acl.ExplainAction(ctx, nil, userA, "test")
+			So(err, ShouldBeNil)
+			So(decision.Allowed, ShouldEqual, true)
+			So(decision.Source.ActorId, ShouldEqual, userC.GetId())
+			So(decision.Source.Path, ShouldResemble, []string{userA.GetId(), userB.GetId(), userC.GetId()})
+			So(len(decision.Shadowed), ShouldEqual, 0)
+		})
+
+		Convey("A row on A itself should have a path of only A", func() {
+			So(acl.SetActionAllowedOn(nil, userA, "test", testResourceA, true), ShouldBeNil)
+
+			decision, err := acl.ExplainActionOn(ctx, nil, userA, "test", testResourceA)
+			So(err, ShouldBeNil)
+			So(decision.Allowed, ShouldEqual, true)
+			So(decision.Source.Level, ShouldEqual, 0)
+			So(decision.Source.Path, ShouldResemble, []string{userA.GetId()})
+			So(len(decision.Shadowed), ShouldEqual, 2)
+		})
+	})
+}
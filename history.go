@@ -0,0 +1,52 @@
+package acl
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// AllowsActionAt is like AllowsAction but checks the ACL as it was at the
+// given time, see AllowsActionOnAt
+func (acl *ACL) AllowsActionAt(q Querier, actor Resource, action string, at time.Time) (bool, error) {
+	return acl.AllowsActionAtContext(context.Background(), q, actor, action, at)
+}
+
+// AllowsActionAtContext is like AllowsActionAt but uses the supplied context
+func (acl *ACL) AllowsActionAtContext(ctx context.Context, q Querier, actor Resource, action string, at time.Time) (bool, error) {
+	decision, err := acl.checkAt(ctx, q, Query{At: at, AsOf: at}, actor, action, nil, nil)
+
+	return decision.Allowed, err
+}
+
+// AllowsActionOnAt is like AllowsActionOn but checks the ACL as it was at the
+// given time, eg. when investigating an incident. The settings, actor
+// inheritances and roles are read from the history tables created by
+// EnsureTablesAndRulesExist, which record every version of their rows along
+// with when it was current, and the validity windows are evaluated at the
+// time. Rows which existed when the history tables were created are recorded
+// as current since forever. The target tree is not versioned, checking a
+// target which currently inherits from other targets returns
+// ErrTargetTreeUnversioned and a target is otherwise checked on its own
+func (acl *ACL) AllowsActionOnAt(q Querier, actor Resource, action string, target Resource, at time.Time) (bool, error) {
+	return acl.AllowsActionOnAtContext(context.Background(), q, actor, action, target, at)
+}
+
+// AllowsActionOnAtContext is like AllowsActionOnAt but uses the supplied context
+func (acl *ACL) AllowsActionOnAtContext(ctx context.Context, q Querier, actor Resource, action string, target Resource, at time.Time) (bool, error) {
+	decision, err := acl.checkAt(ctx, q, Query{At: at, AsOf: at}, actor, action, target, nil)
+
+	return decision.Allowed, err
+}
+
+// historyColumns returns the columns with the prefix and suffix, separated by
+// commas
+func historyColumns(columns []string, prefix string, suffix string) string {
+	ret := make([]string, len(columns))
+
+	for i, column := range columns {
+		ret[i] = prefix + column + suffix
+	}
+
+	return strings.Join(ret, ", ")
+}
@@ -0,0 +1,140 @@
+package acl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHistory(t *testing.T) {
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	Convey("When a setting changes over time", t, func() {
+		acl := NewMemory()
+		before := time.Now()
+
+		So(acl.SetActionAllowedOn(nil, userA, "delete", testResourceA, true), ShouldBeNil)
+		allowedAt := time.Now()
+
+		So(acl.SetActionAllowedOn(nil, userA, "delete", testResourceA, false), ShouldBeNil)
+		deniedAt := time.Now()
+
+		So(acl.UnsetActionAllowedOn(nil, userA, "delete", testResourceA), ShouldBeNil)
+
+		Convey("AllowsActionOnAt() should use the version current at the time", func() {
+			for at, expected := range map[time.Time]bool{before: false, allowedAt: true, deniedAt: false, time.Now(): false} {
+				allowed, err := acl.AllowsActionOnAt(nil, userA, "delete", testResourceA, at)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, expected)
+			}
+		})
+
+		Convey("AllowsActionOnAt() should refuse targets inheriting from other targets", func() {
+			folder := idAble{id: "d0000000-0000-4000-8000-000000000002"}
+
+			So(acl.SetTargetInherits(nil, testResourceA, folder), ShouldBeNil)
+
+			_, err := acl.AllowsActionOnAt(nil, userA, "delete", testResourceA, allowedAt)
+			So(errors.Is(err, ErrTargetTreeUnversioned), ShouldEqual, true)
+
+			allowed, err := acl.AllowsActionOnAt(nil, userA, "delete", folder, allowedAt)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("AllowsActionOnAt() should evaluate the validity windows at the time", func() {
+			So(acl.SetActionAllowedOnUntil(nil, userA, "edit", testResourceA, true, time.Now().Add(time.Hour)), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOnAt(nil, userA, "edit", testResourceA, time.Now())
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionOnAt(nil, userA, "edit", testResourceA, time.Now().Add(2*time.Hour))
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+	})
+
+	Convey("When an inheritance is removed", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActionAllowed(nil, userB, "testing", true), ShouldBeNil)
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+		inheritedAt := time.Now()
+
+		So(acl.RemoveActorInherits(nil, userA, userB), ShouldBeNil)
+
+		Convey("AllowsActionAt() should follow the inheritance current at the time", func() {
+			allowed, err := acl.AllowsActionAt(nil, userA, "testing", inheritedAt)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionAt(nil, userA, "testing", time.Now())
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+	})
+
+	Convey("When the history of a MemoryStore is truncated", t, func() {
+		store := NewMemoryStore()
+		acl := NewWithStore(store, nil)
+
+		So(acl.SetActionAllowedOn(nil, userA, "delete", testResourceA, false), ShouldBeNil)
+		So(acl.SetActionAllowedOn(nil, userA, "delete", testResourceA, true), ShouldBeNil)
+		truncatedAt := time.Now()
+
+		So(acl.SetActionAllowedOn(nil, userA, "edit", testResourceA, true), ShouldBeNil)
+
+		store.TruncateHistory(truncatedAt)
+
+		Convey("AuditLog() should only list the later entries with their ids", func() {
+			entries, err := acl.AuditLog(context.Background(), nil, AuditQuery{})
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Id, ShouldEqual, int64(3))
+			So(entries[0].Action, ShouldEqual, "edit")
+		})
+
+		Convey("The versions current at the time should be kept", func() {
+			allowed, err := acl.AllowsActionOnAt(nil, userA, "edit", testResourceA, time.Now())
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			So(acl.UnsetActionAllowedOn(nil, userA, "delete", testResourceA), ShouldBeNil)
+
+			allowed, err = acl.AllowsActionOnAt(nil, userA, "delete", testResourceA, truncatedAt)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionOnAt(nil, userA, "delete", testResourceA, time.Now())
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+	})
+
+	Convey("When a role is revoked", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetRoleActions(nil, "editor", []string{"edit"}), ShouldBeNil)
+		So(acl.AssignRole(nil, userA, "editor", testResourceA), ShouldBeNil)
+		assignedAt := time.Now()
+
+		So(acl.RevokeRole(nil, userA, "editor", testResourceA), ShouldBeNil)
+
+		Convey("AllowsActionOnAt() should use the assignments current at the time", func() {
+			allowed, err := acl.AllowsActionOnAt(nil, userA, "edit", testResourceA, assignedAt)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionOnAt(nil, userA, "edit", testResourceA, time.Now())
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+	})
+}
@@ -0,0 +1,109 @@
+package acl
+
+import (
+	"sort"
+)
+
+// Implications maps an action to the weaker actions it directly implies, eg.
+// {"admin": {"edit"}, "edit": {"view"}} makes admin imply both edit and view
+type Implications map[string][]string
+
+// WithImplications returns a copy of the ACL which resolves permissions using
+// the action implications. An allow on an action also allows every action it
+// implies, while a deny on an action also denies every action implying it, eg.
+// a deny on "view" blocks "edit". Rows on implied and implying actions rank
+// the same as rows on the checked action, so a deny wins over an allow when
+// both are on the same level and target
+func (acl *ACL) WithImplications(implications Implications) *ACL {
+	ret := *acl
+	ret.implies = make(map[string]map[string]struct{}, len(implications))
+
+	for action := range implications {
+		implied := make(map[string]struct{})
+		current := implications[action]
+
+		/* Walk the implications breadth-first, tolerating cycles */
+		for len(current) > 0 {
+			var next []string
+
+			for _, a := range current {
+				if _, ok := implied[a]; !ok && a != action {
+					implied[a] = struct{}{}
+					next = append(next, implications[a]...)
+				}
+			}
+
+			current = next
+		}
+
+		ret.implies[action] = implied
+	}
+
+	return &ret
+}
+
+// actions returns the actions along with all actions implying them or implied
+// by them and the wildcard patterns matching them, the rows of which can
+// affect a check of the actions
+func (acl *ACL) actions(actions ...string) []string {
+	seen := make(map[string]struct{})
+	var ret []string
+
+	add := func(a string) {
+		if _, ok := seen[a]; !ok {
+			seen[a] = struct{}{}
+			ret = append(ret, a)
+		}
+	}
+
+	for _, action := range actions {
+		add(action)
+
+		for a, implied := range acl.implies {
+			if _, ok := implied[action]; ok {
+				add(a)
+			}
+		}
+
+		for a := range acl.implies[action] {
+			add(a)
+		}
+
+		for _, pattern := range actionPatterns(action) {
+			add(pattern)
+		}
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// actionLevel returns the specificity of the candidate for a check of action
+// and true if it applies to the check at all. The checked action along with
+// the implied and implying actions are on level 0, allows applying to the
+// actions they imply and denies to the actions implying them. Wildcard
+// patterns follow on the levels returned by actionPatterns
+func (acl *ACL) actionLevel(c Candidate, action string) (int, bool) {
+	if c.Action == action {
+		return 0, true
+	}
+
+	if c.Allowed {
+		if _, ok := acl.implies[c.Action][action]; ok {
+			return 0, true
+		}
+	} else {
+		if _, ok := acl.implies[action][c.Action]; ok {
+			return 0, true
+		}
+	}
+
+	for i, pattern := range actionPatterns(action) {
+		if c.Action == pattern {
+			return i + 1, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,127 @@
+package acl
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestImplications(t *testing.T) {
+	ctx := context.Background()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	implications := Implications{"admin": {"edit"}, "edit": {"view"}}
+
+	Convey("With admin implying edit implying view", t, func() {
+		acl := NewMemory().WithImplications(implications)
+
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+
+		Convey("An allow on admin should allow edit and view but not other actions", func() {
+			So(acl.SetActionAllowed(nil, userA, "admin", true), ShouldBeNil)
+
+			for action, expected := range map[string]bool{"admin": true, "edit": true, "view": true, "other": false} {
+				allowed, err := acl.AllowsActionOn(nil, userA, action, testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, expected)
+			}
+		})
+
+		Convey("An allow on view should not allow edit", func() {
+			So(acl.SetActionAllowed(nil, userA, "view", true), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(nil, userA, "edit")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("A deny on view should block edit and admin on the same level", func() {
+			So(acl.SetActionAllowed(nil, userA, "admin", true), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userA, "view", false), ShouldBeNil)
+
+			for _, action := range []string{"admin", "edit", "view"} {
+				allowed, err := acl.AllowsAction(nil, userA, action)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			}
+
+			decision, err := acl.ExplainAction(ctx, nil, userA, "edit")
+			So(err, ShouldBeNil)
+			So(decision.Source.Action, ShouldEqual, "view")
+			So(decision.Shadowed[0].Action, ShouldEqual, "admin")
+		})
+
+		Convey("A deny on admin should not block view", func() {
+			So(acl.SetActionAllowed(nil, userA, "view", true), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userA, "admin", false), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(nil, userA, "view")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+
+		Convey("A nearer allow on admin should win over a deny on view from B", func() {
+			So(acl.SetActionAllowed(nil, userB, "view", false), ShouldBeNil)
+			So(acl.SetActionAllowedOn(nil, userA, "admin", testResourceA, true), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOn(nil, userA, "edit", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsAction(nil, userA, "edit")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("The batch and listing methods should use the implications", func() {
+			So(acl.SetActionAllowedOn(nil, userB, "admin", testResourceA, true), ShouldBeNil)
+
+			many, err := acl.AllowsActionsOnMany(ctx, nil, userA, []string{"view", "other"}, []Resource{testResourceA})
+			So(err, ShouldBeNil)
+			So(many, ShouldResemble, map[string]map[string]bool{testResourceA.GetId(): {"view": true, "other": false}})
+
+			list, err := acl.ListTargets(ctx, nil, userA, "view", Page{})
+			So(err, ShouldBeNil)
+			So(list.Ids, ShouldResemble, []string{testResourceA.GetId()})
+
+			actors, err := acl.ListActors(ctx, nil, testResourceA, "edit", false)
+			So(err, ShouldBeNil)
+			So(actors, ShouldResemble, []string{userA.GetId(), userB.GetId()})
+
+			permissions, err := acl.EffectivePermissions(ctx, nil, userA)
+			So(err, ShouldBeNil)
+			So(len(permissions), ShouldEqual, 3)
+			So(permissions[0].Action, ShouldEqual, "admin")
+			So(permissions[1].Action, ShouldEqual, "edit")
+			So(permissions[2].Action, ShouldEqual, "view")
+			So(permissions[2].Allowed, ShouldEqual, true)
+		})
+
+		Convey("The ACL without implications should be left untouched", func() {
+			plain := NewWithStore(acl.store, nil)
+
+			So(acl.SetActionAllowed(nil, userA, "admin", true), ShouldBeNil)
+
+			allowed, err := plain.AllowsAction(nil, userA, "edit")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+	})
+
+	Convey("With cyclic implications", t, func() {
+		acl := NewMemory().WithImplications(Implications{"a": {"b"}, "b": {"a"}})
+
+		Convey("Both actions should imply each other", func() {
+			So(acl.SetActionAllowed(nil, userA, "a", true), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(nil, userA, "b")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+	})
+}
@@ -0,0 +1,453 @@
+package acl
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer starts the spans of the instrumentation, see NewInstrumentedACL. It
+// is implemented by MemoryTracer and is easily adapted to OpenTelemetry:
+//
+//	func (t otelTracer) Start(ctx context.Context, name string, attributes map[string]interface{}) (context.Context, acl.Span) {
+//		ctx, span := t.tracer.Start(ctx, name)
+//		s := otelSpan{span}
+//
+//		for key, value := range attributes {
+//			s.SetAttribute(key, value)
+//		}
+//
+//		return ctx, s
+//	}
+type Tracer interface {
+	// Start starts a span with the attributes, the returned context carries
+	// the span as the parent of the spans started with it
+	Start(ctx context.Context, name string, attributes map[string]interface{}) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+// Metrics records the metrics of the instrumentation, see
+// NewInstrumentedACL. The names and labels follow the Prometheus conventions,
+// it is implemented by MemoryMetrics and is easily adapted to counter and
+// histogram vectors of the Prometheus client
+type Metrics interface {
+	AddCounter(name string, labels map[string]string, delta float64)
+	ObserveHistogram(name string, labels map[string]string, value float64)
+}
+
+// InstrumentedAuthorizer is a ContextActionAuthorizer tracing every check of
+// the wrapped authorizer in a span named acl.AllowsAction or
+// acl.AllowsActionOn, with the attributes acl.actor_id, acl.action,
+// acl.target_id and acl.allowed, and recording the metrics:
+//
+//	acl_checks_total{action, result}          counter, result is allow, deny or error
+//	acl_check_duration_seconds{action}        histogram
+type InstrumentedAuthorizer struct {
+	authorizer ContextActionAuthorizer
+	tracer     Tracer
+	metrics    Metrics
+}
+
+// NewInstrumentedAuthorizer wraps the authorizer, eg. a Cache, a nil tracer or
+// metrics disables the spans or the metrics respectively
+func NewInstrumentedAuthorizer(authorizer ContextActionAuthorizer, tracer Tracer, metrics Metrics) *InstrumentedAuthorizer {
+	if tracer == nil {
+		tracer = nopTracer{}
+	}
+
+	if metrics == nil {
+		metrics = nopMetrics{}
+	}
+
+	return &InstrumentedAuthorizer{authorizer: authorizer, tracer: tracer, metrics: metrics}
+}
+
+// AllowsAction is like ActionAuthorizer.AllowsAction but instrumented
+func (i *InstrumentedAuthorizer) AllowsAction(q Querier, actor Resource, action string) (bool, error) {
+	return i.AllowsActionContext(context.Background(), q, actor, action)
+}
+
+// AllowsActionContext is like AllowsAction but uses the supplied context
+func (i *InstrumentedAuthorizer) AllowsActionContext(ctx context.Context, q Querier, actor Resource, action string) (bool, error) {
+	return i.check(ctx, "acl.AllowsAction", actor.GetId(), action, "", func(ctx context.Context) (bool, error) {
+		return i.authorizer.AllowsActionContext(ctx, q, actor, action)
+	})
+}
+
+// AllowsActionOn is like ActionAuthorizer.AllowsActionOn but instrumented
+func (i *InstrumentedAuthorizer) AllowsActionOn(q Querier, actor Resource, action string, target Resource) (bool, error) {
+	return i.AllowsActionOnContext(context.Background(), q, actor, action, target)
+}
+
+// AllowsActionOnContext is like AllowsActionOn but uses the supplied context
+func (i *InstrumentedAuthorizer) AllowsActionOnContext(ctx context.Context, q Querier, actor Resource, action string, target Resource) (bool, error) {
+	return i.check(ctx, "acl.AllowsActionOn", actor.GetId(), action, resourceId(target), func(ctx context.Context) (bool, error) {
+		return i.authorizer.AllowsActionOnContext(ctx, q, actor, action, target)
+	})
+}
+
+// instrumentedActionKey carries the checked action to the instrumented store,
+// to label the latency of its queries
+type instrumentedActionKey struct{}
+
+// check runs the check f within a span, recording its metrics
+func (i *InstrumentedAuthorizer) check(ctx context.Context, name string, actorId string, action string, targetId string, f func(ctx context.Context) (bool, error)) (bool, error) {
+	start := time.Now()
+
+	ctx, span := i.tracer.Start(context.WithValue(ctx, instrumentedActionKey{}, action), name, resourceAttributes(actorId, action, targetId))
+	defer span.End()
+
+	allowed, err := f(ctx)
+	result := "deny"
+
+	switch {
+	case err != nil:
+		result = "error"
+
+		span.RecordError(err)
+	case allowed:
+		result = "allow"
+	}
+
+	span.SetAttribute("acl.allowed", allowed)
+
+	i.metrics.AddCounter("acl_checks_total", map[string]string{"action": action, "result": result}, 1)
+	i.metrics.ObserveHistogram("acl_check_duration_seconds", map[string]string{"action": action}, time.Since(start).Seconds())
+
+	return allowed, err
+}
+
+// InstrumentedACL is an InstrumentedAuthorizer of an ACL, which also traces
+// the queries resolving the checks in spans named acl.Candidates and the
+// changes made through it in spans named after the method, eg.
+// acl.SetActionAllowed, recording the additional metrics:
+//
+//	acl_query_duration_seconds{action}        histogram of the recursive queries
+//	acl_mutations_total{operation, result}    counter, result is ok or error
+type InstrumentedACL struct {
+	*InstrumentedAuthorizer
+	acl *ACL
+}
+
+// NewInstrumentedACL wraps the ACL, a nil tracer or metrics disables the spans
+// or the metrics respectively
+func NewInstrumentedACL(acl *ACL, tracer Tracer, metrics Metrics) *InstrumentedACL {
+	authorizer := NewInstrumentedAuthorizer(nil, tracer, metrics)
+
+	instrumented := *acl
+	instrumented.store = &instrumentedStore{Store: acl.store, tracer: authorizer.tracer, metrics: authorizer.metrics}
+	authorizer.authorizer = &instrumented
+
+	return &InstrumentedACL{InstrumentedAuthorizer: authorizer, acl: &instrumented}
+}
+
+// ACL returns the wrapped ACL with its queries traced, for the methods which
+// are not instrumented
+func (i *InstrumentedACL) ACL() *ACL {
+	return i.acl
+}
+
+// mutate runs the change f within a span, recording its metrics
+func (i *InstrumentedACL) mutate(ctx context.Context, operation string, attributes map[string]interface{}, f func(ctx context.Context) error) error {
+	ctx, span := i.tracer.Start(ctx, "acl."+operation, attributes)
+	defer span.End()
+
+	err := f(ctx)
+	result := "ok"
+
+	if err != nil {
+		result = "error"
+
+		span.RecordError(err)
+	}
+
+	i.metrics.AddCounter("acl_mutations_total", map[string]string{"operation": operation, "result": result}, 1)
+
+	return err
+}
+
+// SetActionAllowed is like ACL.SetActionAllowed but instrumented
+func (i *InstrumentedACL) SetActionAllowed(q Querier, actor Resource, action string, allowed bool) error {
+	return i.SetActionAllowedContext(context.Background(), q, actor, action, allowed)
+}
+
+// SetActionAllowedContext is like SetActionAllowed but uses the supplied context
+func (i *InstrumentedACL) SetActionAllowedContext(ctx context.Context, q Querier, actor Resource, action string, allowed bool) error {
+	attributes := resourceAttributes(actor.GetId(), action, "")
+	attributes["acl.allowed"] = allowed
+
+	return i.mutate(ctx, "SetActionAllowed", attributes, func(ctx context.Context) error {
+		return i.acl.SetActionAllowedContext(ctx, q, actor, action, allowed)
+	})
+}
+
+// UnsetActionAllowed is like ACL.UnsetActionAllowed but instrumented
+func (i *InstrumentedACL) UnsetActionAllowed(q Querier, actor Resource, action string) error {
+	return i.UnsetActionAllowedContext(context.Background(), q, actor, action)
+}
+
+// UnsetActionAllowedContext is like UnsetActionAllowed but uses the supplied context
+func (i *InstrumentedACL) UnsetActionAllowedContext(ctx context.Context, q Querier, actor Resource, action string) error {
+	return i.mutate(ctx, "UnsetActionAllowed", resourceAttributes(actor.GetId(), action, ""), func(ctx context.Context) error {
+		return i.acl.UnsetActionAllowedContext(ctx, q, actor, action)
+	})
+}
+
+// SetActionAllowedOn is like ACL.SetActionAllowedOn but instrumented
+func (i *InstrumentedACL) SetActionAllowedOn(q Querier, actor Resource, action string, target Resource, allowed bool) error {
+	return i.SetActionAllowedOnContext(context.Background(), q, actor, action, target, allowed)
+}
+
+// SetActionAllowedOnContext is like SetActionAllowedOn but uses the supplied context
+func (i *InstrumentedACL) SetActionAllowedOnContext(ctx context.Context, q Querier, actor Resource, action string, target Resource, allowed bool) error {
+	attributes := resourceAttributes(actor.GetId(), action, resourceId(target))
+	attributes["acl.allowed"] = allowed
+
+	return i.mutate(ctx, "SetActionAllowedOn", attributes, func(ctx context.Context) error {
+		return i.acl.SetActionAllowedOnContext(ctx, q, actor, action, target, allowed)
+	})
+}
+
+// UnsetActionAllowedOn is like ACL.UnsetActionAllowedOn but instrumented
+func (i *InstrumentedACL) UnsetActionAllowedOn(q Querier, actor Resource, action string, target Resource) error {
+	return i.UnsetActionAllowedOnContext(context.Background(), q, actor, action, target)
+}
+
+// UnsetActionAllowedOnContext is like UnsetActionAllowedOn but uses the supplied context
+func (i *InstrumentedACL) UnsetActionAllowedOnContext(ctx context.Context, q Querier, actor Resource, action string, target Resource) error {
+	return i.mutate(ctx, "UnsetActionAllowedOn", resourceAttributes(actor.GetId(), action, resourceId(target)), func(ctx context.Context) error {
+		return i.acl.UnsetActionAllowedOnContext(ctx, q, actor, action, target)
+	})
+}
+
+// SetActorInherits is like ACL.SetActorInherits but instrumented
+func (i *InstrumentedACL) SetActorInherits(q Querier, actor Resource, parentActor Resource) error {
+	return i.SetActorInheritsContext(context.Background(), q, actor, parentActor)
+}
+
+// SetActorInheritsContext is like SetActorInherits but uses the supplied context
+func (i *InstrumentedACL) SetActorInheritsContext(ctx context.Context, q Querier, actor Resource, parentActor Resource) error {
+	return i.mutate(ctx, "SetActorInherits", inheritanceAttributes(actor, parentActor), func(ctx context.Context) error {
+		return i.acl.SetActorInheritsContext(ctx, q, actor, parentActor)
+	})
+}
+
+// RemoveActorInherits is like ACL.RemoveActorInherits but instrumented
+func (i *InstrumentedACL) RemoveActorInherits(q Querier, actor Resource, parentActor Resource) error {
+	return i.RemoveActorInheritsContext(context.Background(), q, actor, parentActor)
+}
+
+// RemoveActorInheritsContext is like RemoveActorInherits but uses the supplied context
+func (i *InstrumentedACL) RemoveActorInheritsContext(ctx context.Context, q Querier, actor Resource, parentActor Resource) error {
+	return i.mutate(ctx, "RemoveActorInherits", inheritanceAttributes(actor, parentActor), func(ctx context.Context) error {
+		return i.acl.RemoveActorInheritsContext(ctx, q, actor, parentActor)
+	})
+}
+
+// resourceAttributes returns the span attributes of the actor, action and
+// target, an empty targetId is left out
+func resourceAttributes(actorId string, action string, targetId string) map[string]interface{} {
+	ret := map[string]interface{}{"acl.actor_id": actorId, "acl.action": action}
+
+	if targetId != "" {
+		ret["acl.target_id"] = targetId
+	}
+
+	return ret
+}
+
+// resourceId returns the id of the target for the span attributes, a nil
+// target checks the actor-wide rows like in ACL.check and has no id
+func resourceId(target Resource) string {
+	if target == nil {
+		return ""
+	}
+
+	return target.GetId()
+}
+
+// inheritanceAttributes returns the span attributes of an inheritance
+func inheritanceAttributes(actor Resource, parentActor Resource) map[string]interface{} {
+	return map[string]interface{}{"acl.actor_id": actor.GetId(), "acl.parent_actor_id": parentActor.GetId()}
+}
+
+// instrumentedStore traces the Candidates queries of the Store
+type instrumentedStore struct {
+	Store
+	tracer  Tracer
+	metrics Metrics
+}
+
+func (s *instrumentedStore) Candidates(ctx context.Context, q Querier, query Query) ([]Candidate, error) {
+	start := time.Now()
+	action, _ := ctx.Value(instrumentedActionKey{}).(string)
+
+	ctx, span := s.tracer.Start(ctx, "acl.Candidates", map[string]interface{}{"acl.actor_id": query.ActorId, "acl.action": action})
+	defer span.End()
+
+	candidates, err := s.Store.Candidates(ctx, q, query)
+	if err != nil {
+		span.RecordError(err)
+	}
+
+	span.SetAttribute("acl.candidates", len(candidates))
+
+	s.metrics.ObserveHistogram("acl_query_duration_seconds", map[string]string{"action": action}, time.Since(start).Seconds())
+
+	return candidates, err
+}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string, attributes map[string]interface{}) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(key string, value interface{}) {}
+func (nopSpan) RecordError(err error)                      {}
+func (nopSpan) End()                                       {}
+
+type nopMetrics struct{}
+
+func (nopMetrics) AddCounter(name string, labels map[string]string, delta float64)       {}
+func (nopMetrics) ObserveHistogram(name string, labels map[string]string, value float64) {}
+
+// MemoryTracer is an in-memory Tracer recording every span, useful in tests.
+// It is safe for concurrent use
+type MemoryTracer struct {
+	mu    sync.Mutex
+	spans []*MemorySpan
+}
+
+// MemorySpan is a span recorded by a MemoryTracer
+type MemorySpan struct {
+	Name string
+	// Parent is the span in the context the span was started with, if any
+	Parent     *MemorySpan
+	Attributes map[string]interface{}
+	Errors     []error
+	Ended      bool
+
+	tracer *MemoryTracer
+}
+
+type memorySpanKey struct{}
+
+// NewMemoryTracer creates a new MemoryTracer without any spans
+func NewMemoryTracer() *MemoryTracer {
+	return &MemoryTracer{}
+}
+
+func (t *MemoryTracer) Start(ctx context.Context, name string, attributes map[string]interface{}) (context.Context, Span) {
+	parent, _ := ctx.Value(memorySpanKey{}).(*MemorySpan)
+	span := &MemorySpan{Name: name, Parent: parent, Attributes: make(map[string]interface{}, len(attributes)), tracer: t}
+
+	for key, value := range attributes {
+		span.Attributes[key] = value
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.spans = append(t.spans, span)
+
+	return context.WithValue(ctx, memorySpanKey{}, span), span
+}
+
+// Spans returns the spans in the order they were started
+func (t *MemoryTracer) Spans() []*MemorySpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]*MemorySpan(nil), t.spans...)
+}
+
+func (s *MemorySpan) SetAttribute(key string, value interface{}) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+
+	s.Attributes[key] = value
+}
+
+func (s *MemorySpan) RecordError(err error) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+
+	s.Errors = append(s.Errors, err)
+}
+
+func (s *MemorySpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+
+	s.Ended = true
+}
+
+// MemoryMetrics is an in-memory Metrics recording the counters and the
+// observations of the histograms, useful in tests. It is safe for concurrent
+// use
+type MemoryMetrics struct {
+	mu         sync.Mutex
+	counters   map[string]float64
+	histograms map[string][]float64
+}
+
+// NewMemoryMetrics creates a new MemoryMetrics without any metrics
+func NewMemoryMetrics() *MemoryMetrics {
+	return &MemoryMetrics{counters: make(map[string]float64), histograms: make(map[string][]float64)}
+}
+
+func (m *MemoryMetrics) AddCounter(name string, labels map[string]string, delta float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.counters[metricKey(name, labels)] += delta
+}
+
+func (m *MemoryMetrics) ObserveHistogram(name string, labels map[string]string, value float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := metricKey(name, labels)
+	m.histograms[key] = append(m.histograms[key], value)
+}
+
+// Counter returns the value of the counter with the labels
+func (m *MemoryMetrics) Counter(name string, labels map[string]string) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.counters[metricKey(name, labels)]
+}
+
+// Histogram returns the observations of the histogram with the labels
+func (m *MemoryMetrics) Histogram(name string, labels map[string]string) []float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]float64(nil), m.histograms[metricKey(name, labels)]...)
+}
+
+// metricKey returns the metric in the Prometheus text format, eg.
+// acl_checks_total{action="edit",result="allow"}
+func metricKey(name string, labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+
+	for label, value := range labels {
+		pairs = append(pairs, label+`="`+value+`"`)
+	}
+
+	sort.Strings(pairs)
+
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
@@ -0,0 +1,117 @@
+package acl
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInstrumented(t *testing.T) {
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	Convey("When an ACL is instrumented", t, func() {
+		tracer := NewMemoryTracer()
+		metrics := NewMemoryMetrics()
+
+		acl := NewInstrumentedACL(NewMemory(), tracer, metrics)
+
+		So(acl.SetActionAllowedOn(nil, userA, "testing", testResourceA, true), ShouldBeNil)
+		So(acl.SetActorInherits(nil, userB, userA), ShouldBeNil)
+
+		Convey("The changes should be traced and counted", func() {
+			spans := tracer.Spans()
+
+			So(len(spans), ShouldEqual, 2)
+			So(spans[0].Name, ShouldEqual, "acl.SetActionAllowedOn")
+			So(spans[0].Attributes["acl.actor_id"], ShouldEqual, userA.GetId())
+			So(spans[0].Attributes["acl.action"], ShouldEqual, "testing")
+			So(spans[0].Attributes["acl.target_id"], ShouldEqual, testResourceA.GetId())
+			So(spans[0].Attributes["acl.allowed"], ShouldEqual, true)
+			So(spans[0].Ended, ShouldEqual, true)
+			So(spans[1].Name, ShouldEqual, "acl.SetActorInherits")
+			So(spans[1].Attributes["acl.parent_actor_id"], ShouldEqual, userA.GetId())
+
+			So(metrics.Counter("acl_mutations_total", map[string]string{"operation": "SetActionAllowedOn", "result": "ok"}), ShouldEqual, 1.0)
+			So(metrics.Counter("acl_mutations_total", map[string]string{"operation": "SetActorInherits", "result": "ok"}), ShouldEqual, 1.0)
+		})
+
+		Convey("A failed change should be recorded as an error", func() {
+			err := acl.SetActorInherits(nil, userA, userB)
+
+			So(err, ShouldNotBeNil)
+
+			spans := tracer.Spans()
+
+			So(spans[len(spans)-1].Errors, ShouldResemble, []error{err})
+			So(metrics.Counter("acl_mutations_total", map[string]string{"operation": "SetActorInherits", "result": "error"}), ShouldEqual, 1.0)
+		})
+
+		Convey("A check should be traced along with its query", func() {
+			allowed, err := acl.AllowsActionOn(nil, userB, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			spans := tracer.Spans()[2:]
+
+			So(len(spans), ShouldEqual, 2)
+			So(spans[0].Name, ShouldEqual, "acl.AllowsActionOn")
+			So(spans[0].Attributes["acl.actor_id"], ShouldEqual, userB.GetId())
+			So(spans[0].Attributes["acl.target_id"], ShouldEqual, testResourceA.GetId())
+			So(spans[0].Attributes["acl.allowed"], ShouldEqual, true)
+			So(spans[1].Name, ShouldEqual, "acl.Candidates")
+			So(spans[1].Parent, ShouldEqual, spans[0])
+			So(spans[1].Attributes["acl.action"], ShouldEqual, "testing")
+
+			So(metrics.Counter("acl_checks_total", map[string]string{"action": "testing", "result": "allow"}), ShouldEqual, 1.0)
+			So(len(metrics.Histogram("acl_check_duration_seconds", map[string]string{"action": "testing"})), ShouldEqual, 1)
+			So(len(metrics.Histogram("acl_query_duration_seconds", map[string]string{"action": "testing"})), ShouldEqual, 1)
+		})
+
+		Convey("Denied and failed checks should be counted separately", func() {
+			allowed, err := acl.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err = acl.AllowsActionContext(ctx, nil, userA, "testing")
+			So(err, ShouldNotBeNil)
+
+			So(metrics.Counter("acl_checks_total", map[string]string{"action": "testing", "result": "deny"}), ShouldEqual, 1.0)
+			So(metrics.Counter("acl_checks_total", map[string]string{"action": "testing", "result": "error"}), ShouldEqual, 1.0)
+		})
+	})
+
+	Convey("AllowsActionOn() should check a nil target like AllowsAction()", t, func() {
+		acl := NewMemory()
+		tracer := NewMemoryTracer()
+
+		So(acl.SetActionAllowed(nil, userA, "testing", true), ShouldBeNil)
+
+		allowed, err := NewInstrumentedAuthorizer(acl, tracer, nil).AllowsActionOn(nil, userA, "testing", nil)
+		So(err, ShouldBeNil)
+		So(allowed, ShouldEqual, true)
+
+		spans := tracer.Spans()
+		So(len(spans), ShouldEqual, 1)
+		So(spans[0].Attributes["acl.actor_id"], ShouldEqual, userA.GetId())
+
+		_, ok := spans[0].Attributes["acl.target_id"]
+		So(ok, ShouldBeFalse)
+	})
+
+	Convey("NewInstrumentedAuthorizer() should work without a tracer or metrics", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActionAllowed(nil, userA, "testing", true), ShouldBeNil)
+
+		allowed, err := NewInstrumentedAuthorizer(acl, nil, nil).AllowsAction(nil, userA, "testing")
+		So(err, ShouldBeNil)
+		So(allowed, ShouldEqual, true)
+	})
+}
@@ -0,0 +1,188 @@
+package acl
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Page selects a part of a listing, ids are listed in ascending order starting
+// after the id After, a Limit of 0 means no limit
+type Page struct {
+	After string
+	Limit int
+}
+
+// TargetList is the result of ListTargets
+type TargetList struct {
+	// All is true if an EMPTY_RESOURCE grant allows the action on every target,
+	// in that case Except lists the targets which are still denied and Ids is
+	// empty
+	All bool
+	// Ids lists the targets the action is allowed on
+	Ids []string
+	// Except lists the targets the action is denied on when All is set
+	Except []string
+	// Next is the After of the next page, empty if this is the last page
+	Next string
+}
+
+// ListTargets lists the targets which actor is allowed to perform action on,
+// using the same resolution as AllowsActionOn. The targets are those of the
+// rows applying to actor and all targets inheriting from them. A bypassFunc
+// allowing the action on a NilResource target allows every target
+func (acl *ACL) ListTargets(ctx context.Context, q Querier, actor Resource, action string, page Page) (TargetList, error) {
+	if acl.bypassFunc != nil && acl.bypassFunc(actor, action, &NilResource{}) {
+		return TargetList{All: true}, nil
+	}
+
+	candidates, err := acl.store.Candidates(ctx, q, Query{ActorId: actor.GetId(), Actions: acl.actions(action), At: time.Now()})
+	if err != nil {
+		return TargetList{}, err
+	}
+
+	seen := make(map[string]struct{})
+	var roots []string
+
+	for _, c := range candidates {
+		if _, ok := seen[c.TargetId]; !ok && c.TargetId != EMPTY_RESOURCE {
+			seen[c.TargetId] = struct{}{}
+			roots = append(roots, c.TargetId)
+		}
+	}
+
+	all, err := acl.resolve(candidates, action, map[string]int{EMPTY_RESOURCE: 0}, Attributes(nil).with(actor.GetId(), EMPTY_RESOURCE))
+	if err != nil {
+		return TargetList{}, err
+	}
+
+	ret := TargetList{All: all.Allowed}
+	var ids []string
+
+	/* Targets inheriting from the targets of the rows are covered by them,
+	   the subtree is walked a page at a time until one more target than the
+	   limit is listed, telling if there is a next page */
+	limit := 0
+	if page.Limit > 0 {
+		limit = page.Limit + 1
+	}
+
+	for after := page.After; ; {
+		levels, err := acl.store.TargetDescendants(ctx, q, roots, Page{After: after, Limit: limit})
+		if err != nil {
+			return TargetList{}, err
+		}
+
+		found := make([]string, 0, len(levels))
+
+		for targetId := range levels {
+			found = append(found, targetId)
+		}
+
+		sort.Strings(found)
+
+		for _, targetId := range found {
+			decision, err := acl.resolve(candidates, action, levels[targetId], Attributes(nil).with(actor.GetId(), targetId))
+			if err != nil {
+				return TargetList{}, err
+			}
+
+			/* With All we list the exceptions, otherwise the allowed targets */
+			if decision.Allowed != ret.All {
+				ids = append(ids, targetId)
+			}
+		}
+
+		if limit == 0 || len(found) < limit || len(ids) >= limit {
+			break
+		}
+
+		after = found[len(found)-1]
+	}
+
+	if limit > 0 && len(ids) >= limit {
+		ids = ids[:page.Limit]
+		ret.Next = ids[page.Limit-1]
+	}
+
+	if ret.All {
+		ret.Except = ids
+	} else {
+		ret.Ids = ids
+	}
+
+	return ret, nil
+}
+
+// ListActors lists the actors which are allowed to perform action on target,
+// using the same resolution as AllowsActionOn. Actors are found through the
+// rows on target, its ancestors in the target tree or EMPTY_RESOURCE and
+// everyone inheriting from their actors through the edges valid now.
+// With expand only the leaf actors, those without children, are returned,
+// expanding groups into their members. The bypassFunc is only consulted for
+// the actors found this way
+func (acl *ACL) ListActors(ctx context.Context, q Querier, target Resource, action string, expand bool) ([]string, error) {
+	levels, err := acl.store.TargetLevels(ctx, q, []string{target.GetId()})
+	if err != nil {
+		return nil, err
+	}
+
+	inheritors, err := acl.store.Inheritors(ctx, q, Query{Actions: acl.actions(action), TargetIds: targetIds(levels[target.GetId()]), At: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []string
+
+	for _, inheritor := range inheritors {
+		if expand && !inheritor.Leaf {
+			continue
+		}
+
+		allowed := acl.bypassFunc != nil && acl.bypassFunc(idResource(inheritor.ActorId), action, target)
+
+		if !allowed {
+			decision, err := acl.resolve(inheritor.Candidates, action, levels[target.GetId()], Attributes(nil).with(inheritor.ActorId, target.GetId()))
+			if err != nil {
+				return nil, err
+			}
+
+			allowed = decision.Allowed
+		}
+
+		if allowed {
+			ret = append(ret, inheritor.ActorId)
+		}
+	}
+
+	sort.Strings(ret)
+
+	return ret, nil
+}
+
+// idResource is a Resource for an id read from the store
+type idResource string
+
+// GetId returns the id
+func (r idResource) GetId() string {
+	return string(r)
+}
+
+// paginate sorts the ids and returns the selected page along with the After
+// of the next page
+func paginate(ids []string, page Page) ([]string, string) {
+	sort.Strings(ids)
+
+	start := sort.SearchStrings(ids, page.After)
+	if start < len(ids) && page.After != "" && ids[start] == page.After {
+		start++
+	}
+
+	ids = ids[start:]
+
+	if page.Limit <= 0 || len(ids) <= page.Limit {
+		return ids, ""
+	}
+
+	return ids[:page.Limit], ids[page.Limit-1]
+}
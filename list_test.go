@@ -0,0 +1,158 @@
+package acl
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestListTargets(t *testing.T) {
+	ctx := context.Background()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+	testResourceB := idAble{id: "b5c5a6a1-7a1b-4b3d-9a55-3f0f1c1f9f10"}
+	testResourceC := idAble{id: "c1d0f7a4-0f3e-4c1b-8f0e-2d4c6a8e9b21"}
+
+	Convey("When A inherits from B", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+
+		Convey("Without any grants nothing should be listed", func() {
+			list, err := acl.ListTargets(ctx, nil, userA, "edit", Page{})
+			So(err, ShouldBeNil)
+			So(list, ShouldResemble, TargetList{})
+		})
+
+		Convey("With grants on specific targets", func() {
+			So(acl.SetActionAllowedOn(nil, userB, "edit", testResourceA, true), ShouldBeNil)
+			So(acl.SetActionAllowedOn(nil, userB, "edit", testResourceB, true), ShouldBeNil)
+			So(acl.SetActionAllowedOn(nil, userB, "edit", testResourceC, true), ShouldBeNil)
+			So(acl.SetActionAllowedOn(nil, userA, "edit", testResourceB, false), ShouldBeNil)
+			So(acl.SetActionAllowedOn(nil, userA, "read", testResourceB, true), ShouldBeNil)
+
+			Convey("The nearest deny should remove the target", func() {
+				list, err := acl.ListTargets(ctx, nil, userA, "edit", Page{})
+				So(err, ShouldBeNil)
+				So(list, ShouldResemble, TargetList{Ids: []string{testResourceA.GetId(), testResourceC.GetId()}})
+			})
+
+			Convey("The listing should be paginated", func() {
+				list, err := acl.ListTargets(ctx, nil, userB, "edit", Page{Limit: 2})
+				So(err, ShouldBeNil)
+				So(list, ShouldResemble, TargetList{Ids: []string{testResourceA.GetId(), testResourceB.GetId()}, Next: testResourceB.GetId()})
+
+				list, err = acl.ListTargets(ctx, nil, userB, "edit", Page{After: list.Next, Limit: 2})
+				So(err, ShouldBeNil)
+				So(list, ShouldResemble, TargetList{Ids: []string{testResourceC.GetId()}})
+			})
+
+			Convey("An actor-wide allow should list the denies as exceptions", func() {
+				So(acl.SetActionAllowed(nil, userB, "edit", true), ShouldBeNil)
+
+				list, err := acl.ListTargets(ctx, nil, userA, "edit", Page{})
+				So(err, ShouldBeNil)
+				So(list, ShouldResemble, TargetList{All: true, Except: []string{testResourceB.GetId()}})
+			})
+
+			Convey("An actor-wide deny on A should override the allows on B", func() {
+				So(acl.SetActionAllowed(nil, userA, "edit", false), ShouldBeNil)
+
+				list, err := acl.ListTargets(ctx, nil, userA, "edit", Page{})
+				So(err, ShouldBeNil)
+				So(list, ShouldResemble, TargetList{})
+
+				list, err = acl.ListTargets(ctx, nil, userB, "edit", Page{})
+				So(err, ShouldBeNil)
+				So(list.Ids, ShouldResemble, []string{testResourceA.GetId(), testResourceB.GetId(), testResourceC.GetId()})
+			})
+		})
+
+		Convey("With a grant on a drive the targets inherit from", func() {
+			drive := idAble{id: "d0000000-0000-4000-8000-000000000001"}
+
+			So(acl.SetTargetInherits(nil, testResourceA, drive), ShouldBeNil)
+			So(acl.SetTargetInherits(nil, testResourceB, drive), ShouldBeNil)
+			So(acl.SetTargetInherits(nil, testResourceC, drive), ShouldBeNil)
+			So(acl.SetActionAllowedOn(nil, userB, "edit", drive, true), ShouldBeNil)
+			So(acl.SetActionAllowedOn(nil, userA, "edit", testResourceB, false), ShouldBeNil)
+
+			Convey("The pages should be filled past the denied targets", func() {
+				list, err := acl.ListTargets(ctx, nil, userA, "edit", Page{Limit: 2})
+				So(err, ShouldBeNil)
+				So(list, ShouldResemble, TargetList{Ids: []string{testResourceA.GetId(), testResourceC.GetId()}, Next: testResourceC.GetId()})
+
+				list, err = acl.ListTargets(ctx, nil, userA, "edit", Page{After: list.Next, Limit: 2})
+				So(err, ShouldBeNil)
+				So(list, ShouldResemble, TargetList{Ids: []string{drive.GetId()}})
+			})
+		})
+
+		Convey("A bypassFunc giving true should allow everything", func() {
+			acl := NewMemoryWithBypass(func(actor Resource, action string, target Resource) bool {
+				return true
+			})
+
+			list, err := acl.ListTargets(ctx, nil, userA, "edit", Page{})
+			So(err, ShouldBeNil)
+			So(list, ShouldResemble, TargetList{All: true})
+		})
+	})
+}
+
+func TestListActors(t *testing.T) {
+	ctx := context.Background()
+
+	group := idAble{id: "1a2b3c4d-0000-4000-8000-000000000001"}
+	subgroup := idAble{id: "1a2b3c4d-0000-4000-8000-000000000002"}
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+	userC := idAble{id: "7be24c16-6376-478d-91c9-f879116d1d49"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	Convey("When A inherits from the subgroup, B from the group and C from the subgroup", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActorInherits(nil, subgroup, group), ShouldBeNil)
+		So(acl.SetActorInherits(nil, userA, subgroup), ShouldBeNil)
+		So(acl.SetActorInherits(nil, userB, group), ShouldBeNil)
+		So(acl.SetActorInherits(nil, userC, subgroup), ShouldBeNil)
+
+		So(acl.SetActionAllowed(nil, group, "edit", true), ShouldBeNil)
+		So(acl.SetActionAllowedOn(nil, subgroup, "edit", testResourceA, false), ShouldBeNil)
+		So(acl.SetActionAllowedOn(nil, userC, "edit", testResourceA, true), ShouldBeNil)
+
+		Convey("ListActors() should list every allowed actor", func() {
+			actors, err := acl.ListActors(ctx, nil, testResourceA, "edit", false)
+			So(err, ShouldBeNil)
+			So(actors, ShouldResemble, []string{group.GetId(), userB.GetId(), userC.GetId()})
+		})
+
+		Convey("ListActors() with expand should only list the allowed leaf actors", func() {
+			actors, err := acl.ListActors(ctx, nil, testResourceA, "edit", true)
+			So(err, ShouldBeNil)
+			So(actors, ShouldResemble, []string{userB.GetId(), userC.GetId()})
+		})
+
+		Convey("ListActors() should list nothing for other actions", func() {
+			actors, err := acl.ListActors(ctx, nil, testResourceA, "read", false)
+			So(err, ShouldBeNil)
+			So(len(actors), ShouldEqual, 0)
+		})
+
+		Convey("The bypassFunc should be consulted for the found actors", func() {
+			acl := NewWithStore(acl.store, func(actor Resource, action string, target Resource) bool {
+				return actor.GetId() == userA.GetId()
+			})
+
+			actors, err := acl.ListActors(ctx, nil, testResourceA, "edit", true)
+			So(err, ShouldBeNil)
+			So(actors, ShouldResemble, []string{userA.GetId(), userB.GetId(), userC.GetId()})
+		})
+	})
+}
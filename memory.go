@@ -0,0 +1,971 @@
+package acl
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryGrant is the key of a row in the in-memory ACL table, it is also used
+// for role assignments with the role as action
+type memoryGrant struct {
+	actor  string
+	action string
+	target string
+}
+
+// MemoryStore is an in-memory Store, useful in tests where a PostgreSQL
+// database is not available. The Querier parameters are ignored and can be nil.
+// It is safe for concurrent use. The audit log and the history grow with every
+// change, see TruncateHistory
+type MemoryStore struct {
+	mu          sync.RWMutex
+	grants      map[memoryGrant]Grant
+	roles       map[string][]string
+	assignments map[memoryGrant]struct{}
+	actors      memoryTree
+	targets     memoryTree
+	audit       []AuditEntry
+	auditId     int64
+	versions    []memoryVersion
+	current     map[memoryVersionKey]int
+}
+
+// memoryVersion is a version of a grant, an actor tree edge, an action of a
+// role or a role assignment, current from recordedFrom until recordedUntil, a
+// zero recordedUntil is the current version. The key is that of the grants,
+// edges have the id and parent id as actor and action, actions of roles the
+// role and action and role assignments the role as action
+type memoryVersion struct {
+	kind          string
+	key           memoryGrant
+	grant         Grant
+	edge          memoryEdge
+	recordedFrom  time.Time
+	recordedUntil time.Time
+}
+
+// memoryVersionKey is the key of the current version of a row, see
+// MemoryStore.current
+type memoryVersionKey struct {
+	kind string
+	key  memoryGrant
+}
+
+// memoryTree maps every id to its parents and the window of the edge, the
+// methods must be called with the lock of the MemoryStore held
+type memoryTree map[string]map[string]memoryEdge
+
+// memoryEdge is the window in which an edge in a memoryTree is valid
+type memoryEdge struct {
+	validFrom  time.Time
+	validUntil time.Time
+}
+
+// NewMemoryStore creates a new empty in-memory Store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		grants:      make(map[memoryGrant]Grant),
+		roles:       make(map[string][]string),
+		assignments: make(map[memoryGrant]struct{}),
+		actors:      make(memoryTree),
+		targets:     make(memoryTree),
+		current:     make(map[memoryVersionKey]int),
+	}
+}
+
+// NewMemory creates a new ACL instance backed by an empty MemoryStore without
+// any bypassFunc
+func NewMemory() *ACL {
+	return NewWithStore(NewMemoryStore(), nil)
+}
+
+// NewMemoryWithBypass creates a new ACL instance backed by an empty
+// MemoryStore with a bypassFunc, see NewWithBypass
+func NewMemoryWithBypass(bypassFunc func(actor Resource, action string, target Resource) bool) *ACL {
+	return NewWithStore(NewMemoryStore(), bypassFunc)
+}
+
+func (m *MemoryStore) SetGrant(ctx context.Context, q Querier, grant Grant) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memoryGrant{grant.ActorId, grant.Action, grant.TargetId}
+
+	if old, ok := m.grants[key]; ok {
+		if !grant.scoped() {
+			grant.ValidFrom, grant.ValidUntil, grant.Condition = old.ValidFrom, old.ValidUntil, old.Condition
+		}
+
+		m.record(ctx, AuditGrant, "UPDATE", grant.ActorId, grant.Action, grant.TargetId, grantRow(old), grantRow(grant))
+	} else {
+		m.record(ctx, AuditGrant, "INSERT", grant.ActorId, grant.Action, grant.TargetId, nil, grantRow(grant))
+	}
+
+	m.version(memoryVersion{kind: AuditGrant, key: key, grant: grant})
+	m.grants[key] = grant
+
+	return nil
+}
+
+func (m *MemoryStore) UnsetGrant(ctx context.Context, q Querier, actorId string, action string, targetId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memoryGrant{actorId, action, targetId}
+
+	if old, ok := m.grants[key]; ok {
+		m.record(ctx, AuditGrant, "DELETE", actorId, action, targetId, grantRow(old), nil)
+	}
+
+	m.unversion(AuditGrant, key)
+	delete(m.grants, key)
+
+	return nil
+}
+
+func (m *MemoryStore) SetParent(ctx context.Context, q Querier, id string, parentId string, validFrom time.Time, validUntil time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	edge := memoryEdge{validFrom, validUntil}
+	old, exists := m.actors[id][parentId]
+
+	/* Like ON CONFLICT DO NOTHING in the SQL stores, nothing is recorded */
+	if exists && validFrom.IsZero() && validUntil.IsZero() {
+		return nil
+	}
+
+	err := m.actors.setParent(id, parentId, edge)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		m.record(ctx, AuditInheritance, "UPDATE", id, "", "", edgeRow(id, parentId, old), edgeRow(id, parentId, edge))
+	} else {
+		m.record(ctx, AuditInheritance, "INSERT", id, "", "", nil, edgeRow(id, parentId, edge))
+	}
+
+	m.version(memoryVersion{kind: AuditInheritance, key: memoryGrant{id, parentId, ""}, edge: edge})
+
+	return nil
+}
+
+func (m *MemoryStore) RemoveParent(ctx context.Context, q Querier, id string, parentId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if old, ok := m.actors[id][parentId]; ok {
+		m.record(ctx, AuditInheritance, "DELETE", id, "", "", edgeRow(id, parentId, old), nil)
+	}
+
+	m.unversion(AuditInheritance, memoryGrant{id, parentId, ""})
+	delete(m.actors[id], parentId)
+
+	return nil
+}
+
+func (m *MemoryStore) Parents(ctx context.Context, q Querier, id string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return []string{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.actors.parents(id), nil
+}
+
+func (m *MemoryStore) Children(ctx context.Context, q Querier, id string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return []string{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.actors.children(id), nil
+}
+
+func (m *MemoryStore) Ancestors(ctx context.Context, q Querier, id string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return []string{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ret := []string{id}
+
+	for ancestorId := range m.actors.ancestors(id, time.Time{}) {
+		ret = append(ret, ancestorId)
+	}
+
+	sort.Strings(ret[1:])
+
+	return ret, nil
+}
+
+func (m *MemoryStore) RemoveExpired(ctx context.Context, q Querier, at time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int64
+
+	for key, grant := range m.grants {
+		if !grant.ValidUntil.IsZero() && !grant.ValidUntil.After(at) {
+			m.record(ctx, AuditGrant, "DELETE", grant.ActorId, grant.Action, grant.TargetId, grantRow(grant), nil)
+			m.unversion(AuditGrant, key)
+			delete(m.grants, key)
+
+			n++
+		}
+	}
+
+	for id, parents := range m.actors {
+		for parentId, edge := range parents {
+			if !edge.validUntil.IsZero() && !edge.validUntil.After(at) {
+				m.record(ctx, AuditInheritance, "DELETE", id, "", "", edgeRow(id, parentId, edge), nil)
+				m.unversion(AuditInheritance, memoryGrant{id, parentId, ""})
+				delete(parents, parentId)
+
+				n++
+			}
+		}
+
+		if len(parents) == 0 {
+			delete(m.actors, id)
+		}
+	}
+
+	return n, nil
+}
+
+func (m *MemoryStore) SetTargetParent(ctx context.Context, q Querier, id string, parentId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	/* Like ON CONFLICT DO NOTHING in the SQL stores, nothing is recorded */
+	if _, exists := m.targets[id][parentId]; exists {
+		return nil
+	}
+
+	err := m.targets.setParent(id, parentId, memoryEdge{})
+	if err != nil {
+		return err
+	}
+
+	m.record(ctx, AuditTargetInheritance, "INSERT", "", "", id, nil, targetEdgeRow(id, parentId))
+
+	return nil
+}
+
+func (m *MemoryStore) RemoveTargetParent(ctx context.Context, q Querier, id string, parentId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.targets[id][parentId]; ok {
+		m.record(ctx, AuditTargetInheritance, "DELETE", "", "", id, targetEdgeRow(id, parentId), nil)
+	}
+
+	delete(m.targets[id], parentId)
+
+	return nil
+}
+
+func (m *MemoryStore) TargetDescendants(ctx context.Context, q Querier, ids []string, page Page) (map[string]map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return pageTargetLevels(m.targets.descendants(ids, time.Time{}), page), nil
+}
+
+func (m *MemoryStore) TargetLevels(ctx context.Context, q Querier, ids []string) (map[string]map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.targetLevels(ids), nil
+}
+
+func (m *MemoryStore) Candidates(ctx context.Context, q Querier, query Query) ([]Candidate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !query.AsOf.IsZero() {
+		asOf := query.AsOf
+		query.AsOf = time.Time{}
+
+		return m.snapshot(asOf).Candidates(ctx, q, query)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	candidates := m.candidates(query.ActorId, query.At, m.matching(query))
+
+	if query.Ancestors {
+		for id, path := range m.actors.ancestors(query.ActorId, query.At) {
+			candidates = append(candidates, Candidate{Grant: Grant{ActorId: id}, Level: len(path) - 1, Path: path})
+		}
+	}
+
+	return candidates, nil
+}
+
+func (m *MemoryStore) TargetCandidates(ctx context.Context, q Querier, query Query) ([]Candidate, map[string]map[string]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if !query.AsOf.IsZero() {
+		asOf := query.AsOf
+		query.AsOf = time.Time{}
+
+		return m.snapshot(asOf).TargetCandidates(ctx, q, query)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	levels := m.targetLevels(query.TargetIds)
+	all := make([]map[string]int, 0, len(levels))
+
+	for _, targetLevels := range levels {
+		all = append(all, targetLevels)
+	}
+
+	query.TargetIds = targetIds(all...)
+
+	return m.candidates(query.ActorId, query.At, m.matching(query)), levels, nil
+}
+
+func (m *MemoryStore) Inheritors(ctx context.Context, q Querier, query Query) ([]Inheritor, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !query.AsOf.IsZero() {
+		asOf := query.AsOf
+		query.AsOf = time.Time{}
+
+		return m.snapshot(asOf).Inheritors(ctx, q, query)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	grants := m.matching(query)
+	var actorIds []string
+
+	for _, grant := range grants {
+		actorIds = append(actorIds, grant.ActorId)
+	}
+
+	parents := make(map[string]struct{})
+
+	for _, edges := range m.actors {
+		for parentId := range edges {
+			parents[parentId] = struct{}{}
+		}
+	}
+
+	var ret []Inheritor
+
+	for actorId := range m.actors.descendants(actorIds, query.At) {
+		_, parent := parents[actorId]
+
+		ret = append(ret, Inheritor{ActorId: actorId, Leaf: !parent, Candidates: m.candidates(actorId, query.At, grants)})
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ActorId < ret[j].ActorId
+	})
+
+	return ret, nil
+}
+
+// targetLevels maps each of the ids to its ancestors in the target tree and
+// their nearest level, see TargetLevels
+func (m *MemoryStore) targetLevels(ids []string) map[string]map[string]int {
+	ret := make(map[string]map[string]int, len(ids))
+
+	for _, id := range ids {
+		ret[id] = map[string]int{id: 0}
+
+		for ancestorId, path := range m.targets.ancestors(id, time.Time{}) {
+			ret[id][ancestorId] = len(path) - 1
+		}
+	}
+
+	return ret
+}
+
+// matching lists the grants valid at the time of the query matching its
+// actions and targets, regardless of the actor
+func (m *MemoryStore) matching(query Query) []Grant {
+	var ret []Grant
+
+	for _, grant := range m.all(query.At) {
+		if matches(query.Actions, grant.Action) && matches(query.TargetIds, grant.TargetId) {
+			ret = append(ret, grant)
+		}
+	}
+
+	return ret
+}
+
+// candidates lists the grants applying to the actor through the edges valid
+// at the time as candidates, in the order of Candidates
+func (m *MemoryStore) candidates(actorId string, at time.Time, grants []Grant) []Candidate {
+	paths := m.actors.ancestors(actorId, at)
+	paths[actorId] = []string{actorId}
+
+	var ret []Candidate
+
+	for _, grant := range grants {
+		path, ok := paths[grant.ActorId]
+		if !ok {
+			continue
+		}
+
+		ret = append(ret, Candidate{Grant: grant, Level: len(path) - 1, Path: append([]string(nil), path...)})
+	}
+
+	/* Map iteration order is random, sort to be as deterministic as the SQL stores */
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].ActorId != ret[j].ActorId {
+			return ret[i].ActorId < ret[j].ActorId
+		}
+
+		if ret[i].Action != ret[j].Action {
+			return ret[i].Action < ret[j].Action
+		}
+
+		if ret[i].TargetId != ret[j].TargetId {
+			return ret[i].TargetId > ret[j].TargetId
+		}
+
+		return ret[i].Role < ret[j].Role
+	})
+	sortCandidates(ret)
+
+	return ret
+}
+
+func (m *MemoryStore) Grants(ctx context.Context, q Querier, query Query) ([]Grant, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if !query.AsOf.IsZero() {
+		asOf := query.AsOf
+		query.AsOf = time.Time{}
+
+		return m.snapshot(asOf).Grants(ctx, q, query)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var ret []Grant
+
+	for _, grant := range m.all(query.At) {
+		if (query.ActorId != "" && grant.ActorId != query.ActorId) || !matches(query.Actions, grant.Action) || !matches(query.TargetIds, grant.TargetId) {
+			continue
+		}
+
+		ret = append(ret, grant)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].ActorId != ret[j].ActorId {
+			return ret[i].ActorId < ret[j].ActorId
+		}
+
+		if ret[i].Action != ret[j].Action {
+			return ret[i].Action < ret[j].Action
+		}
+
+		if ret[i].TargetId != ret[j].TargetId {
+			return ret[i].TargetId < ret[j].TargetId
+		}
+
+		return ret[i].Role < ret[j].Role
+	})
+
+	return ret, nil
+}
+
+func (m *MemoryStore) SetRoleActions(ctx context.Context, q Querier, role string, actions []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, action := range m.roles[role] {
+		m.record(ctx, AuditRoleAction, "DELETE", "", action, "", map[string]interface{}{"role": role, "action": action}, nil)
+		m.unversion(AuditRoleAction, memoryGrant{role, action, ""})
+	}
+
+	for _, action := range actions {
+		m.record(ctx, AuditRoleAction, "INSERT", "", action, "", nil, map[string]interface{}{"role": role, "action": action})
+		m.version(memoryVersion{kind: AuditRoleAction, key: memoryGrant{role, action, ""}})
+	}
+
+	m.roles[role] = append([]string(nil), actions...)
+
+	return nil
+}
+
+func (m *MemoryStore) AssignRole(ctx context.Context, q Querier, actorId string, role string, targetId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memoryGrant{actorId, role, targetId}
+
+	if _, ok := m.assignments[key]; !ok {
+		m.record(ctx, AuditRoleAssignment, "INSERT", actorId, "", targetId, nil, map[string]interface{}{"actor_id": actorId, "role": role, "target_id": targetId})
+		m.version(memoryVersion{kind: AuditRoleAssignment, key: key})
+	}
+
+	m.assignments[key] = struct{}{}
+
+	return nil
+}
+
+func (m *MemoryStore) RevokeRole(ctx context.Context, q Querier, actorId string, role string, targetId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := memoryGrant{actorId, role, targetId}
+
+	if _, ok := m.assignments[key]; ok {
+		m.record(ctx, AuditRoleAssignment, "DELETE", actorId, "", targetId, map[string]interface{}{"actor_id": actorId, "role": role, "target_id": targetId}, nil)
+		m.unversion(AuditRoleAssignment, key)
+	}
+
+	delete(m.assignments, key)
+
+	return nil
+}
+
+func (m *MemoryStore) AuditLog(ctx context.Context, q Querier, query AuditQuery) ([]AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ret := []AuditEntry{}
+
+	/* The entries are ordered by id */
+	start := sort.Search(len(m.audit), func(i int) bool {
+		return m.audit[i].Id > query.After
+	})
+
+	for _, e := range m.audit[start:] {
+		if (query.ActorId != "" && e.ActorId != query.ActorId) || (query.Action != "" && e.Action != query.Action) || (query.TargetId != "" && e.TargetId != query.TargetId) {
+			continue
+		}
+
+		if (!query.From.IsZero() && e.ChangedAt.Before(query.From)) || (!query.Until.IsZero() && !e.ChangedAt.Before(query.Until)) {
+			continue
+		}
+
+		if query.Limit > 0 && len(ret) == query.Limit {
+			break
+		}
+
+		ret = append(ret, e)
+	}
+
+	return ret, nil
+}
+
+// record appends a change to the audit log, must be called with the lock held
+func (m *MemoryStore) record(ctx context.Context, kind string, operation string, actorId string, action string, targetId string, old map[string]interface{}, new map[string]interface{}) {
+	m.auditId++
+
+	m.audit = append(m.audit, AuditEntry{
+		Id:        m.auditId,
+		ChangedAt: time.Now().UTC(),
+		ChangedBy: changedBy(ctx),
+		Kind:      kind,
+		Operation: operation,
+		ActorId:   actorId,
+		Action:    action,
+		TargetId:  targetId,
+		Old:       old,
+		New:       new,
+	})
+}
+
+// version records v as the current version of its key from now on, must be
+// called with the lock held
+func (m *MemoryStore) version(v memoryVersion) {
+	v.recordedFrom = time.Now()
+
+	m.endVersion(v.kind, v.key, v.recordedFrom)
+	m.versions = append(m.versions, v)
+	m.current[memoryVersionKey{v.kind, v.key}] = len(m.versions) - 1
+}
+
+// unversion ends the current version of the key from now on, if any, must be
+// called with the lock held
+func (m *MemoryStore) unversion(kind string, key memoryGrant) {
+	m.endVersion(kind, key, time.Now())
+}
+
+// endVersion ends the current version of the key at the time, if any
+func (m *MemoryStore) endVersion(kind string, key memoryGrant, at time.Time) {
+	if i, ok := m.current[memoryVersionKey{kind, key}]; ok {
+		m.versions[i].recordedUntil = at
+
+		delete(m.current, memoryVersionKey{kind, key})
+	}
+}
+
+// TruncateHistory removes the entries of the audit log made before the time
+// and the versions which were no longer current at it, bounding the memory
+// used by a long-lived MemoryStore. AuditLog keeps the ids of the remaining
+// entries, while AllowsActionOnAt no longer sees the state before the time
+func (m *MemoryStore) TruncateHistory(before time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	start := 0
+
+	for start < len(m.audit) && m.audit[start].ChangedAt.Before(before) {
+		start++
+	}
+
+	m.audit = append([]AuditEntry(nil), m.audit[start:]...)
+
+	versions := make([]memoryVersion, 0, len(m.versions))
+
+	for _, v := range m.versions {
+		if v.recordedUntil.IsZero() {
+			m.current[memoryVersionKey{v.kind, v.key}] = len(versions)
+		} else if !v.recordedUntil.After(before) {
+			continue
+		}
+
+		versions = append(versions, v)
+	}
+
+	m.versions = versions
+}
+
+// snapshot returns a MemoryStore with the versions current at the time, the
+// target tree is not versioned and is shared
+func (m *MemoryStore) snapshot(asOf time.Time) *MemoryStore {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ret := NewMemoryStore()
+	ret.targets = m.targets.copy()
+
+	for _, v := range m.versions {
+		if v.recordedFrom.After(asOf) || (!v.recordedUntil.IsZero() && !v.recordedUntil.After(asOf)) {
+			continue
+		}
+
+		switch v.kind {
+		case AuditGrant:
+			ret.grants[v.key] = v.grant
+		case AuditInheritance:
+			if ret.actors[v.key.actor] == nil {
+				ret.actors[v.key.actor] = make(map[string]memoryEdge)
+			}
+
+			ret.actors[v.key.actor][v.key.action] = v.edge
+		case AuditRoleAction:
+			ret.roles[v.key.actor] = append(ret.roles[v.key.actor], v.key.action)
+		case AuditRoleAssignment:
+			ret.assignments[v.key] = struct{}{}
+		}
+	}
+
+	return ret
+}
+
+// grantRow returns the columns of the grant as recorded in the audit log
+func grantRow(grant Grant) map[string]interface{} {
+	return map[string]interface{}{
+		"actor_id":    grant.ActorId,
+		"action":      grant.Action,
+		"target_id":   grant.TargetId,
+		"allowed":     grant.Allowed,
+		"valid_from":  auditTime(grant.ValidFrom),
+		"valid_until": auditTime(grant.ValidUntil),
+		"condition":   grant.Condition,
+	}
+}
+
+// edgeRow returns the columns of the inheritance as recorded in the audit log
+func edgeRow(id string, parentId string, edge memoryEdge) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          id,
+		"parent_id":   parentId,
+		"valid_from":  auditTime(edge.validFrom),
+		"valid_until": auditTime(edge.validUntil),
+	}
+}
+
+// targetEdgeRow returns the target tree edge as recorded in the audit log
+func targetEdgeRow(id string, parentId string) map[string]interface{} {
+	return map[string]interface{}{"id": id, "parent_id": parentId}
+}
+
+// auditTime returns the time as recorded in the audit log, nil for a zero time
+func auditTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t
+}
+
+// all returns the rows of the ACL table valid at the time along with the
+// grants of the assigned roles, must be called with the lock held
+func (m *MemoryStore) all(at time.Time) []Grant {
+	ret := make([]Grant, 0, len(m.grants))
+
+	for _, grant := range m.grants {
+		if !validAt(grant.ValidFrom, grant.ValidUntil, at) {
+			continue
+		}
+
+		/* The SQL stores do not read back the window */
+		grant.ValidFrom, grant.ValidUntil = time.Time{}, time.Time{}
+
+		ret = append(ret, grant)
+	}
+
+	for key := range m.assignments {
+		for _, action := range m.roles[key.action] {
+			ret = append(ret, Grant{ActorId: key.actor, Action: action, TargetId: key.target, Allowed: true, Role: key.action})
+		}
+	}
+
+	return ret
+}
+
+// setParent makes id inherit from parentId within the window of the edge,
+// refusing to create cycles
+func (t memoryTree) setParent(id string, parentId string, edge memoryEdge) error {
+	if _, ok := t[id][parentId]; ok {
+		t[id][parentId] = edge
+
+		return nil
+	}
+
+	/* Same check as the PreventCycles trigger, id must not be an ancestor of parentId */
+	if _, ok := t.ancestors(parentId, time.Time{})[id]; ok || id == parentId {
+		return ErrCycle
+	}
+
+	if t[id] == nil {
+		t[id] = make(map[string]memoryEdge)
+	}
+
+	t[id][parentId] = edge
+
+	return nil
+}
+
+// copy returns a copy of the tree
+func (t memoryTree) copy() memoryTree {
+	ret := make(memoryTree, len(t))
+
+	for id, parents := range t {
+		ret[id] = make(map[string]memoryEdge, len(parents))
+
+		for parentId, edge := range parents {
+			ret[id][parentId] = edge
+		}
+	}
+
+	return ret
+}
+
+// parents lists the ids which id directly inherits from
+func (t memoryTree) parents(id string) []string {
+	var ret []string
+
+	for parentId := range t[id] {
+		ret = append(ret, parentId)
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// children lists the ids which directly inherit from id
+func (t memoryTree) children(id string) []string {
+	var ret []string
+
+	for childId, parents := range t {
+		if _, ok := parents[id]; ok {
+			ret = append(ret, childId)
+		}
+	}
+
+	sort.Strings(ret)
+
+	return ret
+}
+
+// descendants maps the ids and every id inheriting from them through the
+// edges valid at the time to those of the ids they are or inherit from and
+// their nearest level, a zero at includes every edge
+func (t memoryTree) descendants(ids []string, at time.Time) map[string]map[string]int {
+	children := make(map[string][]string)
+
+	for id, parents := range t {
+		for parentId, edge := range parents {
+			if validAt(edge.validFrom, edge.validUntil, at) {
+				children[parentId] = append(children[parentId], id)
+			}
+		}
+	}
+
+	ret := make(map[string]map[string]int)
+
+	for _, rootId := range ids {
+		levels := map[string]int{rootId: 0}
+		current := []string{rootId}
+
+		for level := 1; len(current) > 0; level++ {
+			var next []string
+
+			for _, c := range current {
+				for _, childId := range children[c] {
+					if _, seen := levels[childId]; !seen {
+						levels[childId] = level
+						next = append(next, childId)
+					}
+				}
+			}
+
+			current = next
+		}
+
+		for id, level := range levels {
+			if ret[id] == nil {
+				ret[id] = make(map[string]int)
+			}
+
+			ret[id][rootId] = level
+		}
+	}
+
+	return ret
+}
+
+// ancestors returns all ancestors of id through the edges valid at the time
+// mapped to the path of their nearest level, starting with id and ending with
+// the ancestor, a zero at includes every edge
+func (t memoryTree) ancestors(id string, at time.Time) map[string][]string {
+	paths := make(map[string][]string)
+	current := []string{id}
+	paths[id] = []string{id}
+
+	for len(current) > 0 {
+		var next []string
+
+		for _, c := range current {
+			parentIds := make([]string, 0, len(t[c]))
+
+			for parentId, edge := range t[c] {
+				if validAt(edge.validFrom, edge.validUntil, at) {
+					parentIds = append(parentIds, parentId)
+				}
+			}
+
+			/* Sorted to pick the same path every time when several are equally near */
+			sort.Strings(parentIds)
+
+			for _, parentId := range parentIds {
+				if _, seen := paths[parentId]; seen {
+					continue
+				}
+
+				paths[parentId] = append(append([]string(nil), paths[c]...), parentId)
+				next = append(next, parentId)
+			}
+		}
+
+		current = next
+	}
+
+	delete(paths, id)
+
+	return paths
+}
+
+// matches returns true if the list is nil or contains the value
+func matches(list []string, value string) bool {
+	if list == nil {
+		return true
+	}
+
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
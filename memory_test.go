@@ -0,0 +1,369 @@
+package acl
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMemory(t *testing.T) {
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+	userC := idAble{id: "7be24c16-6376-478d-91c9-f879116d1d49"}
+
+	testResourceA := idAble{id: "e74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	Convey("With an empty in-memory ACL", t, func() {
+		acl := NewMemory()
+
+		Convey("It should deny access requests without a bypassFunc", func() {
+			allowed, err := acl.AllowsAction(nil, userA, "test")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			allowed, err = acl.AllowsActionOn(nil, userA, "test", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("It should allow access requests with bypassFunc giving true", func() {
+			acl := NewMemoryWithBypass(func(actor Resource, action string, target Resource) bool {
+				return true
+			})
+
+			allowed, err := acl.AllowsAction(nil, userA, "test")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionOn(nil, userA, "test", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+
+		Convey("It should return an error with a cancelled context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			allowed, err := acl.AllowsActionContext(ctx, nil, userA, "test")
+			So(err, ShouldEqual, context.Canceled)
+			So(allowed, ShouldEqual, false)
+		})
+	})
+
+	Convey("When SetActionAllowed() is set to true and SetActionAllowedOn() is set to false", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActionAllowed(nil, userA, "test", true), ShouldBeNil)
+		So(acl.SetActionAllowedOn(nil, userA, "test", testResourceA, false), ShouldBeNil)
+
+		Convey("AllowsAction()   should return true", func() {
+			allowed, err := acl.AllowsAction(nil, userA, "test")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+
+		Convey("AllowsActionOn() should return false", func() {
+			allowed, err := acl.AllowsActionOn(nil, userA, "test", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("The ACL should check as an ActionAuthorizer with a nil *sql.Tx", func() {
+			var tx *sql.Tx
+			var authorizer ActionAuthorizer = acl
+
+			allowed, err := authorizer.AllowsAction(tx, userA, "test")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = authorizer.AllowsActionOn(tx, userA, "test", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("AllowsActionOn() should return true after UnsetActionAllowedOn()", func() {
+			So(acl.UnsetActionAllowedOn(nil, userA, "test", testResourceA), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOn(nil, userA, "test", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+
+		Convey("AllowsAction()   should return false after UnsetActionAllowed()", func() {
+			So(acl.UnsetActionAllowed(nil, userA, "test"), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(nil, userA, "test")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+	})
+
+	Convey("When A inherits from B", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+
+		Convey("SetActionAllowed(true) on B should allow A", func() {
+			So(acl.SetActionAllowed(nil, userB, "testing", true), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			Convey("And SetActionAllowed(false) on A should disable A", func() {
+				So(acl.SetActionAllowed(nil, userA, "testing", false), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+		})
+
+		Convey("SetActionAllowedOn(true) on B should allow A on resource", func() {
+			So(acl.SetActionAllowedOn(nil, userB, "testing", testResourceA, true), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOn(nil, userA, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			Convey("And SetActionAllowed(false) on A should disable A on resource", func() {
+				So(acl.SetActionAllowed(nil, userA, "testing", false), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(nil, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+		})
+
+		Convey("SetActionAllowed(false) on B and SetActionAllowedOn(true) on B should allow A on resource", func() {
+			So(acl.SetActionAllowed(nil, userB, "testing", false), ShouldBeNil)
+			So(acl.SetActionAllowedOn(nil, userB, "testing", testResourceA, true), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOn(nil, userA, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+
+		Convey("Attempting to establish B -> A should return an error", func() {
+			So(acl.SetActorInherits(nil, userB, userA), ShouldNotBeNil)
+		})
+
+		Convey("Attempting to establish B -> C -> A should return an error", func() {
+			So(acl.SetActorInherits(nil, userB, userC), ShouldBeNil)
+			So(acl.SetActorInherits(nil, userC, userA), ShouldNotBeNil)
+		})
+
+		Convey("Setting an already set relation should not error", func() {
+			So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+
+			parents, err := acl.GetActorInherits(nil, userA)
+			So(err, ShouldBeNil)
+			So(parents, ShouldResemble, []string{userB.GetId()})
+		})
+
+		Convey("RemoveActorInherits() should remove the relation", func() {
+			So(acl.RemoveActorInherits(nil, userA, userB), ShouldBeNil)
+
+			children, err := acl.GetActorChildren(nil, userB)
+			So(err, ShouldBeNil)
+			So(len(children), ShouldEqual, 0)
+		})
+	})
+
+	Convey("When A inherits from B and C", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+		So(acl.SetActorInherits(nil, userA, userC), ShouldBeNil)
+
+		Convey("GetActorInherits() should display all parents", func() {
+			parents, err := acl.GetActorInherits(nil, userA)
+			So(err, ShouldBeNil)
+			So(parents, ShouldResemble, []string{userB.GetId(), userC.GetId()})
+		})
+
+		Convey("SetActionAllowed(true) on B and SetActionAllowed(false) on C should disable A", func() {
+			So(acl.SetActionAllowed(nil, userB, "testing", true), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userC, "testing", false), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("The nearest level should win over a deny further away", func() {
+			So(acl.SetActorInherits(nil, userC, userB), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userB, "testing", false), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userC, "testing", true), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			So(acl.RemoveActorInherits(nil, userA, userB), ShouldBeNil)
+
+			allowed, err = acl.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+	})
+
+	Convey("When a document inherits from a folder which inherits from a drive", t, func() {
+		acl := NewMemory()
+		ctx := context.Background()
+
+		drive := idAble{id: "d0000000-0000-4000-8000-000000000001"}
+		folder := idAble{id: "d0000000-0000-4000-8000-000000000002"}
+		document := testResourceA
+
+		So(acl.SetTargetInherits(nil, document, folder), ShouldBeNil)
+		So(acl.SetTargetInherits(nil, folder, drive), ShouldBeNil)
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+
+		Convey("Attempting to establish drive -> document should return an error", func() {
+			So(acl.SetTargetInherits(nil, drive, document), ShouldNotBeNil)
+		})
+
+		Convey("SetActionAllowedOn(true) on the drive should allow the document", func() {
+			So(acl.SetActionAllowedOn(nil, userA, "edit", drive, true), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOn(nil, userA, "edit", document)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			Convey("And SetActionAllowedOn(false) on the folder should win as the nearer target", func() {
+				So(acl.SetActionAllowedOn(nil, userA, "edit", folder, false), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(nil, userA, "edit", document)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+
+				allowed, err = acl.AllowsActionOn(nil, userA, "edit", drive)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+
+				decision, err := acl.ExplainActionOn(ctx, nil, userA, "edit", document)
+				So(err, ShouldBeNil)
+				So(decision.Source.TargetId, ShouldEqual, folder.GetId())
+				So(decision.Source.TargetLevel, ShouldEqual, 1)
+				So(decision.Shadowed[0].TargetLevel, ShouldEqual, 2)
+			})
+
+			Convey("And SetActionAllowedOn(false) on the document for B should lose to the nearer actor", func() {
+				So(acl.SetActionAllowedOn(nil, userB, "edit", document, false), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(nil, userA, "edit", document)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("And SetActionAllowed(false) on A should lose to the specific target", func() {
+				So(acl.SetActionAllowed(nil, userA, "edit", false), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(nil, userA, "edit", document)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("And RemoveTargetInherits() should remove the permission", func() {
+				So(acl.RemoveTargetInherits(nil, folder, drive), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(nil, userA, "edit", document)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("And the listings should include the inheriting targets", func() {
+				list, err := acl.ListTargets(ctx, nil, userA, "edit", Page{})
+				So(err, ShouldBeNil)
+				So(list.Ids, ShouldResemble, []string{drive.GetId(), folder.GetId(), document.GetId()})
+
+				actors, err := acl.ListActors(ctx, nil, document, "edit", false)
+				So(err, ShouldBeNil)
+				So(actors, ShouldResemble, []string{userA.GetId()})
+
+				many, err := acl.AllowsActionOnMany(ctx, nil, userA, "edit", []Resource{document, userC})
+				So(err, ShouldBeNil)
+				So(many, ShouldResemble, map[string]bool{document.GetId(): true, userC.GetId(): false})
+			})
+		})
+	})
+
+	Convey("When querying the MemoryStore directly", t, func() {
+		store := NewMemoryStore()
+		ctx := context.Background()
+
+		So(store.SetParent(ctx, nil, userA.GetId(), userB.GetId(), time.Time{}, time.Time{}), ShouldBeNil)
+		So(store.SetParent(ctx, nil, userA.GetId(), userC.GetId(), time.Time{}, time.Time{}), ShouldBeNil)
+		So(store.SetParent(ctx, nil, userC.GetId(), userB.GetId(), time.Time{}, time.Time{}), ShouldBeNil)
+		So(store.SetGrant(ctx, nil, Grant{ActorId: userB.GetId(), Action: "testing", TargetId: EMPTY_RESOURCE, Allowed: true}), ShouldBeNil)
+		So(store.SetGrant(ctx, nil, Grant{ActorId: userC.GetId(), Action: "testing", TargetId: testResourceA.GetId(), Allowed: false}), ShouldBeNil)
+		So(store.SetGrant(ctx, nil, Grant{ActorId: userC.GetId(), Action: "other", TargetId: EMPTY_RESOURCE, Allowed: false}), ShouldBeNil)
+
+		Convey("Candidates() should return each grant once with its nearest level", func() {
+			candidates, err := store.Candidates(ctx, nil, Query{ActorId: userA.GetId(), Actions: []string{"testing"}})
+			So(err, ShouldBeNil)
+			So(candidates, ShouldResemble, []Candidate{
+				{Grant: Grant{ActorId: userC.GetId(), Action: "testing", TargetId: testResourceA.GetId(), Allowed: false}, Level: 1, Path: []string{userA.GetId(), userC.GetId()}},
+				{Grant: Grant{ActorId: userB.GetId(), Action: "testing", TargetId: EMPTY_RESOURCE, Allowed: true}, Level: 1, Path: []string{userA.GetId(), userB.GetId()}},
+			})
+		})
+
+		Convey("Candidates() should filter on targets", func() {
+			candidates, err := store.Candidates(ctx, nil, Query{ActorId: userC.GetId(), TargetIds: []string{EMPTY_RESOURCE}})
+			So(err, ShouldBeNil)
+			So(candidates, ShouldResemble, []Candidate{
+				{Grant: Grant{ActorId: userC.GetId(), Action: "other", TargetId: EMPTY_RESOURCE, Allowed: false}, Level: 0, Path: []string{userC.GetId()}},
+				{Grant: Grant{ActorId: userB.GetId(), Action: "testing", TargetId: EMPTY_RESOURCE, Allowed: true}, Level: 1, Path: []string{userC.GetId(), userB.GetId()}},
+			})
+		})
+
+		Convey("An ACL using the store should resolve the candidates", func() {
+			acl := NewWithStore(store, nil)
+
+			allowed, err := acl.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionOn(nil, userA, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+	})
+
+	Convey("When used concurrently", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+
+			go func(allowed bool) {
+				defer wg.Done()
+
+				acl.SetActionAllowed(nil, userB, "testing", allowed)
+			}(i%2 == 0)
+
+			go func() {
+				defer wg.Done()
+
+				acl.AllowsAction(nil, userA, "testing")
+			}()
+		}
+
+		wg.Wait()
+
+		So(acl.SetActionAllowed(nil, userB, "testing", true), ShouldBeNil)
+
+		allowed, err := acl.AllowsAction(nil, userA, "testing")
+		So(err, ShouldBeNil)
+		So(allowed, ShouldEqual, true)
+	})
+}
@@ -0,0 +1,1205 @@
+package acl
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MySQLUUIDFormat is the column type used to store ids in MySQL
+type MySQLUUIDFormat int
+
+const (
+	// MySQLUUIDChar stores ids as their textual representation in CHAR(36)
+	MySQLUUIDChar MySQLUUIDFormat = iota
+	// MySQLUUIDBinary stores ids as BINARY(16), all ids must be valid UUIDs in
+	// the lowercase canonical form since that is how they are read back
+	MySQLUUIDBinary
+)
+
+// columnType returns the SQL type and the EMPTY_RESOURCE literal for the format
+func (f MySQLUUIDFormat) columnType() (string, string) {
+	if f == MySQLUUIDBinary {
+		return "BINARY(16)", "X'" + strings.Replace(EMPTY_RESOURCE, "-", "", -1) + "'"
+	}
+
+	return "CHAR(36)", "'" + EMPTY_RESOURCE + "'"
+}
+
+// MySQL has no ARRAY type, the recursive queries track the visited ids in a
+// comma-separated string of hex-encoded ids instead. The path column needs an
+// explicit width since it is determined by the non-recursive part.
+
+var tpl_mysql_tree_table = "CREATE TABLE IF NOT EXISTS `$TABLE`" + `
+(
+	id $UUID NOT NULL,
+	parent_id $UUID NOT NULL,
+	PRIMARY KEY (id, parent_id)
+);`
+
+var tpl_mysql_prevent_cycles_trigger = "CREATE TRIGGER `$TRIGGER` BEFORE $EVENT ON `$TABLE` FOR EACH ROW" + `
+BEGIN
+	IF NEW.id = NEW.parent_id OR EXISTS (
+		WITH RECURSIVE q (parent_id, path) AS (
+			SELECT t.parent_id, CAST(CONCAT(',', HEX(NEW.parent_id), ',') AS CHAR(10000))
+			FROM ` + "`$TABLE`" + ` t
+			WHERE t.id = NEW.parent_id
+		UNION ALL
+			SELECT t.parent_id, CONCAT(q.path, HEX(t.id), ',')
+			FROM q
+			JOIN ` + "`$TABLE`" + ` t ON t.id = q.parent_id
+			WHERE LOCATE(CONCAT(',', HEX(t.id), ','), q.path) = 0
+		)
+		SELECT 1 FROM q WHERE q.parent_id = NEW.id
+	) THEN
+		SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'Cycles are not allowed in "$TABLE"';
+	END IF;
+END`
+
+var tpl_mysql_actor_delete_trigger = "CREATE TRIGGER `{treeTable}_{relatedTable}_DELETED_REMOVE_PRIMARY` AFTER DELETE ON `{relatedTable}` FOR EACH ROW" + `
+	DELETE FROM ` + "`{treeTable}`" + ` WHERE id = OLD.{relatedKey} OR parent_id = OLD.{relatedKey};`
+
+var tpl_mysql_acl_table = "CREATE TABLE IF NOT EXISTS `$TABLE`" + `
+(
+	actor_id $UUID NOT NULL,
+	action VARCHAR(255) NOT NULL,
+	target_id $UUID NOT NULL DEFAULT $EMPTY,
+	allowed BOOLEAN NOT NULL,
+	PRIMARY KEY (actor_id, action, target_id)
+);`
+
+var tpl_mysql_roles_table = "CREATE TABLE IF NOT EXISTS `$TABLE_Roles`" + `
+(
+	role VARCHAR(255) NOT NULL,
+	action VARCHAR(255) NOT NULL,
+	PRIMARY KEY (role, action)
+);`
+
+var tpl_mysql_role_assignments_table = "CREATE TABLE IF NOT EXISTS `$TABLE_RoleAssignments`" + `
+(
+	actor_id $UUID NOT NULL,
+	role VARCHAR(255) NOT NULL,
+	target_id $UUID NOT NULL DEFAULT $EMPTY,
+	PRIMARY KEY (actor_id, role, target_id)
+);`
+
+var tpl_mysql_validity_columns = "ALTER TABLE `$TABLE`" + `
+	ADD COLUMN valid_from DATETIME(6) NULL,
+	ADD COLUMN valid_until DATETIME(6) NULL;`
+
+var tpl_mysql_condition_column = "ALTER TABLE `$TABLE`" + `
+	ADD COLUMN ` + "`condition`" + ` TEXT NOT NULL DEFAULT ('');`
+
+var tpl_mysql_audit_table = "CREATE TABLE IF NOT EXISTS `$TABLE_Audit`" + `
+(
+	id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	changed_at DATETIME(6) NOT NULL,
+	changed_by VARCHAR(255) NULL,
+	kind VARCHAR(255) NOT NULL,
+	operation VARCHAR(6) NOT NULL,
+	actor_id $UUID NULL,
+	action VARCHAR(255) NULL,
+	target_id $UUID NULL,
+	old_row TEXT NULL,
+	new_row TEXT NULL
+);`
+
+// MySQL has no session settings visible to triggers, the actor making the
+// changes is kept in the @acl_changed_by user variable instead, see
+// WithChangedBy
+var tpl_mysql_audit_trigger = "CREATE TRIGGER `{table}_Audit_{event}` AFTER {event} ON `{table}` FOR EACH ROW" + `
+	INSERT INTO ` + "`{aclTable}_Audit`" + ` (changed_at, changed_by, kind, operation, actor_id, action, target_id, old_row, new_row)
+		VALUES (UTC_TIMESTAMP(6), NULLIF(@acl_changed_by, ''), '{kind}', '{event}', {actor}, {action}, {target}, {old}, {new});`
+
+var tpl_mysql_audit_append_only_trigger = "CREATE TRIGGER `$TABLE_Audit_AppendOnly_$EVENT` BEFORE $EVENT ON `$TABLE_Audit` FOR EACH ROW" + `
+	SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'The audit log "$TABLE_Audit" is append-only';`
+
+var tpl_mysql_history_table = []string{
+	"CREATE TABLE `{table}_History` LIKE `{table}`;",
+	"ALTER TABLE `{table}_History`" + `
+	DROP PRIMARY KEY,
+	ADD COLUMN recorded_from DATETIME(6) NULL,
+	ADD COLUMN recorded_until DATETIME(6) NULL,
+	ADD INDEX ` + "`{table}_History_Keys`" + ` ({keys});`,
+	"INSERT INTO `{table}_History` ({columns}) SELECT {columns} FROM `{table}`;",
+}
+
+var tpl_mysql_history_close = `
+	UPDATE ` + "`{table}_History`" + ` SET recorded_until = UTC_TIMESTAMP(6) WHERE recorded_until IS NULL AND ({keys}) = ({oldKeys});`
+
+var tpl_mysql_history_open = `
+	INSERT INTO ` + "`{table}_History`" + ` ({columns}, recorded_from) VALUES ({newColumns}, UTC_TIMESTAMP(6));`
+
+var tpl_mysql_history_trigger = "CREATE TRIGGER `{table}_History_{event}` AFTER {event} ON `{table}` FOR EACH ROW" + `
+BEGIN{statements}
+END`
+
+var tpl_mysql_link_delete_trigger = "CREATE TRIGGER `{aclTable}_{linkType}_{relatedTable}_DELETED` AFTER DELETE ON `{relatedTable}` FOR EACH ROW" + `
+	DELETE FROM ` + "`{aclTable}`" + ` WHERE {localKey} = OLD.{relatedKey};`
+
+// EnsureMySQLTablesAndRulesExist is the MySQL version of EnsureTablesAndRulesExist,
+// it creates the tables and triggers required by MySQLStore if they do not
+// exist. MySQL commits implicitly after DDL, so this is not run in a transaction.
+func EnsureMySQLTablesAndRulesExist(db *sql.DB, treeTable string, table string, uuids MySQLUUIDFormat, cascades Cascades) error {
+	uuidType, empty := uuids.columnType()
+
+	err := ensureMySQLTree(db, treeTable, uuids, cascades.Actors)
+	if err != nil {
+		return err
+	}
+
+	replacer := strings.NewReplacer("$TABLE", table, "$UUID", uuidType, "$EMPTY", empty)
+
+	for _, tpl := range []string{tpl_mysql_acl_table, tpl_mysql_roles_table, tpl_mysql_role_assignments_table} {
+		_, err = db.Exec(replacer.Replace(tpl))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tableName := range []string{treeTable, table} {
+		err = ensureMySQLColumn(db, tableName, "valid_until", tpl_mysql_validity_columns)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ensureMySQLColumn(db, table, "condition", tpl_mysql_condition_column)
+	if err != nil {
+		return err
+	}
+
+	for _, linkTable := range []string{table, table + "_RoleAssignments"} {
+		err = ensureMySQLLinks(db, linkTable, cascades.Actors, "ACTOR", "actor_id")
+		if err != nil {
+			return err
+		}
+
+		err = ensureMySQLLinks(db, linkTable, cascades.Targets, "TARGET", "target_id")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ensureMySQLAudit(db, treeTable, table, uuids)
+	if err != nil {
+		return err
+	}
+
+	return ensureMySQLHistory(db, treeTable, table)
+}
+
+// ensureMySQLHistory creates the history tables of the audited tables and the
+// triggers maintaining them, see ACL.AllowsActionOnAt
+func ensureMySQLHistory(db *sql.DB, treeTable string, table string) error {
+	for _, audited := range auditedTables(treeTable, table) {
+		replacer := strings.NewReplacer("{table}", audited.table, "{columns}", historyColumns(audited.columns, "`", "`"),
+			"{keys}", historyColumns(audited.keys, "`", "`"), "{oldKeys}", historyColumns(audited.keys, "OLD.`", "`"), "{newColumns}", historyColumns(audited.columns, "NEW.`", "`"))
+
+		exists, err := mysqlColumnExists(db, audited.table+"_History", "recorded_until")
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			/* Rows predating the history are recorded as current since forever */
+			for _, tpl := range tpl_mysql_history_table {
+				_, err = db.Exec(replacer.Replace(tpl))
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		statements := map[string]string{
+			"INSERT": tpl_mysql_history_open,
+			"UPDATE": tpl_mysql_history_close + tpl_mysql_history_open,
+			"DELETE": tpl_mysql_history_close,
+		}
+
+		for _, event := range []string{"INSERT", "UPDATE", "DELETE"} {
+			err = ensureMySQLTrigger(db, fmt.Sprintf("%s_History_%s", audited.table, event), replacer.Replace(strings.NewReplacer("{event}", event, "{statements}", statements[event]).Replace(tpl_mysql_history_trigger)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ensureMySQLAudit creates the append-only audit log and the triggers filling
+// it, see ACL.AuditLog
+func ensureMySQLAudit(db *sql.DB, treeTable string, table string, uuids MySQLUUIDFormat) error {
+	uuidType, _ := uuids.columnType()
+
+	_, err := db.Exec(strings.NewReplacer("$TABLE", table, "$UUID", uuidType).Replace(tpl_mysql_audit_table))
+	if err != nil {
+		return err
+	}
+
+	for _, event := range []string{"UPDATE", "DELETE"} {
+		err = ensureMySQLTrigger(db, fmt.Sprintf("%s_Audit_AppendOnly_%s", table, event), strings.NewReplacer("$TABLE", table, "$EVENT", event).Replace(tpl_mysql_audit_append_only_trigger))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, audited := range auditedTables(treeTable, table) {
+		err = ensureMySQLAuditTriggers(db, table, audited, uuids)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureMySQLAuditTriggers creates the triggers recording the changes of the
+// audited table in the audit log of the ACL table
+func ensureMySQLAuditTriggers(db *sql.DB, table string, audited auditedTable, uuids MySQLUUIDFormat) error {
+	ids := map[string]bool{"id": true, "parent_id": true, "actor_id": true, "target_id": true}
+
+	for _, event := range []string{"INSERT", "UPDATE", "DELETE"} {
+		row := "NEW"
+		if event == "DELETE" {
+			row = "OLD"
+		}
+
+		column := func(name string) string {
+			if name == "" {
+				return "NULL"
+			}
+
+			return row + ".`" + name + "`"
+		}
+
+		/* Binary ids are recorded in their textual representation */
+		object := func(row string) string {
+			var pairs []string
+
+			for _, name := range audited.columns {
+				value := row + ".`" + name + "`"
+
+				if ids[name] && uuids == MySQLUUIDBinary {
+					value = "LOWER(INSERT(INSERT(INSERT(INSERT(HEX(" + value + "), 9, 0, '-'), 14, 0, '-'), 19, 0, '-'), 24, 0, '-'))"
+				}
+
+				pairs = append(pairs, "'"+name+"', "+value)
+			}
+
+			return "JSON_OBJECT(" + strings.Join(pairs, ", ") + ")"
+		}
+
+		oldRow, newRow := object("OLD"), object("NEW")
+
+		switch event {
+		case "INSERT":
+			oldRow = "NULL"
+		case "DELETE":
+			newRow = "NULL"
+		}
+
+		replacer := strings.NewReplacer("{table}", audited.table, "{aclTable}", table, "{event}", event, "{kind}", audited.kind,
+			"{actor}", column(audited.actor), "{action}", column(audited.action), "{target}", column(audited.target), "{old}", oldRow, "{new}", newRow)
+
+		err := ensureMySQLTrigger(db, fmt.Sprintf("%s_Audit_%s", audited.table, event), replacer.Replace(tpl_mysql_audit_trigger))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EnsureMySQLTargetTreeExists is the MySQL version of EnsureTargetTreeExists
+func EnsureMySQLTargetTreeExists(db *sql.DB, targetTreeTable string, table string, uuids MySQLUUIDFormat, links []Link) error {
+	err := ensureMySQLTree(db, targetTreeTable, uuids, links)
+	if err != nil {
+		return err
+	}
+
+	return ensureMySQLAuditTriggers(db, table, targetTreeAudit(targetTreeTable), uuids)
+}
+
+// ensureMySQLTree creates the tree table and the triggers preventing cycles
+func ensureMySQLTree(db *sql.DB, treeTable string, uuids MySQLUUIDFormat, links []Link) error {
+	uuidType, _ := uuids.columnType()
+
+	_, err := db.Exec(strings.NewReplacer("$TABLE", treeTable, "$UUID", uuidType).Replace(tpl_mysql_tree_table))
+	if err != nil {
+		return err
+	}
+
+	/* MySQL triggers only fire on a single event */
+	for _, event := range []string{"INSERT", "UPDATE"} {
+		name := fmt.Sprintf("%s_PreventCycles_%s", treeTable, event)
+
+		err = ensureMySQLTrigger(db, name, strings.NewReplacer("$TRIGGER", name, "$EVENT", event, "$TABLE", treeTable).Replace(tpl_mysql_prevent_cycles_trigger))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, link := range links {
+		replacer := strings.NewReplacer("{treeTable}", treeTable, "{relatedTable}", link.Table, "{relatedKey}", link.Key)
+
+		err = ensureMySQLTrigger(db, fmt.Sprintf("%s_%s_DELETED_REMOVE_PRIMARY", treeTable, link.Table), replacer.Replace(tpl_mysql_actor_delete_trigger))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureMySQLColumn adds the columns of the template to the table if the column
+// is missing, migrating tables created by earlier versions
+func ensureMySQLColumn(db *sql.DB, tableName string, columnName string, tpl string) error {
+	exists, err := mysqlColumnExists(db, tableName, columnName)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(strings.Replace(tpl, "$TABLE", tableName, -1))
+
+	return err
+}
+
+// mysqlColumnExists returns true if the table exists and has the column
+func mysqlColumnExists(db *sql.DB, tableName string, columnName string) (bool, error) {
+	numRows := 0
+	row := db.QueryRow("SELECT COUNT(1) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?", tableName, columnName)
+
+	err := row.Scan(&numRows)
+
+	return numRows > 0, err
+}
+
+// ensureMySQLTrigger creates the trigger unless a trigger with the name exists
+func ensureMySQLTrigger(db *sql.DB, name string, query string) error {
+	numRows := 0
+	row := db.QueryRow("SELECT COUNT(1) FROM information_schema.triggers WHERE trigger_schema = DATABASE() AND trigger_name = ?", name)
+
+	err := row.Scan(&numRows)
+	if err != nil {
+		return err
+	}
+
+	if numRows > 0 {
+		return nil
+	}
+
+	_, err = db.Exec(query)
+
+	return err
+}
+
+func ensureMySQLLinks(db *sql.DB, tableName string, links []Link, linkType string, linkColumn string) error {
+	for _, link := range links {
+		replacer := strings.NewReplacer("{aclTable}", tableName, "{linkType}", linkType, "{relatedTable}", link.Table, "{localKey}", linkColumn, "{relatedKey}", link.Key)
+
+		err := ensureMySQLTrigger(db, fmt.Sprintf("%s_%s_%s_DELETED", tableName, linkType, link.Table), replacer.Replace(tpl_mysql_link_delete_trigger))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MySQLStore is a Store using the tables created by EnsureMySQLTablesAndRulesExist
+// and optionally EnsureMySQLTargetTreeExists, it requires MySQL 8.0 or MariaDB
+// 10.2.2 for recursive queries
+type MySQLStore struct {
+	table           string
+	treeTable       string
+	targetTreeTable string
+	uuids           MySQLUUIDFormat
+}
+
+// NewMySQLStore creates a new Store using the given MySQL tables, the format
+// must match the one used when creating the tables
+func NewMySQLStore(treeTable string, table string, uuids MySQLUUIDFormat) *MySQLStore {
+	return &MySQLStore{treeTable: treeTable, table: table, uuids: uuids}
+}
+
+// NewMySQLStoreWithTargetTree is like NewMySQLStore but includes a target tree
+func NewMySQLStoreWithTargetTree(treeTable string, targetTreeTable string, table string, uuids MySQLUUIDFormat) *MySQLStore {
+	return &MySQLStore{treeTable: treeTable, targetTreeTable: targetTreeTable, table: table, uuids: uuids}
+}
+
+func (s *MySQLStore) SetGrant(ctx context.Context, q Querier, grant Grant) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		ids, err := s.encode(grant.ActorId, grant.TargetId)
+		if err != nil {
+			return err
+		}
+
+		update := "allowed = VALUES(allowed)"
+
+		if grant.scoped() {
+			update += ", valid_from = VALUES(valid_from), valid_until = VALUES(valid_until), `condition` = VALUES(`condition`)"
+		}
+
+		_, err = q.ExecContext(ctx, "INSERT INTO `"+s.table+"` (actor_id, action, target_id, allowed, valid_from, valid_until, `condition`) VALUES (?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE "+update, ids[0], grant.Action, ids[1], grant.Allowed, mysqlTime(grant.ValidFrom), mysqlTime(grant.ValidUntil), grant.Condition)
+
+		return err
+	})
+}
+
+func (s *MySQLStore) UnsetGrant(ctx context.Context, q Querier, actorId string, action string, targetId string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		ids, err := s.encode(actorId, targetId)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.ExecContext(ctx, "DELETE FROM `"+s.table+"` WHERE actor_id = ? AND action = ? AND target_id = ?", ids[0], action, ids[1])
+
+		return err
+	})
+}
+
+func (s *MySQLStore) SetParent(ctx context.Context, q Querier, id string, parentId string, validFrom time.Time, validUntil time.Time) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		ids, err := s.encode(id, parentId)
+		if err != nil {
+			return err
+		}
+
+		if validFrom.IsZero() && validUntil.IsZero() {
+			/* ON DUPLICATE KEY UPDATE fires the update triggers even when nothing changes */
+			_, err = q.ExecContext(ctx, "INSERT INTO `"+s.treeTable+"` (id, parent_id) SELECT ?, ? FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM `"+s.treeTable+"` WHERE id = ? AND parent_id = ?)", ids[0], ids[1], ids[0], ids[1])
+
+			return err
+		}
+
+		_, err = q.ExecContext(ctx, "INSERT INTO `"+s.treeTable+"` (id, parent_id, valid_from, valid_until) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE valid_from = VALUES(valid_from), valid_until = VALUES(valid_until)", ids[0], ids[1], mysqlTime(validFrom), mysqlTime(validUntil))
+
+		return err
+	})
+}
+
+func (s *MySQLStore) RemoveParent(ctx context.Context, q Querier, id string, parentId string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		ids, err := s.encode(id, parentId)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.ExecContext(ctx, "DELETE FROM `"+s.treeTable+"` WHERE id = ? AND parent_id = ?", ids[0], ids[1])
+
+		return err
+	})
+}
+
+func (s *MySQLStore) Parents(ctx context.Context, q Querier, id string) ([]string, error) {
+	return s.queryIds(ctx, q, "SELECT parent_id FROM `"+s.treeTable+"` WHERE id = ? ORDER BY parent_id", id)
+}
+
+func (s *MySQLStore) Children(ctx context.Context, q Querier, id string) ([]string, error) {
+	return s.queryIds(ctx, q, "SELECT id FROM `"+s.treeTable+"` WHERE parent_id = ? ORDER BY id", id)
+}
+
+func (s *MySQLStore) Ancestors(ctx context.Context, q Querier, id string) ([]string, error) {
+	/* Starting from the parents, as the type of a parameter would not fit the columns */
+	ret, err := s.queryIds(ctx, q, "WITH RECURSIVE q (id) AS (SELECT parent_id FROM `"+s.treeTable+"` WHERE id = ? UNION SELECT t.parent_id FROM q JOIN `"+s.treeTable+"` t ON t.id = q.id) SELECT id FROM q", id)
+	if err != nil {
+		return []string{}, err
+	}
+
+	return append([]string{id}, ret...), nil
+}
+
+func (s *MySQLStore) RemoveExpired(ctx context.Context, q Querier, at time.Time) (int64, error) {
+	var n int64
+
+	err := s.audited(ctx, q, func(q Querier) error {
+		var err error
+
+		n, err = execCount(ctx, q, []string{
+			"DELETE FROM `" + s.table + "` WHERE valid_until <= ?",
+			"DELETE FROM `" + s.treeTable + "` WHERE valid_until <= ?",
+		}, mysqlTime(at))
+
+		return err
+	})
+
+	return n, err
+}
+
+func (s *MySQLStore) Candidates(ctx context.Context, q Querier, query Query) ([]Candidate, error) {
+	if (query.Actions != nil && len(query.Actions) == 0) || (query.TargetIds != nil && len(query.TargetIds) == 0) {
+		return nil, nil
+	}
+
+	actorId, err := s.encode(query.ActorId)
+	if err != nil {
+		return nil, err
+	}
+
+	var targetIds []interface{}
+
+	if query.TargetIds != nil {
+		targetIds, err = s.encode(query.TargetIds...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	/* The driver only supports positional placeholders, the arguments are
+	   appended by the fragments in the order they appear in the query */
+	args := []interface{}{}
+	stmt := `WITH RECURSIVE q (parent_id, path, level) AS (
+	SELECT t.parent_id, CAST(CONCAT(',', HEX(t.id), ',') AS CHAR(10000)), 1
+	FROM ` + s.source(&args, s.treeTable, query.AsOf) + ` t
+	WHERE t.id = ` + mysqlPlaceholders(&args, actorId[0]) + mysqlValid(&args, "t", query.At) + `
+UNION ALL
+	SELECT t.parent_id, CONCAT(q.path, HEX(t.id), ','), q.level + 1
+	FROM q
+	JOIN ` + s.source(&args, s.treeTable, query.AsOf) + ` t ON t.id = q.parent_id
+	WHERE LOCATE(CONCAT(',', HEX(t.id), ','), q.path) = 0` + mysqlValid(&args, "t", query.At) + `
+)
+SELECT actor_id, action, target_id, allowed, role, ` + "`condition`" + `, level, path
+FROM (
+	SELECT a.actor_id, a.action, a.target_id, a.allowed, a.role, a.` + "`condition`" + `, h.level, h.path,
+		ROW_NUMBER() OVER (PARTITION BY a.actor_id, a.action, a.target_id, a.role ORDER BY h.level) AS n
+	FROM (
+		SELECT ` + mysqlPlaceholders(&args, actorId[0]) + ` AS id, 0 AS level, CONCAT(',', HEX(` + mysqlPlaceholders(&args, actorId[0]) + `), ',') AS path
+	UNION ALL
+		SELECT q.parent_id AS id, q.level, CONCAT(q.path, HEX(q.parent_id), ',')
+		FROM q
+	) h
+	JOIN ` + s.grants(&args, query.AsOf) + ` a ON a.actor_id = h.id
+	WHERE TRUE` + mysqlFilters(&args, "a", query.At, query.Actions, targetIds) + `
+) c
+WHERE n = 1`
+
+	/* The ancestors are the rows of h without any grant columns */
+	if query.Ancestors {
+		stmt += `
+UNION ALL
+	SELECT ` + mysqlPlaceholders(&args, actorId[0]) + `, '', ` + mysqlPlaceholders(&args, actorId[0]) + `, FALSE, '', '', 0, CONCAT(',', HEX(` + mysqlPlaceholders(&args, actorId[0]) + `), ',')
+UNION ALL
+	SELECT q.parent_id, '', q.parent_id, FALSE, '', '', q.level, CONCAT(q.path, HEX(q.parent_id), ',')
+	FROM q`
+	}
+
+	stmt += `
+ORDER BY level ASC, target_id DESC, allowed ASC`
+
+	candidates, err := queryCandidates(ctx, q, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range candidates {
+		candidates[i].ActorId = s.decode(candidates[i].ActorId)
+		candidates[i].TargetId = s.decode(candidates[i].TargetId)
+
+		/* The path is built from HEX() of the ids to work with both formats */
+		for j, id := range candidates[i].Path {
+			b, _ := hex.DecodeString(id)
+
+			candidates[i].Path[j] = s.decode(string(b))
+		}
+	}
+
+	return candidates, nil
+}
+
+func (s *MySQLStore) TargetCandidates(ctx context.Context, q Querier, query Query) ([]Candidate, map[string]map[string]int, error) {
+	if query.Actions != nil && len(query.Actions) == 0 {
+		return nil, nil, nil
+	}
+
+	actorId, err := s.encode(query.ActorId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roots, err := s.encode(append([]string{EMPTY_RESOURCE}, query.TargetIds...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	/* The driver only supports positional placeholders, the arguments are
+	   appended by the fragments in the order they appear in the query */
+	args := []interface{}{}
+	stmt := `WITH RECURSIVE q (parent_id, path, level) AS (
+	SELECT t.parent_id, CAST(CONCAT(',', HEX(t.id), ',') AS CHAR(10000)), 1
+	FROM ` + s.source(&args, s.treeTable, query.AsOf) + ` t
+	WHERE t.id = ` + mysqlPlaceholders(&args, actorId[0]) + mysqlValid(&args, "t", query.At) + `
+UNION ALL
+	SELECT t.parent_id, CONCAT(q.path, HEX(t.id), ','), q.level + 1
+	FROM q
+	JOIN ` + s.source(&args, s.treeTable, query.AsOf) + ` t ON t.id = q.parent_id
+	WHERE LOCATE(CONCAT(',', HEX(t.id), ','), q.path) = 0` + mysqlValid(&args, "t", query.At) + `
+)`
+
+	/* The levels of the targets are the checked targets themselves on level
+	   0 and their ancestors if there is a target tree */
+	if s.targetTreeTable != "" {
+		stmt += `, tq (start_id, parent_id, path, level) AS (
+	SELECT t.id, t.parent_id, CAST(CONCAT(',', HEX(t.id), ',') AS CHAR(10000)), 1
+	FROM ` + "`" + s.targetTreeTable + "`" + ` t
+	WHERE t.id IN (` + mysqlPlaceholders(&args, roots...) + `)
+UNION ALL
+	SELECT tq.start_id, t.parent_id, CONCAT(tq.path, HEX(t.id), ','), tq.level + 1
+	FROM tq
+	JOIN ` + "`" + s.targetTreeTable + "`" + ` t ON t.id = tq.parent_id
+	WHERE LOCATE(CONCAT(',', HEX(t.id), ','), tq.path) = 0
+), tl (start_id, target_id, level) AS (
+	SELECT start_id, parent_id, MIN(level)
+	FROM tq
+	GROUP BY start_id, parent_id`
+	} else {
+		stmt += `, tl (start_id, target_id, level) AS (`
+	}
+
+	for i, root := range roots {
+		if i > 0 || s.targetTreeTable != "" {
+			stmt += `
+UNION ALL`
+		}
+
+		stmt += `
+	SELECT ` + mysqlPlaceholders(&args, root) + `, ` + mysqlPlaceholders(&args, root) + `, 0`
+	}
+
+	stmt += `
+)
+SELECT c.actor_id, c.action, c.target_id, c.allowed, c.role, c.` + "`condition`" + `, c.level, c.path, tl.start_id, tl.level
+FROM (
+	SELECT a.actor_id, a.action, a.target_id, a.allowed, a.role, a.` + "`condition`" + `, h.level, h.path,
+		ROW_NUMBER() OVER (PARTITION BY a.actor_id, a.action, a.target_id, a.role ORDER BY h.level) AS n
+	FROM (
+		SELECT ` + mysqlPlaceholders(&args, actorId[0]) + ` AS id, 0 AS level, CONCAT(',', HEX(` + mysqlPlaceholders(&args, actorId[0]) + `), ',') AS path
+	UNION ALL
+		SELECT q.parent_id AS id, q.level, CONCAT(q.path, HEX(q.parent_id), ',')
+		FROM q
+	) h
+	JOIN ` + s.grants(&args, query.AsOf) + ` a ON a.actor_id = h.id
+	WHERE TRUE` + mysqlFilters(&args, "a", query.At, query.Actions, nil) + ` AND a.target_id IN (SELECT target_id FROM tl)
+) c
+JOIN tl ON tl.target_id = c.target_id
+WHERE c.n = 1
+ORDER BY c.level ASC, c.target_id DESC, c.allowed ASC`
+
+	candidates, levels, err := queryTargetCandidates(ctx, q, stmt, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range candidates {
+		candidates[i].ActorId = s.decode(candidates[i].ActorId)
+		candidates[i].TargetId = s.decode(candidates[i].TargetId)
+
+		/* The path is built from HEX() of the ids to work with both formats */
+		for j, id := range candidates[i].Path {
+			b, _ := hex.DecodeString(id)
+
+			candidates[i].Path[j] = s.decode(string(b))
+		}
+	}
+
+	ret := make(map[string]map[string]int, len(levels))
+
+	for startId, targets := range levels {
+		startId = s.decode(startId)
+		ret[startId] = make(map[string]int, len(targets))
+
+		for targetId, level := range targets {
+			ret[startId][s.decode(targetId)] = level
+		}
+	}
+
+	return candidates, ret, nil
+}
+
+func (s *MySQLStore) Inheritors(ctx context.Context, q Querier, query Query) ([]Inheritor, error) {
+	if (query.Actions != nil && len(query.Actions) == 0) || (query.TargetIds != nil && len(query.TargetIds) == 0) {
+		return nil, nil
+	}
+
+	var targetIds []interface{}
+
+	if query.TargetIds != nil {
+		var err error
+
+		targetIds, err = s.encode(query.TargetIds...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	/* Walking down from the actors of the grants, the path of an inheritor
+	   is prepended to the path of its parent. The driver only supports
+	   positional placeholders, the arguments are appended by the fragments in
+	   the order they appear in the query */
+	args := []interface{}{}
+	stmt := `WITH RECURSIVE g AS (
+	SELECT a.actor_id, a.action, a.target_id, a.allowed, a.role, a.` + "`condition`" + `
+	FROM ` + s.grants(&args, query.AsOf) + ` a
+	WHERE TRUE` + mysqlFilters(&args, "a", query.At, query.Actions, targetIds) + `
+), q (id, grant_actor_id, path, level) AS (
+	SELECT DISTINCT actor_id, actor_id, CAST(CONCAT(',', HEX(actor_id), ',') AS CHAR(10000)), 0
+	FROM g
+UNION ALL
+	SELECT t.id, q.grant_actor_id, CONCAT(',', HEX(t.id), q.path), q.level + 1
+	FROM q
+	JOIN ` + s.source(&args, s.treeTable, query.AsOf) + ` t ON t.parent_id = q.id
+	WHERE LOCATE(CONCAT(',', HEX(t.id), ','), q.path) = 0` + mysqlValid(&args, "t", query.At) + `
+)
+SELECT inheritor_id, leaf, actor_id, action, target_id, allowed, role, ` + "`condition`" + `, level, path
+FROM (
+	SELECT q.id AS inheritor_id, NOT EXISTS (SELECT 1 FROM ` + "`" + s.treeTable + "`" + ` c WHERE c.parent_id = q.id) AS leaf,
+		g.actor_id, g.action, g.target_id, g.allowed, g.role, g.` + "`condition`" + `, q.level, q.path,
+		ROW_NUMBER() OVER (PARTITION BY q.id, g.actor_id, g.action, g.target_id, g.role ORDER BY q.level) AS n
+	FROM q
+	JOIN g ON g.actor_id = q.grant_actor_id
+) c
+WHERE n = 1
+ORDER BY inheritor_id, level ASC, target_id DESC, allowed ASC`
+
+	inheritors, err := queryInheritors(ctx, q, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range inheritors {
+		inheritors[i].ActorId = s.decode(inheritors[i].ActorId)
+
+		for j := range inheritors[i].Candidates {
+			c := &inheritors[i].Candidates[j]
+			c.ActorId = s.decode(c.ActorId)
+			c.TargetId = s.decode(c.TargetId)
+
+			/* The path is built from HEX() of the ids to work with both formats */
+			for k, id := range c.Path {
+				b, _ := hex.DecodeString(id)
+
+				c.Path[k] = s.decode(string(b))
+			}
+		}
+	}
+
+	return inheritors, nil
+}
+
+func (s *MySQLStore) Grants(ctx context.Context, q Querier, query Query) ([]Grant, error) {
+	if (query.Actions != nil && len(query.Actions) == 0) || (query.TargetIds != nil && len(query.TargetIds) == 0) {
+		return nil, nil
+	}
+
+	var actorId, targetIds []interface{}
+	var err error
+
+	if query.ActorId != "" {
+		actorId, err = s.encode(query.ActorId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if query.TargetIds != nil {
+		targetIds, err = s.encode(query.TargetIds...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	args := []interface{}{}
+	stmt := "SELECT actor_id, action, target_id, allowed, role, `condition` FROM " + s.grants(&args, query.AsOf) + " a WHERE TRUE"
+
+	if actorId != nil {
+		stmt += " AND a.actor_id = " + mysqlPlaceholders(&args, actorId...)
+	}
+
+	stmt += mysqlFilters(&args, "a", query.At, query.Actions, targetIds) + " ORDER BY actor_id, action, target_id, role"
+
+	grants, err := queryGrants(ctx, q, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range grants {
+		grants[i].ActorId = s.decode(grants[i].ActorId)
+		grants[i].TargetId = s.decode(grants[i].TargetId)
+	}
+
+	return grants, nil
+}
+
+func (s *MySQLStore) SetRoleActions(ctx context.Context, q Querier, role string, actions []string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, "DELETE FROM `"+s.table+"_Roles` WHERE role = ?", role)
+		if err != nil || len(actions) == 0 {
+			return err
+		}
+
+		args := []interface{}{}
+
+		for _, action := range actions {
+			args = append(args, role, action)
+		}
+
+		_, err = q.ExecContext(ctx, "INSERT INTO `"+s.table+"_Roles` (role, action) VALUES "+strings.TrimSuffix(strings.Repeat("(?, ?), ", len(actions)), ", "), args...)
+
+		return err
+	})
+}
+
+func (s *MySQLStore) AssignRole(ctx context.Context, q Querier, actorId string, role string, targetId string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		ids, err := s.encode(actorId, targetId)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.ExecContext(ctx, "INSERT IGNORE INTO `"+s.table+"_RoleAssignments` (actor_id, role, target_id) VALUES (?, ?, ?)", ids[0], role, ids[1])
+
+		return err
+	})
+}
+
+func (s *MySQLStore) RevokeRole(ctx context.Context, q Querier, actorId string, role string, targetId string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		ids, err := s.encode(actorId, targetId)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.ExecContext(ctx, "DELETE FROM `"+s.table+"_RoleAssignments` WHERE actor_id = ? AND role = ? AND target_id = ?", ids[0], role, ids[1])
+
+		return err
+	})
+}
+
+func (s *MySQLStore) AuditLog(ctx context.Context, q Querier, query AuditQuery) ([]AuditEntry, error) {
+	for _, id := range []string{query.ActorId, query.TargetId} {
+		if id == "" {
+			continue
+		}
+
+		_, err := s.encode(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	where, args := auditWhere(query, func(n int) string { return "?" }, func(id string) interface{} {
+		ids, _ := s.encode(id)
+
+		return ids[0]
+	}, mysqlTime)
+	limit := ""
+
+	if query.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", query.Limit)
+	}
+
+	entries, err := queryAuditLog(ctx, q, "SELECT id, DATE_FORMAT(changed_at, '%Y-%m-%d %H:%i:%s.%f'), changed_by, kind, operation, actor_id, action, target_id, old_row, new_row FROM `"+s.table+"_Audit` WHERE "+where+" ORDER BY id"+limit, func(s string) (time.Time, error) {
+		return time.ParseInLocation("2006-01-02 15:04:05.000000", s, time.UTC)
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		entries[i].ActorId = s.decode(entries[i].ActorId)
+		entries[i].TargetId = s.decode(entries[i].TargetId)
+	}
+
+	return entries, nil
+}
+
+// audited runs f recording the actor of the context as the one making the
+// changes in the audit log, see WithChangedBy
+func (s *MySQLStore) audited(ctx context.Context, q Querier, f func(q Querier) error) error {
+	return withChangedBy(ctx, q, "SET @acl_changed_by = ?", f)
+}
+
+// grants returns a subquery selecting the rows of the ACL table along with the
+// grants of the assigned roles, as recorded at asOf if it is not zero, see
+// source
+func (s *MySQLStore) grants(args *[]interface{}, asOf time.Time) string {
+	return `(
+		SELECT actor_id, action, target_id, allowed, CAST('' AS CHAR(255)) AS role, valid_from, valid_until, ` + "`condition`" + `
+		FROM ` + s.source(args, s.table, asOf) + ` g
+	UNION ALL
+		SELECT r.actor_id, ra.action, r.target_id, TRUE, r.role, NULL, NULL, ''
+		FROM ` + s.source(args, s.table+"_RoleAssignments", asOf) + ` r
+		JOIN ` + s.source(args, s.table+"_Roles", asOf) + ` ra ON ra.role = r.role
+	)`
+}
+
+// source returns the table, or the rows recorded at asOf in its history table
+// if asOf is not zero. The driver only supports positional placeholders, the
+// time is appended to args once for each placeholder of the source
+func (s *MySQLStore) source(args *[]interface{}, table string, asOf time.Time) string {
+	if asOf.IsZero() {
+		return "`" + table + "`"
+	}
+
+	*args = append(*args, mysqlTime(asOf), mysqlTime(asOf))
+
+	return "(SELECT * FROM `" + table + "_History` WHERE " + historyWhere("?") + ")"
+}
+
+func (s *MySQLStore) SetTargetParent(ctx context.Context, q Querier, id string, parentId string) error {
+	if s.targetTreeTable == "" {
+		return errNoTargetTree
+	}
+
+	ids, err := s.encode(id, parentId)
+	if err != nil {
+		return err
+	}
+
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, "INSERT INTO `"+s.targetTreeTable+"` (id, parent_id) SELECT ?, ? FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM `"+s.targetTreeTable+"` WHERE id = ? AND parent_id = ?)", ids[0], ids[1], ids[0], ids[1])
+
+		return err
+	})
+}
+
+func (s *MySQLStore) RemoveTargetParent(ctx context.Context, q Querier, id string, parentId string) error {
+	if s.targetTreeTable == "" {
+		return errNoTargetTree
+	}
+
+	ids, err := s.encode(id, parentId)
+	if err != nil {
+		return err
+	}
+
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, "DELETE FROM `"+s.targetTreeTable+"` WHERE id = ? AND parent_id = ?", ids[0], ids[1])
+
+		return err
+	})
+}
+
+func (s *MySQLStore) TargetDescendants(ctx context.Context, q Querier, ids []string, page Page) (map[string]map[string]int, error) {
+	if s.targetTreeTable == "" || len(ids) == 0 {
+		return pageTargetLevels(targetLevels(ids), page), nil
+	}
+
+	roots, err := s.encode(ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	/* Starting from the children, as the type of a parameter would not fit
+	   the columns, the ids themselves are added on level 0 afterwards */
+	args := append([]interface{}{}, roots...)
+	list := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+	where := "TRUE"
+	limit := ""
+
+	for _, root := range roots {
+		args = append(args, root, root)
+	}
+
+	if page.After != "" {
+		after, err := s.encode(page.After)
+		if err != nil {
+			return nil, err
+		}
+
+		where = "id > ?"
+		args = append(args, after[0])
+	}
+
+	if page.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", page.Limit)
+	}
+
+	levels, err := queryTargetLevels(ctx, q, nil, `WITH RECURSIVE q (id, root_id, path, level) AS (
+	SELECT t.id, t.parent_id, CAST(CONCAT(',', HEX(t.parent_id), ',', HEX(t.id), ',') AS CHAR(10000)), 1
+	FROM `+"`"+s.targetTreeTable+"`"+` t
+	WHERE t.parent_id IN (`+list+`)
+UNION ALL
+	SELECT t.id, q.root_id, CONCAT(q.path, HEX(t.id), ','), q.level + 1
+	FROM q
+	JOIN `+"`"+s.targetTreeTable+"`"+` t ON t.parent_id = q.id
+	WHERE LOCATE(CONCAT(',', HEX(t.id), ','), q.path) = 0
+), d (id, root_id, level) AS (
+	SELECT id, root_id, level FROM q`+strings.Repeat(`
+UNION ALL
+	SELECT ?, ?, 0`, len(ids))+`
+), p AS (
+	SELECT DISTINCT id FROM d WHERE `+where+` ORDER BY id`+limit+`
+)
+SELECT d.id, d.root_id, MIN(d.level)
+FROM d
+JOIN p ON p.id = d.id
+GROUP BY d.id, d.root_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]map[string]int, len(levels))
+
+	for id, ancestors := range levels {
+		id = s.decode(id)
+		ret[id] = make(map[string]int, len(ancestors))
+
+		for rootId, level := range ancestors {
+			ret[id][s.decode(rootId)] = level
+		}
+	}
+
+	return ret, nil
+}
+
+func (s *MySQLStore) TargetLevels(ctx context.Context, q Querier, ids []string) (map[string]map[string]int, error) {
+	if s.targetTreeTable == "" || len(ids) == 0 {
+		return targetLevels(ids), nil
+	}
+
+	args, err := s.encode(ids...)
+	if err != nil {
+		return nil, err
+	}
+
+	levels, err := queryTargetLevels(ctx, q, nil, `WITH RECURSIVE q (start_id, parent_id, path, level) AS (
+	SELECT t.id, t.parent_id, CAST(CONCAT(',', HEX(t.id), ',') AS CHAR(10000)), 1
+	FROM `+"`"+s.targetTreeTable+"`"+` t
+	WHERE t.id IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")+`)
+UNION ALL
+	SELECT q.start_id, t.parent_id, CONCAT(q.path, HEX(t.id), ','), q.level + 1
+	FROM q
+	JOIN `+"`"+s.targetTreeTable+"`"+` t ON t.id = q.parent_id
+	WHERE LOCATE(CONCAT(',', HEX(t.id), ','), q.path) = 0
+)
+SELECT start_id, parent_id, MIN(level)
+FROM q
+GROUP BY start_id, parent_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := targetLevels(ids)
+
+	for startId, ancestors := range levels {
+		startId = s.decode(startId)
+
+		if ret[startId] == nil {
+			ret[startId] = map[string]int{startId: 0}
+		}
+
+		for ancestorId, level := range ancestors {
+			ret[startId][s.decode(ancestorId)] = level
+		}
+	}
+
+	return ret, nil
+}
+
+func (s *MySQLStore) queryIds(ctx context.Context, q Querier, query string, id string) ([]string, error) {
+	ids, err := s.encode(id)
+	if err != nil {
+		return []string{}, err
+	}
+
+	ret, err := queryIds(ctx, q, query, ids...)
+
+	for i := range ret {
+		ret[i] = s.decode(ret[i])
+	}
+
+	return ret, err
+}
+
+// mysqlTime returns the time in UTC, or nil for a zero time
+func mysqlTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t.UTC()
+}
+
+// mysqlPlaceholders appends the values to args and returns the positional
+// placeholders for them, the fragments of a query have to be built in the
+// order they appear in it
+func mysqlPlaceholders(args *[]interface{}, values ...interface{}) string {
+	*args = append(*args, values...)
+
+	return strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+}
+
+// mysqlValid returns the condition matching the rows of the alias which are
+// valid at the time, appending the time to args once for each of its two
+// placeholders, or an empty string for a zero time
+func mysqlValid(args *[]interface{}, alias string, at time.Time) string {
+	if at.IsZero() {
+		return ""
+	}
+
+	*args = append(*args, mysqlTime(at), mysqlTime(at))
+
+	return " AND " + validWhere(alias, "?")
+}
+
+// mysqlFilters returns the conditions on the validity, the actions and the
+// targets of the grants of the alias, appending their arguments to args. Nil
+// actions or targetIds match every action or target respectively
+func mysqlFilters(args *[]interface{}, alias string, at time.Time, actions []string, targetIds []interface{}) string {
+	ret := mysqlValid(args, alias, at)
+
+	if actions != nil {
+		values := make([]interface{}, len(actions))
+
+		for i, action := range actions {
+			values[i] = action
+		}
+
+		ret += " AND " + alias + ".action IN (" + mysqlPlaceholders(args, values...) + ")"
+	}
+
+	if targetIds != nil {
+		ret += " AND " + alias + ".target_id IN (" + mysqlPlaceholders(args, targetIds...) + ")"
+	}
+
+	return ret
+}
+
+// encode converts the ids to query parameters matching the column format
+func (s *MySQLStore) encode(ids ...string) ([]interface{}, error) {
+	ret := make([]interface{}, len(ids))
+
+	for i, id := range ids {
+		if s.uuids != MySQLUUIDBinary {
+			ret[i] = id
+
+			continue
+		}
+
+		b, err := hex.DecodeString(strings.Replace(id, "-", "", -1))
+		if err != nil || len(b) != 16 {
+			return nil, &Error{Kind: ErrInvalidResourceID, Err: fmt.Errorf("invalid UUID %q", id)}
+		}
+
+		ret[i] = b
+	}
+
+	return ret, nil
+}
+
+// decode converts an id read from a column to its textual representation
+func (s *MySQLStore) decode(id string) string {
+	if s.uuids != MySQLUUIDBinary || len(id) != 16 {
+		return id
+	}
+
+	h := hex.EncodeToString([]byte(id))
+
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
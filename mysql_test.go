@@ -0,0 +1,392 @@
+package acl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMySQL(t *testing.T) {
+	/* Eg. user:password@tcp(localhost:3306)/acl_test */
+	dsn := os.Getenv("MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("MYSQL_DSN is not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		panic(err)
+	}
+
+	defer db.Close()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+	userC := idAble{id: "7be24c16-6376-478d-91c9-f879116d1d49"}
+
+	testResourceA := idAble{id: "e74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+	testResourceB := idAble{id: "b5bd4f2e-4c2e-4e5a-9f0c-0d2a3f4e6b71"}
+
+	for _, format := range []MySQLUUIDFormat{MySQLUUIDChar, MySQLUUIDBinary} {
+		for _, table := range []string{"ACLTestActors", "ACLTest", "ACLTest_Roles", "ACLTest_RoleAssignments", "ACLTestTree", "ACLTestTargetTree"} {
+			_, err = db.Exec("DROP TABLE IF EXISTS `" + table + "`")
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		uuidType, _ := format.columnType()
+
+		_, err = db.Exec("CREATE TABLE `ACLTestActors` (id " + uuidType + " PRIMARY KEY)")
+		if err != nil {
+			panic(err)
+		}
+
+		acl := NewWithStore(NewMySQLStoreWithTargetTree("ACLTestTree", "ACLTestTargetTree", "ACLTest", format), nil)
+
+		Convey("EnsureMySQLTablesAndRulesExist() should create the tables using "+uuidType, t, func() {
+			err := EnsureMySQLTablesAndRulesExist(db, "ACLTestTree", "ACLTest", format, Cascades{Actors: []Link{{Table: "ACLTestActors", Key: "id"}}})
+			So(err, ShouldBeNil)
+
+			Convey("And should not raise an error when they already exist", func() {
+				err := EnsureMySQLTablesAndRulesExist(db, "ACLTestTree", "ACLTest", format, Cascades{Actors: []Link{{Table: "ACLTestActors", Key: "id"}}})
+				So(err, ShouldBeNil)
+			})
+
+			Convey("And EnsureMySQLTargetTreeExists() should create the target tree", func() {
+				So(EnsureMySQLTargetTreeExists(db, "ACLTestTargetTree", "ACLTest", format, nil), ShouldBeNil)
+			})
+		})
+
+		Convey("Edges should be set like in the other stores using "+uuidType, t, withMySQLTransaction(db, func(tx *sql.Tx) {
+			testTreeEdges(acl, tx, userA, userB)
+		}))
+
+		Convey("Candidates() should list the ancestors like in the other stores using "+uuidType, t, withMySQLTransaction(db, func(tx *sql.Tx) {
+			testCandidateAncestors(acl, tx, userA, userB, userC)
+		}))
+
+		Convey("Target tree edges should be recorded like in the other stores using "+uuidType, t, withMySQLTransaction(db, func(tx *sql.Tx) {
+			testTargetTreeAudit(acl, tx, testResourceA, testResourceB, userB)
+		}))
+
+		Convey("When A inherits from B and B inherits from C using "+uuidType, t, withMySQLTransaction(db, func(tx *sql.Tx) {
+			So(acl.SetActorInherits(tx, userA, userB), ShouldBeNil)
+			So(acl.SetActorInherits(tx, userB, userC), ShouldBeNil)
+
+			Convey("Setting an already set relation should not error", func() {
+				So(acl.SetActorInherits(tx, userA, userB), ShouldBeNil)
+
+				parents, err := acl.GetActorInherits(tx, userA)
+				So(err, ShouldBeNil)
+				So(parents, ShouldResemble, []string{userB.GetId()})
+			})
+
+			Convey("Ancestors() should list A along with B and C", func() {
+				ancestors, err := NewMySQLStore("ACLTestTree", "ACLTest", format).Ancestors(context.Background(), tx, userA.GetId())
+				So(err, ShouldBeNil)
+				So(len(ancestors), ShouldEqual, 3)
+				So(ancestors[0], ShouldEqual, userA.GetId())
+				So(ancestors, ShouldContain, userB.GetId())
+				So(ancestors, ShouldContain, userC.GetId())
+			})
+
+			Convey("TargetDescendants() should page through C and the targets in it", func() {
+				So(acl.SetTargetInherits(tx, testResourceA, userC), ShouldBeNil)
+
+				store := NewMySQLStoreWithTargetTree("ACLTestTree", "ACLTestTargetTree", "ACLTest", format)
+
+				levels, err := store.TargetDescendants(context.Background(), tx, []string{userC.GetId()}, Page{Limit: 1})
+				So(err, ShouldBeNil)
+				So(levels, ShouldResemble, map[string]map[string]int{userC.GetId(): {userC.GetId(): 0}})
+
+				levels, err = store.TargetDescendants(context.Background(), tx, []string{userC.GetId()}, Page{After: userC.GetId()})
+				So(err, ShouldBeNil)
+				So(levels, ShouldResemble, map[string]map[string]int{testResourceA.GetId(): {userC.GetId(): 1}})
+			})
+
+			Convey("Attempting to establish C -> A should return an error", func() {
+				So(acl.SetActorInherits(tx, userC, userA), ShouldNotBeNil)
+			})
+
+			Convey("SetActionAllowed(true) on C should allow A", func() {
+				So(acl.SetActionAllowed(tx, userC, "testing", true), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(tx, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+
+				Convey("And Inheritors() should list the candidates of A, B and C at once", func() {
+					inheritors, err := NewMySQLStore("ACLTestTree", "ACLTest", format).Inheritors(context.Background(), tx, Query{Actions: []string{"testing"}})
+					So(err, ShouldBeNil)
+					So(len(inheritors), ShouldEqual, 3)
+					So(inheritors[0].ActorId, ShouldEqual, userA.GetId())
+					So(inheritors[0].Leaf, ShouldEqual, true)
+					So(len(inheritors[0].Candidates), ShouldEqual, 1)
+					So(inheritors[0].Candidates[0].Level, ShouldEqual, 2)
+					So(inheritors[0].Candidates[0].Path, ShouldResemble, []string{userA.GetId(), userB.GetId(), userC.GetId()})
+					So(inheritors[1].ActorId, ShouldEqual, userB.GetId())
+					So(inheritors[1].Leaf, ShouldEqual, false)
+					So(inheritors[2].ActorId, ShouldEqual, userC.GetId())
+					So(inheritors[2].Candidates[0].Level, ShouldEqual, 0)
+
+					actors, err := acl.ListActors(context.Background(), tx, testResourceA, "testing", true)
+					So(err, ShouldBeNil)
+					So(actors, ShouldResemble, []string{userA.GetId()})
+				})
+
+				Convey("And SetActionAllowedOn(false) on B should disable A on resource", func() {
+					So(acl.SetActionAllowedOn(tx, userB, "testing", testResourceA, false), ShouldBeNil)
+
+					allowed, err := acl.AllowsActionOn(tx, userA, "testing", testResourceA)
+					So(err, ShouldBeNil)
+					So(allowed, ShouldEqual, false)
+				})
+
+				Convey("And SetActionAllowedOn(false) on B for a folder should disable A on a resource in it", func() {
+					So(acl.SetTargetInherits(tx, testResourceA, userC), ShouldBeNil)
+					So(acl.SetTargetInherits(tx, userC, testResourceA), ShouldNotBeNil)
+					So(acl.SetActionAllowedOn(tx, userB, "testing", userC, false), ShouldBeNil)
+
+					allowed, err := acl.AllowsActionOn(tx, userA, "testing", testResourceA)
+					So(err, ShouldBeNil)
+					So(allowed, ShouldEqual, false)
+				})
+
+				Convey("And SetActionAllowed(false) then SetActionAllowed(true) on A should allow A", func() {
+					So(acl.SetActionAllowed(tx, userA, "testing", false), ShouldBeNil)
+					So(acl.SetActionAllowed(tx, userA, "testing", true), ShouldBeNil)
+
+					allowed, err := acl.AllowsAction(tx, userA, "testing")
+					So(err, ShouldBeNil)
+					So(allowed, ShouldEqual, true)
+				})
+			})
+
+			Convey("AssignRole() on C should allow A the actions of the role", func() {
+				So(acl.SetRoleActions(tx, "editor", []string{"view", "edit"}), ShouldBeNil)
+				So(acl.AssignRole(tx, userC, "editor", testResourceA), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(tx, userA, "edit", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+
+				Convey("And a direct deny on C should take precedence over the role", func() {
+					So(acl.SetActionAllowedOn(tx, userC, "edit", testResourceA, false), ShouldBeNil)
+
+					allowed, err := acl.AllowsActionOn(tx, userA, "edit", testResourceA)
+					So(err, ShouldBeNil)
+					So(allowed, ShouldEqual, false)
+				})
+			})
+
+			Convey("SetActorInheritsUntil() in the past should stop A from inheriting from B", func() {
+				So(acl.SetActionAllowed(tx, userB, "testing", true), ShouldBeNil)
+				So(acl.SetActorInheritsUntil(tx, userA, userB, time.Now().Add(-time.Hour)), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(tx, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+
+				n, err := acl.RemoveExpired(context.Background(), tx)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, int64(1))
+			})
+
+			Convey("SetActionAllowedOnIf() on C should only allow A when the condition holds", func() {
+				So(acl.SetActionAllowedOnIf(tx, userC, "edit", testResourceA, true, `target.owner == actor.id`), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOnWith(context.Background(), tx, userA, "edit", testResourceA, Attributes{"target.owner": userA.GetId()})
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+
+				allowed, err = acl.AllowsActionOnWith(context.Background(), tx, userA, "edit", testResourceA, Attributes{"target.owner": userB.GetId()})
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("AllowsActionAt() should use the state recorded at the time", func() {
+				So(acl.SetActionAllowed(tx, userC, "testing", true), ShouldBeNil)
+
+				time.Sleep(time.Millisecond)
+				allowedAt := time.Now()
+				time.Sleep(time.Millisecond)
+
+				So(acl.RemoveActorInherits(tx, userB, userC), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionAt(tx, userA, "testing", allowedAt)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+
+				allowed, err = acl.AllowsActionAt(tx, userA, "testing", time.Now())
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("Changes should be recorded in the audit log", func() {
+				start := time.Now().Add(-time.Second)
+
+				So(acl.SetActionAllowedOnContext(WithChangedBy(context.Background(), userB), tx, userC, "testing", testResourceA, true), ShouldBeNil)
+				So(acl.UnsetActionAllowedOn(tx, userC, "testing", testResourceA), ShouldBeNil)
+
+				entries, err := acl.AuditLog(context.Background(), tx, AuditQuery{ActorId: userC.GetId(), TargetId: testResourceA.GetId(), From: start})
+				So(err, ShouldBeNil)
+				So(len(entries), ShouldEqual, 2)
+				So(entries[0].Operation, ShouldEqual, "INSERT")
+				So(entries[0].ChangedBy, ShouldEqual, userB.GetId())
+				So(entries[0].ActorId, ShouldEqual, userC.GetId())
+				So(entries[0].New["actor_id"], ShouldEqual, userC.GetId())
+				So(entries[0].New["allowed"], ShouldEqual, true)
+				So(entries[1].Operation, ShouldEqual, "DELETE")
+				So(entries[1].ChangedBy, ShouldEqual, "")
+
+				_, err = tx.Exec("DELETE FROM `ACLTest_Audit`")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("DELETE on an actor row should remove the corresponding relations", func() {
+				ids, err := acl.store.(*MySQLStore).encode(userB.GetId())
+				So(err, ShouldBeNil)
+
+				_, err = tx.Exec("INSERT INTO `ACLTestActors` (id) VALUES (?)", ids[0])
+				So(err, ShouldBeNil)
+
+				_, err = tx.Exec("DELETE FROM `ACLTestActors` WHERE id = ?", ids[0])
+				So(err, ShouldBeNil)
+
+				parents, err := acl.GetActorInherits(tx, userA)
+				So(err, ShouldBeNil)
+				So(len(parents), ShouldEqual, 0)
+			})
+		}))
+	}
+}
+
+func withMySQLTransaction(db *sql.DB, f func(tx *sql.Tx)) func() {
+	return func() {
+		tx, err := db.Begin()
+		So(err, ShouldBeNil)
+
+		/* Clear tables used in tests, TRUNCATE would commit the transaction */
+		_, err = tx.Exec("DELETE FROM `ACLTest`")
+		So(err, ShouldBeNil)
+		_, err = tx.Exec("DELETE FROM `ACLTestTree`")
+		So(err, ShouldBeNil)
+		_, err = tx.Exec("DELETE FROM `ACLTestTargetTree`")
+		So(err, ShouldBeNil)
+		_, err = tx.Exec("DELETE FROM `ACLTest_Roles`")
+		So(err, ShouldBeNil)
+		_, err = tx.Exec("DELETE FROM `ACLTest_RoleAssignments`")
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			So(tx.Rollback(), ShouldBeNil)
+		})
+
+		f(tx)
+	}
+}
+
+func TestMySQLUUIDEncoding(t *testing.T) {
+	Convey("With BINARY(16) ids", t, func() {
+		store := NewMySQLStore("ACLTestTree", "ACLTest", MySQLUUIDBinary)
+
+		Convey("A UUID should survive encoding and decoding", func() {
+			ids, err := store.encode("3eb9e0dc-72fa-4e8f-a188-dcca409220f9", EMPTY_RESOURCE)
+			So(err, ShouldBeNil)
+			So(len(ids[0].([]byte)), ShouldEqual, 16)
+			So(store.decode(string(ids[0].([]byte))), ShouldEqual, "3eb9e0dc-72fa-4e8f-a188-dcca409220f9")
+			So(store.decode(string(ids[1].([]byte))), ShouldEqual, EMPTY_RESOURCE)
+		})
+
+		Convey("An invalid UUID should return an error", func() {
+			_, err := store.encode("not-a-uuid")
+			So(errors.Is(err, ErrInvalidResourceID), ShouldEqual, true)
+		})
+	})
+
+	Convey("With CHAR(36) ids", t, func() {
+		store := NewMySQLStore("ACLTestTree", "ACLTest", MySQLUUIDChar)
+
+		Convey("Ids should be used as is", func() {
+			ids, err := store.encode("not-a-uuid")
+			So(err, ShouldBeNil)
+			So(ids[0], ShouldEqual, "not-a-uuid")
+			So(store.decode("not-a-uuid"), ShouldEqual, "not-a-uuid")
+		})
+	})
+}
+
+// recordingQuerier records the queries and their arguments without running
+// them
+type recordingQuerier struct {
+	queries []string
+	args    [][]interface{}
+}
+
+var errRecorded = errors.New("recorded")
+
+func (r *recordingQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, r.record(query, args)
+}
+
+func (r *recordingQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, r.record(query, args)
+}
+
+func (r *recordingQuerier) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	r.record(query, args)
+
+	return nil
+}
+
+func (r *recordingQuerier) record(query string, args []interface{}) error {
+	r.queries = append(r.queries, query)
+	r.args = append(r.args, args)
+
+	return errRecorded
+}
+
+func TestMySQLPlaceholders(t *testing.T) {
+	Convey("Every placeholder of the MySQL queries should have an argument", t, func() {
+		ctx := context.Background()
+		store := NewMySQLStoreWithTargetTree("ACLTestTree", "ACLTestTargetTree", "ACLTest", MySQLUUIDChar)
+		at := time.Now()
+		asOf := at.Add(-time.Hour)
+		query := Query{ActorId: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9", Actions: []string{"read", "write"}, TargetIds: []string{"e74dc49c-e663-4144-9383-1a09c6c7ddfd"}, At: at, AsOf: asOf}
+		q := &recordingQuerier{}
+
+		_, err := store.Candidates(ctx, q, query)
+		So(err, ShouldEqual, errRecorded)
+		_, _, err = store.TargetCandidates(ctx, q, query)
+		So(err, ShouldEqual, errRecorded)
+		_, err = store.Inheritors(ctx, q, query)
+		So(err, ShouldEqual, errRecorded)
+		_, err = store.Grants(ctx, q, query)
+		So(err, ShouldEqual, errRecorded)
+
+		query.Ancestors = true
+		_, err = store.Candidates(ctx, q, query)
+		So(err, ShouldEqual, errRecorded)
+
+		So(len(q.queries), ShouldEqual, 5)
+
+		for i, query := range q.queries {
+			So(len(q.args[i]), ShouldEqual, strings.Count(query, "?"))
+		}
+
+		Convey("And the past time should be bound instead of inlined", func() {
+			for i, query := range q.queries {
+				So(strings.Contains(query, asOf.UTC().Format("2006-01-02")), ShouldBeFalse)
+				So(q.args[i], ShouldContain, asOf.UTC())
+			}
+		})
+	})
+}
@@ -0,0 +1,690 @@
+package acl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PostgresStore is a Store using the tables created by EnsureTablesAndRulesExist
+// and optionally EnsureTargetTreeExists
+type PostgresStore struct {
+	table           string
+	treeTable       string
+	targetTreeTable string
+}
+
+// NewPostgresStore creates a new Store using the given PostgreSQL tables,
+// without any target tree
+func NewPostgresStore(treeTable string, table string) *PostgresStore {
+	return &PostgresStore{treeTable: treeTable, table: table}
+}
+
+// NewPostgresStoreWithTargetTree creates a new Store using the given PostgreSQL
+// tables, including a target tree
+func NewPostgresStoreWithTargetTree(treeTable string, targetTreeTable string, table string) *PostgresStore {
+	return &PostgresStore{treeTable: treeTable, targetTreeTable: targetTreeTable, table: table}
+}
+
+// SetGrant inserts the grant, the _INSERT rule turns it into an update if it
+// exists which keeps the window and the condition of a grant without them
+func (s *PostgresStore) SetGrant(ctx context.Context, q Querier, grant Grant) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, "INSERT INTO \""+s.table+"\" (actor_id, action, target_id, allowed, valid_from, valid_until, condition) VALUES($1, $2, $3, $4, $5, $6, $7)", grant.ActorId, grant.Action, grant.TargetId, grant.Allowed, nullTime(grant.ValidFrom), nullTime(grant.ValidUntil), grant.Condition)
+
+		return err
+	})
+}
+
+func (s *PostgresStore) UnsetGrant(ctx context.Context, q Querier, actorId string, action string, targetId string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, "DELETE FROM \""+s.table+"\" WHERE actor_id = $1 AND action = $2 AND target_id = $3", actorId, action, targetId)
+
+		return err
+	})
+}
+
+func (s *PostgresStore) SetParent(ctx context.Context, q Querier, id string, parentId string, validFrom time.Time, validUntil time.Time) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		conflict := `NOTHING`
+
+		if !validFrom.IsZero() || !validUntil.IsZero() {
+			conflict = `UPDATE SET "valid_from" = excluded."valid_from", "valid_until" = excluded."valid_until"`
+		}
+
+		_, err := q.ExecContext(ctx, `INSERT INTO "`+s.treeTable+`" ("id", "parent_id", "valid_from", "valid_until") VALUES ($1, $2, $3, $4)
+		ON CONFLICT ("id", "parent_id") DO `+conflict, id, parentId, nullTime(validFrom), nullTime(validUntil))
+
+		return err
+	})
+}
+
+func (s *PostgresStore) RemoveParent(ctx context.Context, q Querier, id string, parentId string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `DELETE FROM "`+s.treeTable+`" WHERE ("id", "parent_id") = ($1, $2)`, id, parentId)
+
+		return err
+	})
+}
+
+func (s *PostgresStore) Parents(ctx context.Context, q Querier, id string) ([]string, error) {
+	return queryIds(ctx, q, `SELECT "parent_id" FROM "`+s.treeTable+`" WHERE "id" = $1 ORDER BY "id"`, id)
+}
+
+func (s *PostgresStore) Children(ctx context.Context, q Querier, id string) ([]string, error) {
+	return queryIds(ctx, q, `SELECT "id" FROM "`+s.treeTable+`" WHERE "parent_id" = $1 ORDER BY "id"`, id)
+}
+
+func (s *PostgresStore) Ancestors(ctx context.Context, q Querier, id string) ([]string, error) {
+	return queryIds(ctx, q, `WITH RECURSIVE q AS (
+	SELECT $1::uuid "id"
+UNION
+	SELECT t."parent_id"
+	FROM q
+	JOIN "`+s.treeTable+`" t ON t."id" = q."id"
+)
+SELECT "id" FROM q`, id)
+}
+
+func (s *PostgresStore) RemoveExpired(ctx context.Context, q Querier, at time.Time) (int64, error) {
+	var n int64
+
+	err := s.audited(ctx, q, func(q Querier) error {
+		var err error
+
+		n, err = execCount(ctx, q, []string{
+			`DELETE FROM "` + s.table + `" WHERE "valid_until" <= $1`,
+			`DELETE FROM "` + s.treeTable + `" WHERE "valid_until" <= $1`,
+		}, at)
+
+		return err
+	})
+
+	return n, err
+}
+
+func (s *PostgresStore) Candidates(ctx context.Context, q Querier, query Query) ([]Candidate, error) {
+	if (query.Actions != nil && len(query.Actions) == 0) || (query.TargetIds != nil && len(query.TargetIds) == 0) {
+		return nil, nil
+	}
+
+	args := []interface{}{query.ActorId}
+	where := "TRUE"
+	treeWhere := ""
+
+	if !query.At.IsZero() {
+		args = append(args, query.At)
+		where += " AND " + validWhere("a", fmt.Sprintf("$%d", len(args)))
+		treeWhere = " AND " + validWhere("t", fmt.Sprintf("$%d", len(args)))
+	}
+
+	if query.Actions != nil {
+		where += ` AND a."action" IN (` + placeholders("$", &args, query.Actions) + `)`
+	}
+
+	if query.TargetIds != nil {
+		where += ` AND a."target_id" IN (` + placeholders("$", &args, query.TargetIds) + `)`
+	}
+
+	asOf := s.asOf(&args, query.AsOf)
+	ancestors := ""
+
+	/* The ancestors are the rows of h without any grant columns */
+	if query.Ancestors {
+		ancestors = `
+UNION ALL
+	SELECT $1, '', $1, FALSE, '', '', 0, $1::text
+UNION ALL
+	SELECT q."parent_id", '', q."parent_id", FALSE, '', '', q."level", array_to_string(q."path" || q."parent_id", ',')
+	FROM q`
+	}
+
+	return queryCandidates(ctx, q, `WITH RECURSIVE q AS (
+	SELECT t."parent_id", ARRAY[t."id"] "path", 1 "level"
+	FROM `+s.source(s.treeTable, asOf)+` t
+	WHERE t."id" = $1`+treeWhere+`
+UNION ALL
+	SELECT t."parent_id", q."path" || t."id", q."level" + 1
+	FROM q
+	JOIN `+s.source(s.treeTable, asOf)+` t ON t."id" = q."parent_id"
+	WHERE NOT t."id" = ANY(q."path")`+treeWhere+`
+)
+SELECT "actor_id", "action", "target_id", "allowed", "role", "condition", "level", "path"
+FROM (
+	SELECT DISTINCT ON (a."actor_id", a."action", a."target_id", a."role") a."actor_id", a."action", a."target_id", a."allowed", a."role", a."condition", h."level", array_to_string(h."path", ',') "path"
+	FROM (
+		SELECT $1 AS "id", 0 "level", ARRAY[$1] "path"
+	UNION ALL
+		SELECT q."parent_id" AS "id", q."level", q."path" || q."parent_id"
+		FROM q
+	) h
+	JOIN `+s.grants(asOf)+` a ON a."actor_id" = h.id
+	WHERE `+where+`
+	ORDER BY a."actor_id", a."action", a."target_id", a."role", h."level"
+) c`+ancestors+`
+ORDER BY "level" ASC, "target_id" DESC, "allowed" ASC`, args...)
+}
+
+func (s *PostgresStore) TargetCandidates(ctx context.Context, q Querier, query Query) ([]Candidate, map[string]map[string]int, error) {
+	if query.Actions != nil && len(query.Actions) == 0 {
+		return nil, nil, nil
+	}
+
+	args := []interface{}{query.ActorId}
+	where := "TRUE"
+	treeWhere := ""
+
+	if !query.At.IsZero() {
+		args = append(args, query.At)
+		where += " AND " + validWhere("a", "$2")
+		treeWhere = " AND " + validWhere("t", "$2")
+	}
+
+	if query.Actions != nil {
+		where += ` AND a."action" IN (` + placeholders("$", &args, query.Actions) + `)`
+	}
+
+	roots := placeholders("$", &args, append([]string{EMPTY_RESOURCE}, query.TargetIds...))
+	targets := ""
+
+	/* The levels of the targets are the checked targets themselves on level
+	   0 and their ancestors if there is a target tree */
+	if s.targetTreeTable != "" {
+		targets = `, tq AS (
+	SELECT "id" "start_id", "parent_id", ARRAY["id"] "path", 1 "level"
+	FROM "` + s.targetTreeTable + `"
+	WHERE "id" IN (` + roots + `)
+UNION ALL
+	SELECT tq."start_id", t."parent_id", tq."path" || t."id", tq."level" + 1
+	FROM tq
+	JOIN "` + s.targetTreeTable + `" t ON t."id" = tq."parent_id"
+	WHERE NOT t."id" = ANY(tq."path")
+), tl AS (
+	SELECT r "start_id", r "target_id", 0 "level"
+	FROM unnest(ARRAY[` + roots + `]::uuid[]) r
+UNION ALL
+	SELECT "start_id", "parent_id", MIN("level")
+	FROM tq
+	GROUP BY "start_id", "parent_id"
+)`
+	} else {
+		targets = `, tl AS (
+	SELECT r "start_id", r "target_id", 0 "level"
+	FROM unnest(ARRAY[` + roots + `]::uuid[]) r
+)`
+	}
+
+	asOf := s.asOf(&args, query.AsOf)
+
+	return queryTargetCandidates(ctx, q, `WITH RECURSIVE q AS (
+	SELECT t."parent_id", ARRAY[t."id"] "path", 1 "level"
+	FROM `+s.source(s.treeTable, asOf)+` t
+	WHERE t."id" = $1`+treeWhere+`
+UNION ALL
+	SELECT t."parent_id", q."path" || t."id", q."level" + 1
+	FROM q
+	JOIN `+s.source(s.treeTable, asOf)+` t ON t."id" = q."parent_id"
+	WHERE NOT t."id" = ANY(q."path")`+treeWhere+`
+)`+targets+`
+SELECT c."actor_id", c."action", c."target_id", c."allowed", c."role", c."condition", c."level", c."path", tl."start_id", tl."level"
+FROM (
+	SELECT DISTINCT ON (a."actor_id", a."action", a."target_id", a."role") a."actor_id", a."action", a."target_id", a."allowed", a."role", a."condition", h."level", array_to_string(h."path", ',') "path"
+	FROM (
+		SELECT $1 AS "id", 0 "level", ARRAY[$1] "path"
+	UNION ALL
+		SELECT q."parent_id" AS "id", q."level", q."path" || q."parent_id"
+		FROM q
+	) h
+	JOIN `+s.grants(asOf)+` a ON a."actor_id" = h.id
+	WHERE `+where+` AND a."target_id" IN (SELECT "target_id" FROM tl)
+	ORDER BY a."actor_id", a."action", a."target_id", a."role", h."level"
+) c
+JOIN tl ON tl."target_id" = c."target_id"
+ORDER BY c."level" ASC, c."target_id" DESC, c."allowed" ASC`, args...)
+}
+
+func (s *PostgresStore) Inheritors(ctx context.Context, q Querier, query Query) ([]Inheritor, error) {
+	if (query.Actions != nil && len(query.Actions) == 0) || (query.TargetIds != nil && len(query.TargetIds) == 0) {
+		return nil, nil
+	}
+
+	args := []interface{}{}
+	where := "TRUE"
+	treeWhere := ""
+
+	if !query.At.IsZero() {
+		args = append(args, query.At)
+		where += " AND " + validWhere("a", "$1")
+		treeWhere = " AND " + validWhere("t", "$1")
+	}
+
+	if query.Actions != nil {
+		where += ` AND a."action" IN (` + placeholders("$", &args, query.Actions) + `)`
+	}
+
+	if query.TargetIds != nil {
+		where += ` AND a."target_id" IN (` + placeholders("$", &args, query.TargetIds) + `)`
+	}
+
+	asOf := s.asOf(&args, query.AsOf)
+
+	/* Walking down from the actors of the grants, the path of an inheritor
+	   is prepended to the path of its parent */
+	return queryInheritors(ctx, q, `WITH RECURSIVE g AS (
+	SELECT a."actor_id", a."action", a."target_id", a."allowed", a."role", a."condition"
+	FROM `+s.grants(asOf)+` a
+	WHERE `+where+`
+), q AS (
+	SELECT DISTINCT "actor_id" "id", "actor_id" "grant_actor_id", ARRAY["actor_id"] "path", 0 "level"
+	FROM g
+UNION ALL
+	SELECT t."id", q."grant_actor_id", t."id" || q."path", q."level" + 1
+	FROM q
+	JOIN `+s.source(s.treeTable, asOf)+` t ON t."parent_id" = q."id"
+	WHERE NOT t."id" = ANY(q."path")`+treeWhere+`
+)
+SELECT "inheritor_id", "leaf", "actor_id", "action", "target_id", "allowed", "role", "condition", "level", "path"
+FROM (
+	SELECT DISTINCT ON (q."id", g."actor_id", g."action", g."target_id", g."role") q."id" "inheritor_id", NOT EXISTS (SELECT 1 FROM "`+s.treeTable+`" c WHERE c."parent_id" = q."id") "leaf", g."actor_id", g."action", g."target_id", g."allowed", g."role", g."condition", q."level", array_to_string(q."path", ',') "path"
+	FROM q
+	JOIN g ON g."actor_id" = q."grant_actor_id"
+	ORDER BY q."id", g."actor_id", g."action", g."target_id", g."role", q."level"
+) c
+ORDER BY "inheritor_id", "level" ASC, "target_id" DESC, "allowed" ASC`, args...)
+}
+
+func (s *PostgresStore) Grants(ctx context.Context, q Querier, query Query) ([]Grant, error) {
+	if (query.Actions != nil && len(query.Actions) == 0) || (query.TargetIds != nil && len(query.TargetIds) == 0) {
+		return nil, nil
+	}
+
+	args := []interface{}{}
+	where := "TRUE"
+
+	if query.ActorId != "" {
+		where += ` AND "actor_id" = ` + placeholders("$", &args, []string{query.ActorId})
+	}
+
+	if !query.At.IsZero() {
+		args = append(args, query.At)
+		where += " AND " + validWhere("a", fmt.Sprintf("$%d", len(args)))
+	}
+
+	if query.Actions != nil {
+		where += ` AND "action" IN (` + placeholders("$", &args, query.Actions) + `)`
+	}
+
+	if query.TargetIds != nil {
+		where += ` AND "target_id" IN (` + placeholders("$", &args, query.TargetIds) + `)`
+	}
+
+	asOf := s.asOf(&args, query.AsOf)
+
+	return queryGrants(ctx, q, `SELECT "actor_id", "action", "target_id", "allowed", "role", "condition" FROM `+s.grants(asOf)+` a WHERE `+where+` ORDER BY "actor_id", "action", "target_id", "role"`, args...)
+}
+
+func (s *PostgresStore) SetRoleActions(ctx context.Context, q Querier, role string, actions []string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `DELETE FROM "`+s.table+`_Roles" WHERE "role" = $1`, role)
+		if err != nil || len(actions) == 0 {
+			return err
+		}
+
+		args := []interface{}{role}
+		values := make([]string, len(actions))
+
+		for i, action := range actions {
+			values[i] = `($1, ` + placeholders("$", &args, []string{action}) + `)`
+		}
+
+		_, err = q.ExecContext(ctx, `INSERT INTO "`+s.table+`_Roles" ("role", "action") VALUES `+strings.Join(values, ", "), args...)
+
+		return err
+	})
+}
+
+func (s *PostgresStore) AssignRole(ctx context.Context, q Querier, actorId string, role string, targetId string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `INSERT INTO "`+s.table+`_RoleAssignments" ("actor_id", "role", "target_id") SELECT $1, $2, $3 WHERE NOT EXISTS (SELECT 1 FROM "`+s.table+`_RoleAssignments" WHERE "actor_id" = $4 AND "role" = $5 AND "target_id" = $6)`, actorId, role, targetId, actorId, role, targetId)
+
+		return err
+	})
+}
+
+func (s *PostgresStore) RevokeRole(ctx context.Context, q Querier, actorId string, role string, targetId string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `DELETE FROM "`+s.table+`_RoleAssignments" WHERE "actor_id" = $1 AND "role" = $2 AND "target_id" = $3`, actorId, role, targetId)
+
+		return err
+	})
+}
+
+func (s *PostgresStore) AuditLog(ctx context.Context, q Querier, query AuditQuery) ([]AuditEntry, error) {
+	where, args := auditWhere(query, func(n int) string { return fmt.Sprintf("$%d", n) }, func(id string) interface{} { return id }, func(t time.Time) interface{} { return t })
+	limit := ""
+
+	if query.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", query.Limit)
+	}
+
+	return queryAuditLog(ctx, q, `SELECT "id", "changed_at", "changed_by", "kind", "operation", "actor_id", "action", "target_id", "old_row", "new_row" FROM "`+s.table+`_Audit" WHERE `+where+` ORDER BY "id"`+limit, func(s string) (time.Time, error) {
+		return time.Parse(time.RFC3339Nano, s)
+	}, args...)
+}
+
+// audited runs f recording the actor of the context as the one making the
+// changes in the audit log, see WithChangedBy
+func (s *PostgresStore) audited(ctx context.Context, q Querier, f func(q Querier) error) error {
+	return withChangedBy(ctx, q, `SELECT set_config('acl.changed_by', $1, true)`, f)
+}
+
+// grants returns a subquery selecting the rows of the ACL table along with the
+// grants of the assigned roles, as recorded at the placeholder asOf if it is
+// not empty
+func (s *PostgresStore) grants(asOf string) string {
+	return `(
+		SELECT "actor_id", "action", "target_id", "allowed", '' "role", "valid_from", "valid_until", "condition"
+		FROM ` + s.source(s.table, asOf) + ` g
+	UNION ALL
+		SELECT r."actor_id", ra."action", r."target_id", TRUE, r."role", NULL, NULL, ''
+		FROM ` + s.source(s.table+"_RoleAssignments", asOf) + ` r
+		JOIN ` + s.source(s.table+"_Roles", asOf) + ` ra ON ra."role" = r."role"
+	)`
+}
+
+// source returns the table, or the rows recorded at the time in the
+// placeholder asOf in its history table if asOf is not empty, see asOf
+func (s *PostgresStore) source(table string, asOf string) string {
+	if asOf == "" {
+		return `"` + table + `"`
+	}
+
+	return `(SELECT * FROM "` + table + `_History" WHERE ` + historyWhere(asOf) + `)`
+}
+
+// asOf appends the time of a query to args, returning its placeholder for
+// source, or an empty string for a zero time selecting the current rows
+func (s *PostgresStore) asOf(args *[]interface{}, asOf time.Time) string {
+	if asOf.IsZero() {
+		return ""
+	}
+
+	*args = append(*args, asOf)
+
+	return fmt.Sprintf("$%d::timestamp with time zone", len(*args))
+}
+
+func (s *PostgresStore) SetTargetParent(ctx context.Context, q Querier, id string, parentId string) error {
+	if s.targetTreeTable == "" {
+		return errNoTargetTree
+	}
+
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `INSERT INTO "`+s.targetTreeTable+`" ("id", "parent_id") SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM "`+s.targetTreeTable+`" WHERE "id" = $3 AND "parent_id" = $4)`, id, parentId, id, parentId)
+
+		return err
+	})
+}
+
+func (s *PostgresStore) RemoveTargetParent(ctx context.Context, q Querier, id string, parentId string) error {
+	if s.targetTreeTable == "" {
+		return errNoTargetTree
+	}
+
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `DELETE FROM "`+s.targetTreeTable+`" WHERE ("id", "parent_id") = ($1, $2)`, id, parentId)
+
+		return err
+	})
+}
+
+func (s *PostgresStore) TargetDescendants(ctx context.Context, q Querier, ids []string, page Page) (map[string]map[string]int, error) {
+	if s.targetTreeTable == "" || len(ids) == 0 {
+		return pageTargetLevels(targetLevels(ids), page), nil
+	}
+
+	args := []interface{}{}
+	roots := placeholders("$", &args, ids)
+	where := "TRUE"
+	limit := ""
+
+	if page.After != "" {
+		args = append(args, page.After)
+		where = fmt.Sprintf(`"id" > $%d`, len(args))
+	}
+
+	if page.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", page.Limit)
+	}
+
+	return queryTargetLevels(ctx, q, nil, `WITH RECURSIVE q AS (
+	SELECT r "id", r "root_id", ARRAY[r] "path", 0 "level"
+	FROM unnest(ARRAY[`+roots+`]::uuid[]) r
+UNION ALL
+	SELECT t."id", q."root_id", q."path" || t."id", q."level" + 1
+	FROM q
+	JOIN "`+s.targetTreeTable+`" t ON t."parent_id" = q."id"
+	WHERE NOT t."id" = ANY(q."path")
+), p AS (
+	SELECT DISTINCT "id" FROM q WHERE `+where+` ORDER BY "id"`+limit+`
+)
+SELECT q."id", q."root_id", MIN(q."level")
+FROM q
+JOIN p ON p."id" = q."id"
+GROUP BY q."id", q."root_id"`, args...)
+}
+
+func (s *PostgresStore) TargetLevels(ctx context.Context, q Querier, ids []string) (map[string]map[string]int, error) {
+	if s.targetTreeTable == "" || len(ids) == 0 {
+		return targetLevels(ids), nil
+	}
+
+	args := []interface{}{}
+
+	return queryTargetLevels(ctx, q, ids, `WITH RECURSIVE q AS (
+	SELECT "id" "start_id", "parent_id", ARRAY["id"] "path", 1 "level"
+	FROM "`+s.targetTreeTable+`"
+	WHERE "id" IN (`+placeholders("$", &args, ids)+`)
+UNION ALL
+	SELECT q."start_id", t."parent_id", q."path" || t."id", q."level" + 1
+	FROM q
+	JOIN "`+s.targetTreeTable+`" t ON t."id" = q."parent_id"
+	WHERE NOT t."id" = ANY(q."path")
+)
+SELECT "start_id", "parent_id", MIN("level")
+FROM q
+GROUP BY "start_id", "parent_id"`, args...)
+}
+
+// placeholders appends the values to args and returns the list of numbered
+// placeholders for them, prefix is $ for PostgreSQL and ? for SQLite
+func placeholders(prefix string, args *[]interface{}, values []string) string {
+	list := make([]string, len(values))
+
+	for i, v := range values {
+		*args = append(*args, v)
+		list[i] = fmt.Sprintf("%s%d", prefix, len(*args))
+	}
+
+	return strings.Join(list, ", ")
+}
+
+// execCount runs the statements with the same arguments, returning the total
+// number of affected rows
+func execCount(ctx context.Context, q Querier, queries []string, args ...interface{}) (int64, error) {
+	var n int64
+
+	for _, query := range queries {
+		res, err := q.ExecContext(ctx, query, args...)
+		if err != nil {
+			return n, err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return n, err
+		}
+
+		n += affected
+	}
+
+	return n, nil
+}
+
+// queryIds runs a query selecting a single id column
+func queryIds(ctx context.Context, q Querier, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return []string{}, err
+	}
+
+	var ret []string
+
+	for rows.Next() {
+		str := ""
+
+		rows.Scan(&str)
+
+		ret = append(ret, str)
+	}
+
+	rows.Close()
+	return ret, rows.Err()
+}
+
+// queryCandidates runs a query selecting actor_id, action, target_id, allowed,
+// role, condition, level and path, path being a comma-separated list of ids
+func queryCandidates(ctx context.Context, q Querier, query string, args ...interface{}) ([]Candidate, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var ret []Candidate
+
+	for rows.Next() {
+		c := Candidate{}
+		path := ""
+
+		if err := rows.Scan(&c.ActorId, &c.Action, &c.TargetId, &c.Allowed, &c.Role, &c.Condition, &c.Level, &path); err != nil {
+			return nil, err
+		}
+
+		c.Path = strings.Split(strings.Trim(path, ","), ",")
+
+		/* An ancestor listed for Query.Ancestors, the other columns are placeholders */
+		if c.Action == "" {
+			c.Grant = Grant{ActorId: c.ActorId}
+		}
+
+		ret = append(ret, c)
+	}
+
+	return ret, rows.Err()
+}
+
+// queryTargetCandidates runs a query selecting the columns of queryCandidates
+// followed by a checked target and the level of the target of the candidate
+// from it, returning every candidate once along with the levels
+func queryTargetCandidates(ctx context.Context, q Querier, query string, args ...interface{}) ([]Candidate, map[string]map[string]int, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer rows.Close()
+
+	type key struct{ actorId, action, targetId, role string }
+
+	var ret []Candidate
+	seen := make(map[key]struct{})
+	levels := make(map[string]map[string]int)
+
+	for rows.Next() {
+		c := Candidate{}
+		path, startId, level := "", "", 0
+
+		if err := rows.Scan(&c.ActorId, &c.Action, &c.TargetId, &c.Allowed, &c.Role, &c.Condition, &c.Level, &path, &startId, &level); err != nil {
+			return nil, nil, err
+		}
+
+		if levels[startId] == nil {
+			levels[startId] = make(map[string]int)
+		}
+
+		levels[startId][c.TargetId] = level
+
+		/* A candidate is selected once for every checked target it applies to */
+		if _, ok := seen[key{c.ActorId, c.Action, c.TargetId, c.Role}]; ok {
+			continue
+		}
+
+		seen[key{c.ActorId, c.Action, c.TargetId, c.Role}] = struct{}{}
+		c.Path = strings.Split(strings.Trim(path, ","), ",")
+
+		ret = append(ret, c)
+	}
+
+	return ret, levels, rows.Err()
+}
+
+// queryInheritors runs a query selecting the id of the inheritor, if it is a
+// leaf and the columns of queryCandidates, ordered by the inheritor
+func queryInheritors(ctx context.Context, q Querier, query string, args ...interface{}) ([]Inheritor, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var ret []Inheritor
+
+	for rows.Next() {
+		i := Inheritor{}
+		c := Candidate{}
+		path := ""
+
+		if err := rows.Scan(&i.ActorId, &i.Leaf, &c.ActorId, &c.Action, &c.TargetId, &c.Allowed, &c.Role, &c.Condition, &c.Level, &path); err != nil {
+			return nil, err
+		}
+
+		c.Path = strings.Split(strings.Trim(path, ","), ",")
+
+		if len(ret) == 0 || ret[len(ret)-1].ActorId != i.ActorId {
+			ret = append(ret, i)
+		}
+
+		ret[len(ret)-1].Candidates = append(ret[len(ret)-1].Candidates, c)
+	}
+
+	return ret, rows.Err()
+}
+
+// queryGrants runs a query selecting actor_id, action, target_id, allowed,
+// role and condition
+func queryGrants(ctx context.Context, q Querier, query string, args ...interface{}) ([]Grant, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var ret []Grant
+
+	for rows.Next() {
+		g := Grant{}
+
+		if err := rows.Scan(&g.ActorId, &g.Action, &g.TargetId, &g.Allowed, &g.Role, &g.Condition); err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, g)
+	}
+
+	return ret, rows.Err()
+}
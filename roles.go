@@ -0,0 +1,47 @@
+package acl
+
+import (
+	"context"
+)
+
+// SetRoleActions replaces the set of actions allowed by role, affecting every
+// actor the role is assigned to
+func (acl *ACL) SetRoleActions(q Querier, role string, actions []string) error {
+	return acl.SetRoleActionsContext(context.Background(), q, role, actions)
+}
+
+// SetRoleActionsContext is like SetRoleActions but uses the supplied context
+func (acl *ACL) SetRoleActionsContext(ctx context.Context, q Querier, role string, actions []string) error {
+	return acl.store.SetRoleActions(ctx, q, role, actions)
+}
+
+// AssignRole allows actor to perform the actions of role on target, a nil
+// target or NilResource assigns the role on any target. Rows in the ACL table
+// take precedence over roles on the same level in the tree
+func (acl *ACL) AssignRole(q Querier, actor Resource, role string, target Resource) error {
+	return acl.AssignRoleContext(context.Background(), q, actor, role, target)
+}
+
+// AssignRoleContext is like AssignRole but uses the supplied context
+func (acl *ACL) AssignRoleContext(ctx context.Context, q Querier, actor Resource, role string, target Resource) error {
+	return acl.store.AssignRole(ctx, q, actor.GetId(), role, roleTargetId(target))
+}
+
+// RevokeRole removes the assignment of role to actor on target, if any
+func (acl *ACL) RevokeRole(q Querier, actor Resource, role string, target Resource) error {
+	return acl.RevokeRoleContext(context.Background(), q, actor, role, target)
+}
+
+// RevokeRoleContext is like RevokeRole but uses the supplied context
+func (acl *ACL) RevokeRoleContext(ctx context.Context, q Querier, actor Resource, role string, target Resource) error {
+	return acl.store.RevokeRole(ctx, q, actor.GetId(), role, roleTargetId(target))
+}
+
+// roleTargetId returns the id of the target of a role assignment
+func roleTargetId(target Resource) string {
+	if target == nil || target.GetId() == "" {
+		return EMPTY_RESOURCE
+	}
+
+	return target.GetId()
+}
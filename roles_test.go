@@ -0,0 +1,101 @@
+package acl
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRoles(t *testing.T) {
+	ctx := context.Background()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+	testResourceB := idAble{id: "b74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	Convey("When editor allows view, comment and edit", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetRoleActions(nil, "editor", []string{"view", "comment", "edit"}), ShouldBeNil)
+
+		Convey("AssignRole() on a target should allow the actions on that target only", func() {
+			So(acl.AssignRole(nil, userA, "editor", testResourceA), ShouldBeNil)
+
+			for _, action := range []string{"view", "comment", "edit"} {
+				allowed, err := acl.AllowsActionOn(nil, userA, action, testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			}
+
+			allowed, err := acl.AllowsActionOn(nil, userA, "delete", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			allowed, err = acl.AllowsActionOn(nil, userA, "edit", testResourceB)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			Convey("And RevokeRole() should remove the actions", func() {
+				So(acl.RevokeRole(nil, userA, "editor", testResourceA), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(nil, userA, "edit", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("And SetRoleActions() should change the actions of the assignment", func() {
+				So(acl.SetRoleActions(nil, "editor", []string{"view"}), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(nil, userA, "edit", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+
+				allowed, err = acl.AllowsActionOn(nil, userA, "view", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("And a direct deny should take precedence over the role", func() {
+				So(acl.SetActionAllowedOn(nil, userA, "edit", testResourceA, false), ShouldBeNil)
+
+				decision, err := acl.ExplainActionOn(ctx, nil, userA, "edit", testResourceA)
+				So(err, ShouldBeNil)
+				So(decision.Allowed, ShouldEqual, false)
+				So(decision.Source.Role, ShouldEqual, "")
+				So(len(decision.Shadowed), ShouldEqual, 1)
+				So(decision.Shadowed[0].Role, ShouldEqual, "editor")
+			})
+		})
+
+		Convey("AssignRole() with NilResource should allow the actions on any target", func() {
+			So(acl.AssignRole(nil, userB, "editor", NilResource{}), ShouldBeNil)
+			So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOn(nil, userA, "comment", testResourceB)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			decision, err := acl.ExplainAction(ctx, nil, userA, "comment")
+			So(err, ShouldBeNil)
+			So(decision.Source.Role, ShouldEqual, "editor")
+			So(decision.Source.Path, ShouldResemble, []string{userA.GetId(), userB.GetId()})
+
+			Convey("And a direct deny on the inherited actor should still win", func() {
+				So(acl.SetActionAllowed(nil, userA, "comment", false), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(nil, userA, "comment", testResourceB)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("And ListActors() should include the actors with the role", func() {
+				actors, err := acl.ListActors(ctx, nil, testResourceA, "edit", true)
+				So(err, ShouldBeNil)
+				So(actors, ShouldResemble, []string{userA.GetId()})
+			})
+		})
+	})
+}
@@ -0,0 +1,1040 @@
+package acl
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SQLite has neither ARRAY nor procedural triggers, and triggers cannot use
+// WITH RECURSIVE. Cycles are instead prevented using the $TABLE_Paths table,
+// which is maintained by triggers and contains the number of paths between
+// every ancestor and descendant in the tree.
+
+var tpl_sqlite_tree_table = `CREATE TABLE "$TABLE"
+(
+	"id" TEXT NOT NULL,
+	"parent_id" TEXT,
+	PRIMARY KEY ("id", "parent_id")
+);`
+
+var tpl_sqlite_paths_table = `CREATE TABLE "$TABLE_Paths"
+(
+	"ancestor_id" TEXT NOT NULL,
+	"descendant_id" TEXT NOT NULL,
+	"paths" INTEGER NOT NULL,
+	PRIMARY KEY ("ancestor_id", "descendant_id")
+);`
+
+var tpl_sqlite_paths_seed = `
+INSERT INTO "$TABLE_Paths" ("ancestor_id", "descendant_id", "paths")
+	WITH RECURSIVE p("ancestor_id", "descendant_id") AS (
+		SELECT "parent_id", "id" FROM "$TABLE" WHERE "parent_id" IS NOT NULL
+	UNION ALL
+		SELECT t."parent_id", p."descendant_id"
+		FROM p
+		JOIN "$TABLE" t ON t."id" = p."ancestor_id"
+		WHERE t."parent_id" IS NOT NULL
+	)
+	SELECT "ancestor_id", "descendant_id", COUNT(1) FROM p GROUP BY "ancestor_id", "descendant_id";`
+
+var tpl_sqlite_prevent_cycles = `
+	SELECT RAISE(ABORT, 'Cycles are not allowed in "$TABLE"')
+	WHERE NEW."id" = NEW."parent_id" OR EXISTS (SELECT 1 FROM "$TABLE_Paths" p
+		WHERE p."ancestor_id" = NEW."id" AND p."descendant_id" = NEW."parent_id");`
+
+// {ROW} is NEW or OLD and {OP} is + or -, every path ending in {ROW}."parent_id"
+// combined with every path starting in {ROW}."id" goes through the edge
+var tpl_sqlite_paths_insert = `
+	INSERT OR IGNORE INTO "$TABLE_Paths" ("ancestor_id", "descendant_id", "paths")
+		SELECT a."id", d."id", 0
+		FROM (SELECT {ROW}."parent_id" "id" UNION ALL SELECT p."ancestor_id" FROM "$TABLE_Paths" p WHERE p."descendant_id" = {ROW}."parent_id") a,
+			(SELECT {ROW}."id" "id" UNION ALL SELECT p."descendant_id" FROM "$TABLE_Paths" p WHERE p."ancestor_id" = {ROW}."id") d
+		WHERE {ROW}."parent_id" IS NOT NULL;`
+
+var tpl_sqlite_paths_update = `
+	UPDATE "$TABLE_Paths" SET "paths" = "paths" {OP} (
+		SELECT a."n" * d."n"
+		FROM (SELECT {ROW}."parent_id" "id", 1 "n" UNION ALL SELECT p."ancestor_id", p."paths" FROM "$TABLE_Paths" p WHERE p."descendant_id" = {ROW}."parent_id") a,
+			(SELECT {ROW}."id" "id", 1 "n" UNION ALL SELECT p."descendant_id", p."paths" FROM "$TABLE_Paths" p WHERE p."ancestor_id" = {ROW}."id") d
+		WHERE a."id" = "$TABLE_Paths"."ancestor_id" AND d."id" = "$TABLE_Paths"."descendant_id")
+	WHERE "ancestor_id" IN (SELECT {ROW}."parent_id" UNION ALL SELECT p."ancestor_id" FROM "$TABLE_Paths" p WHERE p."descendant_id" = {ROW}."parent_id")
+		AND "descendant_id" IN (SELECT {ROW}."id" UNION ALL SELECT p."descendant_id" FROM "$TABLE_Paths" p WHERE p."ancestor_id" = {ROW}."id");`
+
+var tpl_sqlite_paths_cleanup = `
+	DELETE FROM "$TABLE_Paths" WHERE "paths" <= 0;`
+
+var tpl_sqlite_tree_triggers = map[string]string{"$TABLE_PreventCycles": `
+CREATE TRIGGER "$TABLE_PreventCycles" BEFORE INSERT ON "$TABLE" FOR EACH ROW
+BEGIN` + tpl_sqlite_prevent_cycles + `
+END;`, "$TABLE_PreventCyclesUpdate": `
+CREATE TRIGGER "$TABLE_PreventCyclesUpdate" BEFORE UPDATE ON "$TABLE" FOR EACH ROW
+BEGIN` + tpl_sqlite_prevent_cycles + `
+END;`, "$TABLE_PathsInsert": `
+CREATE TRIGGER "$TABLE_PathsInsert" AFTER INSERT ON "$TABLE" FOR EACH ROW
+BEGIN` +
+	strings.Replace(tpl_sqlite_paths_insert, "{ROW}", "NEW", -1) +
+	strings.NewReplacer("{ROW}", "NEW", "{OP}", "+").Replace(tpl_sqlite_paths_update) + `
+END;`, "$TABLE_PathsDelete": `
+CREATE TRIGGER "$TABLE_PathsDelete" AFTER DELETE ON "$TABLE" FOR EACH ROW
+BEGIN` +
+	strings.NewReplacer("{ROW}", "OLD", "{OP}", "-").Replace(tpl_sqlite_paths_update) +
+	tpl_sqlite_paths_cleanup + `
+END;`, "$TABLE_PathsUpdate": `
+CREATE TRIGGER "$TABLE_PathsUpdate" AFTER UPDATE ON "$TABLE" FOR EACH ROW
+BEGIN` +
+	strings.NewReplacer("{ROW}", "OLD", "{OP}", "-").Replace(tpl_sqlite_paths_update) +
+	tpl_sqlite_paths_cleanup +
+	strings.Replace(tpl_sqlite_paths_insert, "{ROW}", "NEW", -1) +
+	strings.NewReplacer("{ROW}", "NEW", "{OP}", "+").Replace(tpl_sqlite_paths_update) + `
+END;`}
+
+var tpl_sqlite_acl_table = `CREATE TABLE "$TABLE"
+(
+	"actor_id" TEXT NOT NULL,
+	"action" VARCHAR(255) NOT NULL,
+	"target_id" TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000',
+	"allowed" BOOLEAN NOT NULL,
+	PRIMARY KEY ("actor_id", "action", "target_id")
+);`
+
+var tpl_sqlite_roles_table = `CREATE TABLE "$TABLE_Roles"
+(
+	"role" VARCHAR(255) NOT NULL,
+	"action" VARCHAR(255) NOT NULL,
+	PRIMARY KEY ("role", "action")
+);`
+
+var tpl_sqlite_role_assignments_table = `CREATE TABLE "$TABLE_RoleAssignments"
+(
+	"actor_id" TEXT NOT NULL,
+	"role" VARCHAR(255) NOT NULL,
+	"target_id" TEXT NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000',
+	PRIMARY KEY ("actor_id", "role", "target_id")
+);`
+
+// SQLite has no date type, times are stored as text in UTC with a fixed width
+// so that they compare in order
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000000"
+
+var tpl_sqlite_validity_columns = []string{
+	`ALTER TABLE "$TABLE" ADD COLUMN "valid_from" TEXT;`,
+	`ALTER TABLE "$TABLE" ADD COLUMN "valid_until" TEXT;`}
+
+var tpl_sqlite_condition_column = []string{
+	`ALTER TABLE "$TABLE" ADD COLUMN "condition" TEXT NOT NULL DEFAULT '';`}
+
+var tpl_sqlite_audit_table = `CREATE TABLE "$TABLE_Audit"
+(
+	"id" INTEGER PRIMARY KEY AUTOINCREMENT,
+	"changed_at" TEXT NOT NULL,
+	"changed_by" TEXT,
+	"kind" VARCHAR(255) NOT NULL,
+	"operation" VARCHAR(6) NOT NULL,
+	"actor_id" TEXT,
+	"action" VARCHAR(255),
+	"target_id" TEXT,
+	"old_row" TEXT,
+	"new_row" TEXT
+);`
+
+// SQLite has no session variables, the actor making the changes is kept in
+// $TABLE_AuditContext for the duration of the change instead, see WithChangedBy
+var tpl_sqlite_audit_context_table = `CREATE TABLE "$TABLE_AuditContext"
+(
+	"id" INTEGER PRIMARY KEY,
+	"changed_by" TEXT NOT NULL
+);`
+
+var tpl_sqlite_audit_trigger = `
+CREATE TRIGGER "{table}_Audit{event}" AFTER {event} ON "{table}" FOR EACH ROW
+BEGIN
+	INSERT INTO "{aclTable}_Audit" ("changed_at", "changed_by", "kind", "operation", "actor_id", "action", "target_id", "old_row", "new_row")
+		VALUES (strftime('%Y-%m-%d %H:%M:%f000000', 'now'), (SELECT NULLIF("changed_by", '') FROM "{aclTable}_AuditContext" WHERE "id" = 1), '{kind}', '{event}', {actor}, {action}, {target}, {old}, {new});
+END;`
+
+var tpl_sqlite_audit_append_only = map[string]string{"$TABLE_AuditAppendOnlyUpdate": `
+CREATE TRIGGER "$TABLE_AuditAppendOnlyUpdate" BEFORE UPDATE ON "$TABLE_Audit" FOR EACH ROW
+BEGIN
+	SELECT RAISE(ABORT, 'The audit log "$TABLE_Audit" is append-only');
+END;`, "$TABLE_AuditAppendOnlyDelete": `
+CREATE TRIGGER "$TABLE_AuditAppendOnlyDelete" BEFORE DELETE ON "$TABLE_Audit" FOR EACH ROW
+BEGIN
+	SELECT RAISE(ABORT, 'The audit log "$TABLE_Audit" is append-only');
+END;`}
+
+var tpl_sqlite_history_table = `
+CREATE TABLE "{table}_History" AS
+	SELECT {columns}, NULL AS "recorded_from", NULL AS "recorded_until"
+	FROM "{table}";`
+
+var tpl_sqlite_history_index = `
+CREATE INDEX IF NOT EXISTS "{table}_History_Keys" ON "{table}_History" ({keys});`
+
+var tpl_sqlite_history_close = `
+	UPDATE "{table}_History" SET "recorded_until" = strftime('%Y-%m-%d %H:%M:%f000000', 'now') WHERE "recorded_until" IS NULL AND ({keys}) = ({oldKeys});`
+
+var tpl_sqlite_history_open = `
+	INSERT INTO "{table}_History" ({columns}, "recorded_from") VALUES ({newColumns}, strftime('%Y-%m-%d %H:%M:%f000000', 'now'));`
+
+var tpl_sqlite_history_trigger = `
+CREATE TRIGGER "{table}_History{event}" AFTER {event} ON "{table}" FOR EACH ROW
+BEGIN{statements}
+END;`
+
+var tpl_sqlite_actor_delete_trigger = `
+CREATE TRIGGER "{treeTable}_{relatedTable}_DELETED_REMOVE_PRIMARY" AFTER DELETE ON "{relatedTable}" FOR EACH ROW
+BEGIN
+	DELETE FROM "{treeTable}" WHERE "id" = OLD."{relatedKey}" OR "parent_id" = OLD."{relatedKey}";
+END;`
+
+var tpl_sqlite_link_delete_trigger = `
+CREATE TRIGGER "{aclTable}_{linkType}_{relatedTable}_DELETED" AFTER DELETE ON "{relatedTable}" FOR EACH ROW
+BEGIN
+	DELETE FROM "{aclTable}" WHERE "{localKey}" = OLD."{relatedKey}";
+END;`
+
+// EnsureSQLiteTablesAndRulesExist is the SQLite version of EnsureTablesAndRulesExist,
+// it creates the tables and triggers required by SQLiteStore if they do not exist
+func EnsureSQLiteTablesAndRulesExist(db *sql.DB, treeTable string, table string, cascades Cascades) error {
+	t, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = ensureSQLiteTables(t, treeTable, table, cascades)
+	if err != nil {
+		t.Rollback()
+
+		return err
+	}
+
+	return t.Commit()
+}
+
+func ensureSQLiteTables(t *sql.Tx, treeTable string, table string, cascades Cascades) error {
+	err := ensureSQLiteTree(t, treeTable, cascades.Actors)
+	if err != nil {
+		return err
+	}
+
+	exists, err := sqliteTableExists(t, table)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		_, err = t.Exec(strings.Replace(tpl_sqlite_acl_table, "$TABLE", table, -1))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, tableName := range []string{treeTable, table} {
+		err = ensureSQLiteColumn(t, tableName, "valid_until", tpl_sqlite_validity_columns)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ensureSQLiteColumn(t, table, "condition", tpl_sqlite_condition_column)
+	if err != nil {
+		return err
+	}
+
+	for tableName, tpl := range map[string]string{table + "_Roles": tpl_sqlite_roles_table, table + "_RoleAssignments": tpl_sqlite_role_assignments_table} {
+		exists, err = sqliteTableExists(t, tableName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			_, err = t.Exec(strings.Replace(tpl, "$TABLE", table, -1))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, linkTable := range []string{table, table + "_RoleAssignments"} {
+		err = ensureSQLiteLinks(t, linkTable, cascades.Actors, "ACTOR", "actor_id")
+		if err != nil {
+			return err
+		}
+
+		err = ensureSQLiteLinks(t, linkTable, cascades.Targets, "TARGET", "target_id")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ensureSQLiteAudit(t, treeTable, table)
+	if err != nil {
+		return err
+	}
+
+	return ensureSQLiteHistory(t, treeTable, table)
+}
+
+// ensureSQLiteHistory creates the history tables of the audited tables and
+// the triggers maintaining them, see ACL.AllowsActionOnAt
+func ensureSQLiteHistory(t *sql.Tx, treeTable string, table string) error {
+	for _, audited := range auditedTables(treeTable, table) {
+		replacer := strings.NewReplacer("{table}", audited.table, "{columns}", historyColumns(audited.columns, `"`, `"`),
+			"{keys}", historyColumns(audited.keys, `"`, `"`), "{oldKeys}", historyColumns(audited.keys, `OLD."`, `"`), "{newColumns}", historyColumns(audited.columns, `NEW."`, `"`))
+
+		exists, err := sqliteTableExists(t, audited.table+"_History")
+		if err != nil {
+			return err
+		}
+		if !exists {
+			/* Rows predating the history are recorded as current since forever */
+			_, err = t.Exec(replacer.Replace(tpl_sqlite_history_table))
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = t.Exec(replacer.Replace(tpl_sqlite_history_index))
+		if err != nil {
+			return err
+		}
+
+		statements := map[string]string{
+			"INSERT": tpl_sqlite_history_open,
+			"UPDATE": tpl_sqlite_history_close + tpl_sqlite_history_open,
+			"DELETE": tpl_sqlite_history_close,
+		}
+
+		for _, event := range []string{"INSERT", "UPDATE", "DELETE"} {
+			err = ensureSQLiteTrigger(t, audited.table+"_History"+event, replacer.Replace(strings.NewReplacer("{event}", event, "{statements}", statements[event]).Replace(tpl_sqlite_history_trigger)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ensureSQLiteAudit creates the append-only audit log and the triggers
+// filling it, see ACL.AuditLog
+func ensureSQLiteAudit(t *sql.Tx, treeTable string, table string) error {
+	for tableName, tpl := range map[string]string{table + "_Audit": tpl_sqlite_audit_table, table + "_AuditContext": tpl_sqlite_audit_context_table} {
+		exists, err := sqliteTableExists(t, tableName)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			_, err = t.Exec(strings.Replace(tpl, "$TABLE", table, -1))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, tpl := range tpl_sqlite_audit_append_only {
+		err := ensureSQLiteTrigger(t, strings.Replace(name, "$TABLE", table, -1), strings.Replace(tpl, "$TABLE", table, -1))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, audited := range auditedTables(treeTable, table) {
+		err := ensureSQLiteAuditTriggers(t, table, audited)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureSQLiteAuditTriggers creates the triggers recording the changes of the
+// audited table in the audit log of the ACL table
+func ensureSQLiteAuditTriggers(t *sql.Tx, table string, audited auditedTable) error {
+	/* Triggers cannot use NEW and OLD generically, the columns are listed */
+	for _, event := range []string{"INSERT", "UPDATE", "DELETE"} {
+		row := "NEW"
+		if event == "DELETE" {
+			row = "OLD"
+		}
+
+		column := func(name string) string {
+			if name == "" {
+				return "NULL"
+			}
+
+			return row + `."` + name + `"`
+		}
+
+		object := func(row string) string {
+			var pairs []string
+
+			for _, name := range audited.columns {
+				pairs = append(pairs, `'`+name+`', `+row+`."`+name+`"`)
+			}
+
+			return "json_object(" + strings.Join(pairs, ", ") + ")"
+		}
+
+		oldRow, newRow := object("OLD"), object("NEW")
+
+		switch event {
+		case "INSERT":
+			oldRow = "NULL"
+		case "DELETE":
+			newRow = "NULL"
+		}
+
+		replacer := strings.NewReplacer("{table}", audited.table, "{aclTable}", table, "{event}", event, "{kind}", audited.kind,
+			"{actor}", column(audited.actor), "{action}", column(audited.action), "{target}", column(audited.target), "{old}", oldRow, "{new}", newRow)
+
+		err := ensureSQLiteTrigger(t, audited.table+"_Audit"+event, replacer.Replace(tpl_sqlite_audit_trigger))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EnsureSQLiteTargetTreeExists is the SQLite version of EnsureTargetTreeExists
+func EnsureSQLiteTargetTreeExists(db *sql.DB, targetTreeTable string, table string, links []Link) error {
+	t, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = ensureSQLiteTree(t, targetTreeTable, links)
+	if err != nil {
+		t.Rollback()
+
+		return err
+	}
+
+	err = ensureSQLiteAuditTriggers(t, table, targetTreeAudit(targetTreeTable))
+	if err != nil {
+		t.Rollback()
+
+		return err
+	}
+
+	return t.Commit()
+}
+
+// ensureSQLiteTree creates the tree table, its paths table and the triggers
+// maintaining them
+func ensureSQLiteTree(t *sql.Tx, treeTable string, links []Link) error {
+	exists, err := sqliteTableExists(t, treeTable)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		_, err = t.Exec(strings.Replace(tpl_sqlite_tree_table, "$TABLE", treeTable, -1))
+		if err != nil {
+			return err
+		}
+	}
+
+	exists, err = sqliteTableExists(t, treeTable+"_Paths")
+	if err != nil {
+		return err
+	}
+	if !exists {
+		_, err = t.Exec(strings.Replace(tpl_sqlite_paths_table, "$TABLE", treeTable, -1))
+		if err != nil {
+			return err
+		}
+
+		/* The tree table might already contain rows */
+		_, err = t.Exec(strings.Replace(tpl_sqlite_paths_seed, "$TABLE", treeTable, -1))
+		if err != nil {
+			return err
+		}
+	}
+
+	for name, tpl := range tpl_sqlite_tree_triggers {
+		err = ensureSQLiteTrigger(t, strings.Replace(name, "$TABLE", treeTable, -1), strings.Replace(tpl, "$TABLE", treeTable, -1))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, link := range links {
+		replacer := strings.NewReplacer("{treeTable}", treeTable, "{relatedTable}", link.Table, "{relatedKey}", link.Key)
+
+		err = ensureSQLiteTrigger(t, replacer.Replace("{treeTable}_{relatedTable}_DELETED_REMOVE_PRIMARY"), replacer.Replace(tpl_sqlite_actor_delete_trigger))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureSQLiteTrigger replaces the trigger, SQLite has no CREATE OR REPLACE
+// TRIGGER and the body of a trigger created by an earlier version is kept by
+// CREATE TRIGGER IF NOT EXISTS
+func ensureSQLiteTrigger(t *sql.Tx, name string, query string) error {
+	_, err := t.Exec(`DROP TRIGGER IF EXISTS "` + name + `"`)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.Exec(query)
+
+	return err
+}
+
+// ensureSQLiteColumn adds the columns of the templates to the table if the
+// column is missing, migrating tables created by earlier versions
+func ensureSQLiteColumn(t *sql.Tx, tableName string, columnName string, tpls []string) error {
+	numRows := 0
+	row := t.QueryRow(`SELECT COUNT(1) FROM pragma_table_info(?) WHERE "name" = ?`, tableName, columnName)
+
+	err := row.Scan(&numRows)
+	if err != nil || numRows > 0 {
+		return err
+	}
+
+	for _, tpl := range tpls {
+		_, err = t.Exec(strings.Replace(tpl, "$TABLE", tableName, -1))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sqliteTime returns the time in the format stored by SQLiteStore, or nil for
+// a zero time
+func sqliteTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// sqliteTableExists returns true if the supplied table name exists
+func sqliteTableExists(t *sql.Tx, tableName string) (bool, error) {
+	numRows := 0
+	row := t.QueryRow("SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = ?", tableName)
+
+	err := row.Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+
+	return numRows == 1, nil
+}
+
+func ensureSQLiteLinks(t *sql.Tx, tableName string, links []Link, linkType string, linkColumn string) error {
+	for _, link := range links {
+		replacer := strings.NewReplacer("{aclTable}", tableName, "{linkType}", linkType, "{relatedTable}", link.Table, "{localKey}", linkColumn, "{relatedKey}", link.Key)
+
+		err := ensureSQLiteTrigger(t, replacer.Replace("{aclTable}_{linkType}_{relatedTable}_DELETED"), replacer.Replace(tpl_sqlite_link_delete_trigger))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SQLiteStore is a Store using the tables created by EnsureSQLiteTablesAndRulesExist
+// and optionally EnsureSQLiteTargetTreeExists, it requires SQLite 3.24 or later
+// with the JSON1 extension
+type SQLiteStore struct {
+	table           string
+	treeTable       string
+	targetTreeTable string
+}
+
+// NewSQLiteStore creates a new Store using the given SQLite tables, without
+// any target tree
+func NewSQLiteStore(treeTable string, table string) *SQLiteStore {
+	return &SQLiteStore{treeTable: treeTable, table: table}
+}
+
+// NewSQLiteStoreWithTargetTree creates a new Store using the given SQLite
+// tables, including a target tree
+func NewSQLiteStoreWithTargetTree(treeTable string, targetTreeTable string, table string) *SQLiteStore {
+	return &SQLiteStore{treeTable: treeTable, targetTreeTable: targetTreeTable, table: table}
+}
+
+func (s *SQLiteStore) SetGrant(ctx context.Context, q Querier, grant Grant) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		update := `"allowed" = excluded."allowed"`
+
+		if grant.scoped() {
+			update += `, "valid_from" = excluded."valid_from", "valid_until" = excluded."valid_until", "condition" = excluded."condition"`
+		}
+
+		_, err := q.ExecContext(ctx, `INSERT INTO "`+s.table+`" ("actor_id", "action", "target_id", "allowed", "valid_from", "valid_until", "condition") VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT ("actor_id", "action", "target_id") DO UPDATE SET `+update, grant.ActorId, grant.Action, grant.TargetId, grant.Allowed, sqliteTime(grant.ValidFrom), sqliteTime(grant.ValidUntil), grant.Condition)
+
+		return err
+	})
+}
+
+func (s *SQLiteStore) UnsetGrant(ctx context.Context, q Querier, actorId string, action string, targetId string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `DELETE FROM "`+s.table+`" WHERE "actor_id" = ? AND "action" = ? AND "target_id" = ?`, actorId, action, targetId)
+
+		return err
+	})
+}
+
+func (s *SQLiteStore) SetParent(ctx context.Context, q Querier, id string, parentId string, validFrom time.Time, validUntil time.Time) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		conflict := `NOTHING`
+
+		if !validFrom.IsZero() || !validUntil.IsZero() {
+			conflict = `UPDATE SET "valid_from" = excluded."valid_from", "valid_until" = excluded."valid_until"`
+		}
+
+		_, err := q.ExecContext(ctx, `INSERT INTO "`+s.treeTable+`" ("id", "parent_id", "valid_from", "valid_until") VALUES (?, ?, ?, ?)
+		ON CONFLICT ("id", "parent_id") DO `+conflict, id, parentId, sqliteTime(validFrom), sqliteTime(validUntil))
+
+		return err
+	})
+}
+
+func (s *SQLiteStore) RemoveParent(ctx context.Context, q Querier, id string, parentId string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `DELETE FROM "`+s.treeTable+`" WHERE "id" = ? AND "parent_id" = ?`, id, parentId)
+
+		return err
+	})
+}
+
+func (s *SQLiteStore) Parents(ctx context.Context, q Querier, id string) ([]string, error) {
+	return queryIds(ctx, q, `SELECT "parent_id" FROM "`+s.treeTable+`" WHERE "id" = ? ORDER BY "parent_id"`, id)
+}
+
+func (s *SQLiteStore) Children(ctx context.Context, q Querier, id string) ([]string, error) {
+	return queryIds(ctx, q, `SELECT "id" FROM "`+s.treeTable+`" WHERE "parent_id" = ? ORDER BY "id"`, id)
+}
+
+func (s *SQLiteStore) Ancestors(ctx context.Context, q Querier, id string) ([]string, error) {
+	return queryIds(ctx, q, `WITH RECURSIVE q("id") AS (
+	SELECT ?
+UNION
+	SELECT t."parent_id"
+	FROM q
+	JOIN "`+s.treeTable+`" t ON t."id" = q."id"
+)
+SELECT "id" FROM q`, id)
+}
+
+func (s *SQLiteStore) RemoveExpired(ctx context.Context, q Querier, at time.Time) (int64, error) {
+	var n int64
+
+	err := s.audited(ctx, q, func(q Querier) error {
+		var err error
+
+		n, err = execCount(ctx, q, []string{
+			`DELETE FROM "` + s.table + `" WHERE "valid_until" <= ?`,
+			`DELETE FROM "` + s.treeTable + `" WHERE "valid_until" <= ?`,
+		}, sqliteTime(at))
+
+		return err
+	})
+
+	return n, err
+}
+
+func (s *SQLiteStore) Candidates(ctx context.Context, q Querier, query Query) ([]Candidate, error) {
+	if (query.Actions != nil && len(query.Actions) == 0) || (query.TargetIds != nil && len(query.TargetIds) == 0) {
+		return nil, nil
+	}
+
+	args := []interface{}{query.ActorId}
+	where := "1"
+	treeWhere := ""
+
+	if !query.At.IsZero() {
+		args = append(args, sqliteTime(query.At))
+		where += " AND " + validWhere("a", "?2")
+		treeWhere = " AND " + validWhere("t", "?2")
+	}
+
+	if query.Actions != nil {
+		where += ` AND a."action" IN (` + placeholders("?", &args, query.Actions) + `)`
+	}
+
+	if query.TargetIds != nil {
+		where += ` AND a."target_id" IN (` + placeholders("?", &args, query.TargetIds) + `)`
+	}
+
+	asOf := s.asOf(&args, query.AsOf)
+	ancestors := ""
+
+	/* The ancestors are the rows of h without any grant columns */
+	if query.Ancestors {
+		ancestors = `
+UNION ALL
+	SELECT ?1, '', ?1, 0, '', '', 0, ',' || ?1 || ','
+UNION ALL
+	SELECT q."parent_id", '', q."parent_id", 0, '', '', q."level", q."path" || q."parent_id" || ','
+	FROM q`
+	}
+
+	/* The path is a comma-separated list of visited ids, wrapped in commas,
+	   SQLite takes the bare path column from the row with the MIN() level */
+	return queryCandidates(ctx, q, `WITH RECURSIVE q("parent_id", "path", "level") AS (
+	SELECT t."parent_id", ',' || t."id" || ',', 1
+	FROM `+s.source(s.treeTable, asOf)+` t
+	WHERE t."id" = ?1`+treeWhere+`
+UNION ALL
+	SELECT t."parent_id", q."path" || t."id" || ',', q."level" + 1
+	FROM q
+	JOIN `+s.source(s.treeTable, asOf)+` t ON t."id" = q."parent_id"
+	WHERE instr(q."path", ',' || t."id" || ',') = 0`+treeWhere+`
+)
+SELECT a."actor_id", a."action", a."target_id", a."allowed", a."role", a."condition", MIN(h."level") AS "level", h."path"
+FROM (
+	SELECT ?1 AS "id", 0 AS "level", ',' || ?1 || ',' AS "path"
+UNION ALL
+	SELECT q."parent_id" AS "id", q."level", q."path" || q."parent_id" || ','
+	FROM q
+) h
+JOIN `+s.grants(asOf)+` a ON a."actor_id" = h."id"
+WHERE `+where+`
+GROUP BY a."actor_id", a."action", a."target_id", a."allowed", a."role", a."condition"`+ancestors+`
+ORDER BY "level" ASC, "target_id" DESC, "allowed" ASC`, args...)
+}
+
+func (s *SQLiteStore) TargetCandidates(ctx context.Context, q Querier, query Query) ([]Candidate, map[string]map[string]int, error) {
+	if query.Actions != nil && len(query.Actions) == 0 {
+		return nil, nil, nil
+	}
+
+	args := []interface{}{query.ActorId}
+	where := "1"
+	treeWhere := ""
+
+	if !query.At.IsZero() {
+		args = append(args, sqliteTime(query.At))
+		where += " AND " + validWhere("a", "?2")
+		treeWhere = " AND " + validWhere("t", "?2")
+	}
+
+	if query.Actions != nil {
+		where += ` AND a."action" IN (` + placeholders("?", &args, query.Actions) + `)`
+	}
+
+	roots := placeholders("?", &args, append([]string{EMPTY_RESOURCE}, query.TargetIds...))
+	targets := `, r("id") AS (
+	VALUES (` + strings.Join(strings.Split(roots, ", "), "), (") + `)
+)`
+
+	/* The levels of the targets are the checked targets themselves on level
+	   0 and their ancestors if there is a target tree */
+	if s.targetTreeTable != "" {
+		targets += `, tq("start_id", "parent_id", "path", "level") AS (
+	SELECT "id", "parent_id", ',' || "id" || ',', 1
+	FROM "` + s.targetTreeTable + `"
+	WHERE "id" IN (` + roots + `)
+UNION ALL
+	SELECT tq."start_id", t."parent_id", tq."path" || t."id" || ',', tq."level" + 1
+	FROM tq
+	JOIN "` + s.targetTreeTable + `" t ON t."id" = tq."parent_id"
+	WHERE instr(tq."path", ',' || t."id" || ',') = 0
+), tl("start_id", "target_id", "level") AS (
+	SELECT "id", "id", 0
+	FROM r
+UNION ALL
+	SELECT "start_id", "parent_id", MIN("level")
+	FROM tq
+	GROUP BY "start_id", "parent_id"
+)`
+	} else {
+		targets += `, tl("start_id", "target_id", "level") AS (
+	SELECT "id", "id", 0
+	FROM r
+)`
+	}
+
+	asOf := s.asOf(&args, query.AsOf)
+
+	return queryTargetCandidates(ctx, q, `WITH RECURSIVE q("parent_id", "path", "level") AS (
+	SELECT t."parent_id", ',' || t."id" || ',', 1
+	FROM `+s.source(s.treeTable, asOf)+` t
+	WHERE t."id" = ?1`+treeWhere+`
+UNION ALL
+	SELECT t."parent_id", q."path" || t."id" || ',', q."level" + 1
+	FROM q
+	JOIN `+s.source(s.treeTable, asOf)+` t ON t."id" = q."parent_id"
+	WHERE instr(q."path", ',' || t."id" || ',') = 0`+treeWhere+`
+)`+targets+`
+SELECT c."actor_id", c."action", c."target_id", c."allowed", c."role", c."condition", c."level", c."path", tl."start_id", tl."level"
+FROM (
+	SELECT a."actor_id", a."action", a."target_id", a."allowed", a."role", a."condition", MIN(h."level") AS "level", h."path"
+	FROM (
+		SELECT ?1 AS "id", 0 AS "level", ',' || ?1 || ',' AS "path"
+	UNION ALL
+		SELECT q."parent_id" AS "id", q."level", q."path" || q."parent_id" || ','
+		FROM q
+	) h
+	JOIN `+s.grants(asOf)+` a ON a."actor_id" = h."id"
+	WHERE `+where+` AND a."target_id" IN (SELECT "target_id" FROM tl)
+	GROUP BY a."actor_id", a."action", a."target_id", a."allowed", a."role", a."condition"
+) c
+JOIN tl ON tl."target_id" = c."target_id"
+ORDER BY c."level" ASC, c."target_id" DESC, c."allowed" ASC`, args...)
+}
+
+func (s *SQLiteStore) Inheritors(ctx context.Context, q Querier, query Query) ([]Inheritor, error) {
+	if (query.Actions != nil && len(query.Actions) == 0) || (query.TargetIds != nil && len(query.TargetIds) == 0) {
+		return nil, nil
+	}
+
+	args := []interface{}{}
+	where := "1"
+	treeWhere := ""
+
+	if !query.At.IsZero() {
+		args = append(args, sqliteTime(query.At))
+		where += " AND " + validWhere("a", "?1")
+		treeWhere = " AND " + validWhere("t", "?1")
+	}
+
+	if query.Actions != nil {
+		where += ` AND a."action" IN (` + placeholders("?", &args, query.Actions) + `)`
+	}
+
+	if query.TargetIds != nil {
+		where += ` AND a."target_id" IN (` + placeholders("?", &args, query.TargetIds) + `)`
+	}
+
+	asOf := s.asOf(&args, query.AsOf)
+
+	/* Walking down from the actors of the grants, the path of an inheritor
+	   is prepended to the path of its parent */
+	return queryInheritors(ctx, q, `WITH RECURSIVE g AS (
+	SELECT a."actor_id", a."action", a."target_id", a."allowed", a."role", a."condition"
+	FROM `+s.grants(asOf)+` a
+	WHERE `+where+`
+), q("id", "grant_actor_id", "path", "level") AS (
+	SELECT DISTINCT "actor_id", "actor_id", ',' || "actor_id" || ',', 0
+	FROM g
+UNION ALL
+	SELECT t."id", q."grant_actor_id", ',' || t."id" || q."path", q."level" + 1
+	FROM q
+	JOIN `+s.source(s.treeTable, asOf)+` t ON t."parent_id" = q."id"
+	WHERE instr(q."path", ',' || t."id" || ',') = 0`+treeWhere+`
+)
+SELECT q."id", NOT EXISTS (SELECT 1 FROM "`+s.treeTable+`" c WHERE c."parent_id" = q."id"), g."actor_id", g."action", g."target_id", g."allowed", g."role", g."condition", MIN(q."level") AS "level", q."path"
+FROM q
+JOIN g ON g."actor_id" = q."grant_actor_id"
+GROUP BY q."id", g."actor_id", g."action", g."target_id", g."allowed", g."role", g."condition"
+ORDER BY q."id", "level" ASC, g."target_id" DESC, g."allowed" ASC`, args...)
+}
+
+func (s *SQLiteStore) Grants(ctx context.Context, q Querier, query Query) ([]Grant, error) {
+	if (query.Actions != nil && len(query.Actions) == 0) || (query.TargetIds != nil && len(query.TargetIds) == 0) {
+		return nil, nil
+	}
+
+	args := []interface{}{}
+	where := "1"
+
+	if query.ActorId != "" {
+		where += ` AND "actor_id" = ` + placeholders("?", &args, []string{query.ActorId})
+	}
+
+	if !query.At.IsZero() {
+		where += " AND " + validWhere("a", placeholders("?", &args, []string{query.At.UTC().Format(sqliteTimeFormat)}))
+	}
+
+	if query.Actions != nil {
+		where += ` AND "action" IN (` + placeholders("?", &args, query.Actions) + `)`
+	}
+
+	if query.TargetIds != nil {
+		where += ` AND "target_id" IN (` + placeholders("?", &args, query.TargetIds) + `)`
+	}
+
+	asOf := s.asOf(&args, query.AsOf)
+
+	return queryGrants(ctx, q, `SELECT "actor_id", "action", "target_id", "allowed", "role", "condition" FROM `+s.grants(asOf)+` a WHERE `+where+` ORDER BY "actor_id", "action", "target_id", "role"`, args...)
+}
+
+func (s *SQLiteStore) SetRoleActions(ctx context.Context, q Querier, role string, actions []string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `DELETE FROM "`+s.table+`_Roles" WHERE "role" = ?`, role)
+		if err != nil || len(actions) == 0 {
+			return err
+		}
+
+		args := []interface{}{role}
+		values := make([]string, len(actions))
+
+		for i, action := range actions {
+			values[i] = `(?1, ` + placeholders("?", &args, []string{action}) + `)`
+		}
+
+		_, err = q.ExecContext(ctx, `INSERT INTO "`+s.table+`_Roles" ("role", "action") VALUES `+strings.Join(values, ", "), args...)
+
+		return err
+	})
+}
+
+func (s *SQLiteStore) AssignRole(ctx context.Context, q Querier, actorId string, role string, targetId string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `INSERT OR IGNORE INTO "`+s.table+`_RoleAssignments" ("actor_id", "role", "target_id") VALUES (?, ?, ?)`, actorId, role, targetId)
+
+		return err
+	})
+}
+
+func (s *SQLiteStore) RevokeRole(ctx context.Context, q Querier, actorId string, role string, targetId string) error {
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `DELETE FROM "`+s.table+`_RoleAssignments" WHERE "actor_id" = ? AND "role" = ? AND "target_id" = ?`, actorId, role, targetId)
+
+		return err
+	})
+}
+
+func (s *SQLiteStore) AuditLog(ctx context.Context, q Querier, query AuditQuery) ([]AuditEntry, error) {
+	where, args := auditWhere(query, func(n int) string { return "?" }, func(id string) interface{} { return id }, func(t time.Time) interface{} { return sqliteTime(t) })
+	limit := ""
+
+	if query.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", query.Limit)
+	}
+
+	return queryAuditLog(ctx, q, `SELECT "id", "changed_at", "changed_by", "kind", "operation", "actor_id", "action", "target_id", "old_row", "new_row" FROM "`+s.table+`_Audit" WHERE `+where+` ORDER BY "id"`+limit, func(s string) (time.Time, error) {
+		return time.ParseInLocation(sqliteTimeFormat, s, time.UTC)
+	}, args...)
+}
+
+// audited runs f recording the actor of the context as the one making the
+// changes in the audit log, see WithChangedBy
+func (s *SQLiteStore) audited(ctx context.Context, q Querier, f func(q Querier) error) error {
+	return withChangedBy(ctx, q, `INSERT INTO "`+s.table+`_AuditContext" ("id", "changed_by") VALUES (1, ?) ON CONFLICT ("id") DO UPDATE SET "changed_by" = excluded."changed_by"`, f)
+}
+
+// grants returns a subquery selecting the rows of the ACL table along with the
+// grants of the assigned roles, as recorded at the placeholder asOf if it is
+// not empty
+func (s *SQLiteStore) grants(asOf string) string {
+	return `(
+		SELECT "actor_id", "action", "target_id", "allowed", '' AS "role", "valid_from", "valid_until", "condition"
+		FROM ` + s.source(s.table, asOf) + `
+	UNION ALL
+		SELECT r."actor_id", ra."action", r."target_id", 1, r."role", NULL, NULL, ''
+		FROM ` + s.source(s.table+"_RoleAssignments", asOf) + ` r
+		JOIN ` + s.source(s.table+"_Roles", asOf) + ` ra ON ra."role" = r."role"
+	)`
+}
+
+// source returns the table, or the rows recorded at the time in the
+// placeholder asOf in its history table if asOf is not empty, see asOf
+func (s *SQLiteStore) source(table string, asOf string) string {
+	if asOf == "" {
+		return `"` + table + `"`
+	}
+
+	return `(SELECT * FROM "` + table + `_History" WHERE ` + historyWhere(asOf) + `)`
+}
+
+// asOf appends the time of a query to args, returning its placeholder for
+// source, or an empty string for a zero time selecting the current rows
+func (s *SQLiteStore) asOf(args *[]interface{}, asOf time.Time) string {
+	if asOf.IsZero() {
+		return ""
+	}
+
+	*args = append(*args, sqliteTime(asOf))
+
+	return fmt.Sprintf("?%d", len(*args))
+}
+
+func (s *SQLiteStore) SetTargetParent(ctx context.Context, q Querier, id string, parentId string) error {
+	if s.targetTreeTable == "" {
+		return errNoTargetTree
+	}
+
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `INSERT INTO "`+s.targetTreeTable+`" ("id", "parent_id") SELECT ?1, ?2 WHERE NOT EXISTS (SELECT 1 FROM "`+s.targetTreeTable+`" WHERE "id" = ?1 AND "parent_id" = ?2)`, id, parentId)
+
+		return err
+	})
+}
+
+func (s *SQLiteStore) RemoveTargetParent(ctx context.Context, q Querier, id string, parentId string) error {
+	if s.targetTreeTable == "" {
+		return errNoTargetTree
+	}
+
+	return s.audited(ctx, q, func(q Querier) error {
+		_, err := q.ExecContext(ctx, `DELETE FROM "`+s.targetTreeTable+`" WHERE "id" = ? AND "parent_id" = ?`, id, parentId)
+
+		return err
+	})
+}
+
+func (s *SQLiteStore) TargetDescendants(ctx context.Context, q Querier, ids []string, page Page) (map[string]map[string]int, error) {
+	if s.targetTreeTable == "" || len(ids) == 0 {
+		return pageTargetLevels(targetLevels(ids), page), nil
+	}
+
+	args := []interface{}{}
+	roots := "(" + strings.Join(strings.Split(placeholders("?", &args, ids), ", "), "), (") + ")"
+	where := "1"
+	limit := ""
+
+	if page.After != "" {
+		args = append(args, page.After)
+		where = fmt.Sprintf(`"id" > ?%d`, len(args))
+	}
+
+	if page.Limit > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", page.Limit)
+	}
+
+	return queryTargetLevels(ctx, q, nil, `WITH RECURSIVE r("id") AS (
+	VALUES `+roots+`
+), q("id", "root_id", "path", "level") AS (
+	SELECT "id", "id", ',' || "id" || ',', 0
+	FROM r
+UNION ALL
+	SELECT t."id", q."root_id", q."path" || t."id" || ',', q."level" + 1
+	FROM q
+	JOIN "`+s.targetTreeTable+`" t ON t."parent_id" = q."id"
+	WHERE instr(q."path", ',' || t."id" || ',') = 0
+), p AS (
+	SELECT DISTINCT "id" FROM q WHERE `+where+` ORDER BY "id"`+limit+`
+)
+SELECT q."id", q."root_id", MIN(q."level")
+FROM q
+JOIN p ON p."id" = q."id"
+GROUP BY q."id", q."root_id"`, args...)
+}
+
+func (s *SQLiteStore) TargetLevels(ctx context.Context, q Querier, ids []string) (map[string]map[string]int, error) {
+	if s.targetTreeTable == "" || len(ids) == 0 {
+		return targetLevels(ids), nil
+	}
+
+	args := []interface{}{}
+
+	return queryTargetLevels(ctx, q, ids, `WITH RECURSIVE q("start_id", "parent_id", "path", "level") AS (
+	SELECT "id", "parent_id", ',' || "id" || ',', 1
+	FROM "`+s.targetTreeTable+`"
+	WHERE "id" IN (`+placeholders("?", &args, ids)+`)
+UNION ALL
+	SELECT q."start_id", t."parent_id", q."path" || t."id" || ',', q."level" + 1
+	FROM q
+	JOIN "`+s.targetTreeTable+`" t ON t."id" = q."parent_id"
+	WHERE instr(q."path", ',' || t."id" || ',') = 0
+)
+SELECT "start_id", "parent_id", MIN("level")
+FROM q
+GROUP BY "start_id", "parent_id"`, args...)
+}
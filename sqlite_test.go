@@ -0,0 +1,537 @@
+package acl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSQLite(t *testing.T) {
+	dir, err := os.MkdirTemp("", "acl-sqlite")
+	if err != nil {
+		panic(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "acl.db"))
+	if err != nil {
+		panic(err)
+	}
+
+	defer db.Close()
+
+	_, err = db.Exec(`CREATE TABLE "ACLTestActors" ("id" TEXT PRIMARY KEY)`)
+	if err != nil {
+		panic(err)
+	}
+
+	cascades := Cascades{Actors: []Link{{Table: "ACLTestActors", Key: "id"}}}
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+	userC := idAble{id: "7be24c16-6376-478d-91c9-f879116d1d49"}
+
+	testResourceA := idAble{id: "e74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+	testResourceB := idAble{id: "b5bd4f2e-4c2e-4e5a-9f0c-0d2a3f4e6b71"}
+
+	acl := NewWithStore(NewSQLiteStore("ACL_TestTree", "ACL_Test"), nil)
+	targetAcl := NewWithStore(NewSQLiteStoreWithTargetTree("ACL_TestTree", "ACL_TestTargetTree", "ACL_Test"), nil)
+
+	Convey("EnsureSQLiteTablesAndRulesExist() should create the tables", t, func() {
+		err := EnsureSQLiteTablesAndRulesExist(db, "ACL_TestTree", "ACL_Test", cascades)
+		So(err, ShouldBeNil)
+
+		Convey("And should not raise an error when they already exist", func() {
+			err := EnsureSQLiteTablesAndRulesExist(db, "ACL_TestTree", "ACL_Test", cascades)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("A store without its tables should return ErrSchemaMissing", t, func() {
+		_, err := NewWithStore(NewSQLiteStore("ACL_MissingTree", "ACL_Missing"), nil).AllowsAction(db, userA, "testing")
+		So(errors.Is(err, ErrSchemaMissing), ShouldEqual, true)
+	})
+
+	Convey("EnsureSQLiteTablesAndRulesExist() should add the validity to existing tables", t, func() {
+		_, err := db.Exec(strings.Replace(tpl_sqlite_tree_table, "$TABLE", "ACL_OldTree", -1))
+		So(err, ShouldBeNil)
+		_, err = db.Exec(strings.Replace(tpl_sqlite_acl_table, "$TABLE", "ACL_Old", -1))
+		So(err, ShouldBeNil)
+		_, err = db.Exec(`INSERT INTO "ACL_Old" ("actor_id", "action", "allowed") VALUES (?, 'testing', 1)`, userA.GetId())
+		So(err, ShouldBeNil)
+
+		So(EnsureSQLiteTablesAndRulesExist(db, "ACL_OldTree", "ACL_Old", Cascades{}), ShouldBeNil)
+
+		oldAcl := NewWithStore(NewSQLiteStore("ACL_OldTree", "ACL_Old"), nil)
+
+		allowed, err := oldAcl.AllowsAction(db, userA, "testing")
+		So(err, ShouldBeNil)
+		So(allowed, ShouldEqual, true)
+
+		allowed, err = oldAcl.AllowsActionAt(db, userA, "testing", time.Now().Add(-time.Hour))
+		So(err, ShouldBeNil)
+		So(allowed, ShouldEqual, true)
+
+		So(oldAcl.SetActionAllowedIf(db, userA, "conditional", true, `request.mfa`), ShouldBeNil)
+
+		allowed, err = oldAcl.AllowsActionWith(context.Background(), db, userA, "conditional", Attributes{"request.mfa": "true"})
+		So(err, ShouldBeNil)
+		So(allowed, ShouldEqual, true)
+
+		So(oldAcl.SetActionAllowedUntil(db, userA, "testing", true, time.Now().Add(-time.Hour)), ShouldBeNil)
+
+		allowed, err = oldAcl.AllowsAction(db, userA, "testing")
+		So(err, ShouldBeNil)
+		So(allowed, ShouldEqual, false)
+	})
+
+	Convey("EnsureSQLiteTargetTreeExists() should create the target tree", t, func() {
+		err := EnsureSQLiteTargetTreeExists(db, "ACL_TestTargetTree", "ACL_Test", nil)
+		So(err, ShouldBeNil)
+
+		Convey("And should not raise an error when it already exists", func() {
+			err := EnsureSQLiteTargetTreeExists(db, "ACL_TestTargetTree", "ACL_Test", nil)
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Edges should be set like in the other stores", t, withSQLiteTransaction(db, func(tx *sql.Tx) {
+		testTreeEdges(acl, tx, userA, userB)
+	}))
+
+	Convey("Candidates() should list the ancestors like in the other stores", t, withSQLiteTransaction(db, func(tx *sql.Tx) {
+		testCandidateAncestors(acl, tx, userA, userB, userC)
+	}))
+
+	Convey("Target tree edges should be recorded like in the other stores", t, withSQLiteTransaction(db, func(tx *sql.Tx) {
+		testTargetTreeAudit(targetAcl, tx, testResourceA, testResourceB, userB)
+	}))
+
+	Convey("EnsureSQLiteTargetTreeExists() should replace the triggers of an earlier version", t, func() {
+		So(EnsureSQLiteTargetTreeExists(db, "ACL_StaleTree", "ACL_Test", nil), ShouldBeNil)
+
+		_, err := db.Exec(`DROP TRIGGER "ACL_StaleTree_PreventCycles"`)
+		So(err, ShouldBeNil)
+		_, err = db.Exec(`CREATE TRIGGER "ACL_StaleTree_PreventCycles" BEFORE INSERT ON "ACL_StaleTree" FOR EACH ROW BEGIN SELECT 1; END;`)
+		So(err, ShouldBeNil)
+
+		So(EnsureSQLiteTargetTreeExists(db, "ACL_StaleTree", "ACL_Test", nil), ShouldBeNil)
+
+		_, err = db.Exec(`INSERT INTO "ACL_StaleTree" ("id", "parent_id") VALUES (?, ?)`, testResourceA.GetId(), testResourceA.GetId())
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("When A inherits from B and B inherits from C", t, withSQLiteTransaction(db, func(tx *sql.Tx) {
+		So(acl.SetActorInherits(tx, userA, userB), ShouldBeNil)
+		So(acl.SetActorInherits(tx, userB, userC), ShouldBeNil)
+
+		Convey("Setting an already set relation should not error", func() {
+			So(acl.SetActorInherits(tx, userA, userB), ShouldBeNil)
+
+			parents, err := acl.GetActorInherits(tx, userA)
+			So(err, ShouldBeNil)
+			So(parents, ShouldResemble, []string{userB.GetId()})
+		})
+
+		Convey("Ancestors() should list A along with B and C", func() {
+			ancestors, err := NewSQLiteStore("ACL_TestTree", "ACL_Test").Ancestors(context.Background(), tx, userA.GetId())
+			So(err, ShouldBeNil)
+			So(len(ancestors), ShouldEqual, 3)
+			So(ancestors[0], ShouldEqual, userA.GetId())
+			So(ancestors, ShouldContain, userB.GetId())
+			So(ancestors, ShouldContain, userC.GetId())
+		})
+
+		Convey("Attempting to establish C -> A should return an error", func() {
+			err := acl.SetActorInherits(tx, userC, userA)
+			So(errors.Is(err, ErrCycle), ShouldEqual, true)
+		})
+
+		Convey("Establishing C -> A should work after removing B -> C", func() {
+			So(acl.RemoveActorInherits(tx, userB, userC), ShouldBeNil)
+			So(acl.SetActorInherits(tx, userC, userA), ShouldBeNil)
+
+			children, err := acl.GetActorChildren(tx, userA)
+			So(err, ShouldBeNil)
+			So(children, ShouldResemble, []string{userC.GetId()})
+		})
+
+		Convey("SetActionAllowed(true) on C should allow A", func() {
+			So(acl.SetActionAllowed(tx, userC, "testing", true), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(tx, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			Convey("And Inheritors() should list the candidates of A, B and C at once", func() {
+				inheritors, err := NewSQLiteStore("ACL_TestTree", "ACL_Test").Inheritors(context.Background(), tx, Query{Actions: []string{"testing"}})
+				So(err, ShouldBeNil)
+				So(len(inheritors), ShouldEqual, 3)
+				So(inheritors[0].ActorId, ShouldEqual, userA.GetId())
+				So(inheritors[0].Leaf, ShouldEqual, true)
+				So(len(inheritors[0].Candidates), ShouldEqual, 1)
+				So(inheritors[0].Candidates[0].Level, ShouldEqual, 2)
+				So(inheritors[0].Candidates[0].Path, ShouldResemble, []string{userA.GetId(), userB.GetId(), userC.GetId()})
+				So(inheritors[1].ActorId, ShouldEqual, userB.GetId())
+				So(inheritors[1].Leaf, ShouldEqual, false)
+				So(inheritors[2].ActorId, ShouldEqual, userC.GetId())
+				So(inheritors[2].Candidates[0].Level, ShouldEqual, 0)
+
+				actors, err := acl.ListActors(context.Background(), tx, testResourceA, "testing", true)
+				So(err, ShouldBeNil)
+				So(actors, ShouldResemble, []string{userA.GetId()})
+			})
+
+			Convey("And SetActionAllowedOn(false) on B should disable A on resource", func() {
+				So(acl.SetActionAllowedOn(tx, userB, "testing", testResourceA, false), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(tx, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+
+				allowed, err = acl.AllowsAction(tx, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+
+				decision, err := acl.ExplainActionOn(context.Background(), tx, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(decision.Source.ActorId, ShouldEqual, userB.GetId())
+				So(decision.Source.Path, ShouldResemble, []string{userA.GetId(), userB.GetId()})
+				So(len(decision.Shadowed), ShouldEqual, 1)
+				So(decision.Shadowed[0].Path, ShouldResemble, []string{userA.GetId(), userB.GetId(), userC.GetId()})
+
+				many, err := acl.AllowsActionOnMany(context.Background(), tx, userA, "testing", []Resource{testResourceA, userB})
+				So(err, ShouldBeNil)
+				So(many, ShouldResemble, map[string]bool{testResourceA.GetId(): false, userB.GetId(): true})
+
+				actors, err := acl.ListActors(context.Background(), tx, testResourceA, "testing", false)
+				So(err, ShouldBeNil)
+				So(actors, ShouldResemble, []string{userC.GetId()})
+			})
+
+			Convey("And SetActionAllowed(false) then SetActionAllowed(true) on A should allow A", func() {
+				So(acl.SetActionAllowed(tx, userA, "testing", false), ShouldBeNil)
+				So(acl.SetActionAllowed(tx, userA, "testing", true), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(tx, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("And UnsetActionAllowed() on C should disable A", func() {
+				So(acl.UnsetActionAllowed(tx, userC, "testing"), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(tx, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+		})
+
+		Convey("AssignRole() on C should allow A the actions of the role", func() {
+			So(acl.SetRoleActions(tx, "editor", []string{"view", "edit"}), ShouldBeNil)
+			So(acl.AssignRole(tx, userC, "editor", testResourceA), ShouldBeNil)
+			So(acl.AssignRole(tx, userC, "editor", testResourceA), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOn(tx, userA, "edit", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			Convey("And a direct deny on C should take precedence over the role", func() {
+				So(acl.SetActionAllowedOn(tx, userC, "edit", testResourceA, false), ShouldBeNil)
+
+				decision, err := acl.ExplainActionOn(context.Background(), tx, userA, "edit", testResourceA)
+				So(err, ShouldBeNil)
+				So(decision.Allowed, ShouldEqual, false)
+				So(decision.Shadowed[0].Role, ShouldEqual, "editor")
+			})
+
+			Convey("And SetRoleActions() without edit should disable A", func() {
+				So(acl.SetRoleActions(tx, "editor", []string{"view"}), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(tx, userA, "edit", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("And RevokeRole() should disable A", func() {
+				So(acl.RevokeRole(tx, userC, "editor", testResourceA), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(tx, userA, "view", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+		})
+
+		Convey("SetActorInheritsUntil() in the past should stop A from inheriting from B", func() {
+			So(acl.SetActionAllowed(tx, userB, "testing", true), ShouldBeNil)
+			So(acl.SetActorInheritsUntil(tx, userA, userB, time.Now().Add(-time.Hour)), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(tx, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			Convey("And SetActorInherits() should keep the window", func() {
+				So(acl.SetActorInherits(tx, userA, userB), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(tx, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("And SetActorInheritsUntil() in the future should restore it", func() {
+				So(acl.SetActorInheritsUntil(tx, userA, userB, time.Now().Add(time.Hour)), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(tx, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("And RemoveExpired() should remove the edge and the expired settings", func() {
+				So(acl.SetActionAllowedOnUntil(tx, userC, "testing", testResourceA, false, time.Now().Add(-time.Minute)), ShouldBeNil)
+				So(acl.SetActionAllowedOnUntil(tx, userC, "other", testResourceA, false, time.Now().Add(time.Minute)), ShouldBeNil)
+
+				n, err := acl.RemoveExpired(context.Background(), tx)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, int64(2))
+
+				parents, err := acl.GetActorInherits(tx, userA)
+				So(err, ShouldBeNil)
+				So(len(parents), ShouldEqual, 0)
+
+				So(acl.SetActorInherits(tx, userC, userA), ShouldBeNil)
+			})
+		})
+
+		Convey("SetActionAllowedBetween() should only apply within the window", func() {
+			So(acl.SetActionAllowedBetween(tx, userA, "testing", true, time.Now().Add(time.Hour), time.Time{}), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(tx, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			So(acl.SetActionAllowedBetween(tx, userA, "testing", true, time.Now().Add(-time.Hour), time.Now().Add(time.Hour)), ShouldBeNil)
+
+			allowed, err = acl.AllowsAction(tx, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+
+		Convey("SetActionAllowedOnIf() on C should only allow A when the condition holds", func() {
+			So(acl.SetActionAllowedOnIf(tx, userC, "edit", testResourceA, true, `target.owner == actor.id`), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOnWith(context.Background(), tx, userA, "edit", testResourceA, Attributes{"target.owner": userA.GetId()})
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionOnWith(context.Background(), tx, userA, "edit", testResourceA, Attributes{"target.owner": userB.GetId()})
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			Convey("And SetActionAllowedOn() should keep the condition", func() {
+				So(acl.SetActionAllowedOn(tx, userC, "edit", testResourceA, true), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOnWith(context.Background(), tx, userA, "edit", testResourceA, Attributes{"target.owner": userB.GetId()})
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+		})
+
+		Convey("AllowsActionAt() should use the state recorded at the time", func() {
+			So(acl.SetActionAllowed(tx, userC, "testing", true), ShouldBeNil)
+
+			/* SQLite records the time in milliseconds */
+			time.Sleep(5 * time.Millisecond)
+			allowedAt := time.Now()
+			time.Sleep(5 * time.Millisecond)
+
+			So(acl.RemoveActorInherits(tx, userB, userC), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionAt(tx, userA, "testing", allowedAt)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionAt(tx, userA, "testing", time.Now())
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			So(acl.SetActionAllowed(tx, userC, "testing", false), ShouldBeNil)
+			So(acl.SetActorInherits(tx, userB, userC), ShouldBeNil)
+
+			allowed, err = acl.AllowsActionAt(tx, userA, "testing", allowedAt)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+
+		Convey("Changes should be recorded in the audit log", func() {
+			start := time.Now().Add(-time.Second)
+
+			So(acl.SetActionAllowedContext(WithChangedBy(context.Background(), userB), tx, userC, "testing", true), ShouldBeNil)
+			So(acl.SetActionAllowed(tx, userC, "testing", false), ShouldBeNil)
+			So(acl.UnsetActionAllowed(tx, userC, "testing"), ShouldBeNil)
+
+			entries, err := acl.AuditLog(context.Background(), tx, AuditQuery{ActorId: userC.GetId(), Action: "testing", From: start})
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 3)
+			So(entries[0].Kind, ShouldEqual, AuditGrant)
+			So(entries[0].Operation, ShouldEqual, "INSERT")
+			So(entries[0].ChangedBy, ShouldEqual, userB.GetId())
+			So(entries[0].Old, ShouldBeNil)
+			So(entries[0].New["allowed"], ShouldEqual, true)
+			So(entries[1].Operation, ShouldEqual, "UPDATE")
+			So(entries[1].ChangedBy, ShouldEqual, "")
+			So(entries[1].New["allowed"], ShouldEqual, false)
+			So(entries[2].Operation, ShouldEqual, "DELETE")
+			So(entries[2].New, ShouldBeNil)
+
+			entries, err = acl.AuditLog(context.Background(), tx, AuditQuery{ActorId: userA.GetId(), Limit: 1})
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Kind, ShouldEqual, AuditInheritance)
+			So(entries[0].New["parent_id"], ShouldEqual, userB.GetId())
+
+			Convey("And the audit log should be append-only", func() {
+				_, err := tx.Exec(`DELETE FROM "ACL_Test_Audit"`)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("DELETE on an actor row should remove the corresponding relations and ACL entries", func() {
+			_, err := tx.Exec(`INSERT INTO "ACLTestActors" ("id") VALUES (?)`, userB.GetId())
+			So(err, ShouldBeNil)
+
+			So(acl.SetActionAllowed(tx, userB, "testing", true), ShouldBeNil)
+
+			_, err = tx.Exec(`DELETE FROM "ACLTestActors" WHERE "id" = ?`, userB.GetId())
+			So(err, ShouldBeNil)
+
+			parents, err := acl.GetActorInherits(tx, userA)
+			So(err, ShouldBeNil)
+			So(len(parents), ShouldEqual, 0)
+
+			allowed, err := acl.AllowsAction(tx, userB, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			So(acl.SetActorInherits(tx, userC, userA), ShouldBeNil)
+		})
+	}))
+
+	Convey("When a target inherits from a folder which inherits from a drive", t, withSQLiteTransaction(db, func(tx *sql.Tx) {
+		_, err := tx.Exec(`DELETE FROM "ACL_TestTargetTree";`)
+		So(err, ShouldBeNil)
+
+		drive := idAble{id: "d0000000-0000-4000-8000-000000000001"}
+		folder := idAble{id: "d0000000-0000-4000-8000-000000000002"}
+
+		So(targetAcl.SetTargetInherits(tx, testResourceA, folder), ShouldBeNil)
+		So(targetAcl.SetTargetInherits(tx, folder, drive), ShouldBeNil)
+
+		Convey("Attempting to establish drive -> target should return an error", func() {
+			err := targetAcl.SetTargetInherits(tx, drive, testResourceA)
+			So(errors.Is(err, ErrCycle), ShouldEqual, true)
+		})
+
+		Convey("The store without target tree should refuse target relations", func() {
+			So(acl.SetTargetInherits(tx, folder, drive), ShouldNotBeNil)
+		})
+
+		Convey("TargetDescendants() should page through the drive and everything in it", func() {
+			store := NewSQLiteStoreWithTargetTree("ACL_TestTree", "ACL_TestTargetTree", "ACL_Test")
+
+			levels, err := store.TargetDescendants(context.Background(), tx, []string{drive.GetId()}, Page{Limit: 2})
+			So(err, ShouldBeNil)
+			So(levels, ShouldResemble, map[string]map[string]int{
+				drive.GetId():  {drive.GetId(): 0},
+				folder.GetId(): {drive.GetId(): 1},
+			})
+
+			levels, err = store.TargetDescendants(context.Background(), tx, []string{drive.GetId(), folder.GetId()}, Page{After: folder.GetId()})
+			So(err, ShouldBeNil)
+			So(levels, ShouldResemble, map[string]map[string]int{
+				testResourceA.GetId(): {drive.GetId(): 2, folder.GetId(): 1},
+			})
+		})
+
+		Convey("SetActionAllowedOn(true) on the drive should allow the target", func() {
+			So(targetAcl.SetActionAllowedOn(tx, userA, "testing", drive, true), ShouldBeNil)
+
+			allowed, err := targetAcl.AllowsActionOn(tx, userA, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			allowed, err = acl.AllowsActionOn(tx, userA, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			Convey("And SetActionAllowedOn(false) on the folder should win as the nearer target", func() {
+				So(targetAcl.SetActionAllowedOn(tx, userA, "testing", folder, false), ShouldBeNil)
+
+				allowed, err := targetAcl.AllowsActionOn(tx, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+
+				Convey("And AllowsActionOnMany() should resolve the target tree along with the candidates", func() {
+					many, err := targetAcl.AllowsActionOnMany(context.Background(), tx, userA, "testing", []Resource{testResourceA, folder, drive, userB})
+					So(err, ShouldBeNil)
+					So(many, ShouldResemble, map[string]bool{
+						testResourceA.GetId(): false,
+						folder.GetId():        false,
+						drive.GetId():         true,
+						userB.GetId():         false,
+					})
+
+					candidates, levels, err := NewSQLiteStoreWithTargetTree("ACL_TestTree", "ACL_TestTargetTree", "ACL_Test").TargetCandidates(context.Background(), tx, Query{ActorId: userA.GetId(), TargetIds: []string{testResourceA.GetId()}})
+					So(err, ShouldBeNil)
+					So(len(candidates), ShouldEqual, 2)
+					So(levels[testResourceA.GetId()], ShouldResemble, map[string]int{drive.GetId(): 2, folder.GetId(): 1})
+				})
+			})
+
+			Convey("And a wildcard deny on the folder should win over the drive", func() {
+				So(targetAcl.SetActionAllowedOn(tx, userA, "*", folder, false), ShouldBeNil)
+
+				allowed, err := targetAcl.AllowsActionOn(tx, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("And ListTargets() should include the inheriting targets", func() {
+				list, err := targetAcl.ListTargets(context.Background(), tx, userA, "testing", Page{})
+				So(err, ShouldBeNil)
+				So(list.Ids, ShouldResemble, []string{drive.GetId(), folder.GetId(), testResourceA.GetId()})
+			})
+		})
+	}))
+}
+
+func withSQLiteTransaction(db *sql.DB, f func(tx *sql.Tx)) func() {
+	return func() {
+		tx, err := db.Begin()
+		So(err, ShouldBeNil)
+
+		/* Clear tables used in tests, SQLite lacks TRUNCATE */
+		_, err = tx.Exec(`DELETE FROM "ACL_Test";`)
+		So(err, ShouldBeNil)
+		_, err = tx.Exec(`DELETE FROM "ACL_TestTree";`)
+		So(err, ShouldBeNil)
+		_, err = tx.Exec(`DELETE FROM "ACL_Test_Roles";`)
+		So(err, ShouldBeNil)
+		_, err = tx.Exec(`DELETE FROM "ACL_Test_RoleAssignments";`)
+		So(err, ShouldBeNil)
+
+		Reset(func() {
+			So(tx.Rollback(), ShouldBeNil)
+		})
+
+		f(tx)
+	}
+}
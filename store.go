@@ -0,0 +1,274 @@
+package acl
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// errNoTargetTree is returned by the SQL stores when modifying the target tree
+// without a target tree table
+var errNoTargetTree = errors.New("acl: no target tree table is configured")
+
+// Grant is a row in the ACL table, stating if the actor is allowed to perform
+// the action on the target, EMPTY_RESOURCE as target means any target. Role is
+// set when the grant comes from a role assigned to the actor, such grants
+// always allow. ValidFrom and ValidUntil limit when the grant applies, a zero
+// time leaves that end open, they are only used when setting a grant.
+// Condition is an expression which must hold for the grant to apply, see
+// SetActionAllowedOnIf
+type Grant struct {
+	ActorId    string
+	Action     string
+	TargetId   string
+	Allowed    bool
+	Role       string
+	ValidFrom  time.Time
+	ValidUntil time.Time
+	Condition  string
+}
+
+// scoped returns true if the grant has a window or a condition, the plain
+// setters keep the window and the condition of an existing grant so they do
+// not turn a time-bounded or conditional grant into a permanent one
+func (g Grant) scoped() bool {
+	return !g.ValidFrom.IsZero() || !g.ValidUntil.IsZero() || g.Condition != ""
+}
+
+// Candidate is a Grant which applies to a permission check, Level is the
+// distance in the tree from the checked actor to the actor of the Grant,
+// 0 being the checked actor itself. Path lists the ids from the checked actor
+// to the actor of the Grant, both included. TargetLevel is the distance in the
+// target tree from the checked target to the target of the Grant, and
+// ActionLevel is 0 for the checked action and increases for less specific
+// wildcard patterns, both are set by ACL when resolving and not by the Store
+type Candidate struct {
+	Grant
+	Level       int
+	Path        []string
+	TargetLevel int
+	ActionLevel int
+}
+
+// Inheritor is an actor listed by Inheritors, Leaf is true if no actor
+// inherits from it, including edges outside of their window
+type Inheritor struct {
+	ActorId    string
+	Leaf       bool
+	Candidates []Candidate
+}
+
+// Query selects the candidates for a permission check, a nil Actions or
+// TargetIds matches any action or target respectively. Grants and actor tree
+// edges which are not valid at At are skipped, a zero At ignores the validity.
+// A non-zero AsOf selects the rows as they were recorded in the history
+// tables at that time instead of the current rows, see AllowsActionOnAt.
+// Ancestors adds the actor and its ancestors to the result of Candidates, see
+// Cache
+type Query struct {
+	ActorId   string
+	Actions   []string
+	TargetIds []string
+	At        time.Time
+	AsOf      time.Time
+	Ancestors bool
+}
+
+// Store is the storage of grants and the inheritance trees of actors and
+// targets used by ACL, the resolution of which candidate decides a permission check is made by
+// ACL so that all stores share the same rules
+type Store interface {
+	// SetGrant inserts the grant or replaces the allowed flag of an existing
+	// one. The window and the condition of an existing grant are only replaced
+	// when the grant has a window or a condition
+	SetGrant(ctx context.Context, q Querier, grant Grant) error
+	// UnsetGrant removes the grant, if it exists
+	UnsetGrant(ctx context.Context, q Querier, actorId string, action string, targetId string) error
+	// SetParent makes id inherit from parentId between validFrom and validUntil,
+	// zero times leave the window open. The window of an existing edge is only
+	// replaced when one of the times is set. It must refuse to create cycles,
+	// regardless of the windows
+	SetParent(ctx context.Context, q Querier, id string, parentId string, validFrom time.Time, validUntil time.Time) error
+	// RemoveParent removes the inheritance from parentId, if it exists
+	RemoveParent(ctx context.Context, q Querier, id string, parentId string) error
+	// Parents lists the ids which id directly inherits from, including edges
+	// outside of their window
+	Parents(ctx context.Context, q Querier, id string) ([]string, error)
+	// Children lists the ids which directly inherit from id, including edges
+	// outside of their window
+	Children(ctx context.Context, q Querier, id string) ([]string, error)
+	// Ancestors lists id along with every id it inherits from, directly or
+	// through other ids and including edges outside of their window, using a
+	// single query
+	Ancestors(ctx context.Context, q Querier, id string) ([]string, error)
+	// RemoveExpired removes the grants and actor tree edges which are no longer
+	// valid at the given time, returning the number of removed rows
+	RemoveExpired(ctx context.Context, q Querier, at time.Time) (int64, error)
+	// Candidates lists the grants of the actor and all of its ancestors which
+	// match the query, including those from assigned roles, every grant
+	// appears once with its nearest level and the path of that level. With
+	// Query.Ancestors the actor and every ancestor reachable through edges
+	// valid at At are listed too, at least once, as a candidate with only
+	// ActorId, Level and Path set
+	Candidates(ctx context.Context, q Querier, query Query) ([]Candidate, error)
+	// TargetCandidates is like Candidates for the TargetIds of the query along
+	// with their ancestors in the target tree and EMPTY_RESOURCE, resolving
+	// the target tree in the same query. The levels map each of the TargetIds
+	// to its ancestors like TargetLevels, at least for those with candidates
+	TargetCandidates(ctx context.Context, q Querier, query Query) ([]Candidate, map[string]map[string]int, error)
+	// Inheritors lists the actors of the grants matching the query, ignoring
+	// ActorId, along with every actor inheriting from them, ordered by id.
+	// Each of them has the candidates Candidates would list for it, using a
+	// single query
+	Inheritors(ctx context.Context, q Querier, query Query) ([]Inheritor, error)
+	// Grants lists the grants matching the query without any inheritance,
+	// including those from assigned roles, an empty ActorId matches any actor
+	Grants(ctx context.Context, q Querier, query Query) ([]Grant, error)
+	// SetRoleActions replaces the actions of the role
+	SetRoleActions(ctx context.Context, q Querier, role string, actions []string) error
+	// AssignRole assigns the role to the actor on the target, if not already assigned
+	AssignRole(ctx context.Context, q Querier, actorId string, role string, targetId string) error
+	// RevokeRole removes the role from the actor on the target, if it is assigned
+	RevokeRole(ctx context.Context, q Querier, actorId string, role string, targetId string) error
+	// SetTargetParent makes the target id inherit from parentId, it must refuse
+	// to create cycles
+	SetTargetParent(ctx context.Context, q Querier, id string, parentId string) error
+	// RemoveTargetParent removes the target inheritance from parentId, if it exists
+	RemoveTargetParent(ctx context.Context, q Querier, id string, parentId string) error
+	// TargetDescendants maps the ids and every target inheriting from them,
+	// directly or through other targets, to those of the ids they are or
+	// inherit from and their nearest level. Only the targets of the page are
+	// included, in the order of their ids, using a single query
+	TargetDescendants(ctx context.Context, q Querier, ids []string, page Page) (map[string]map[string]int, error)
+	// TargetLevels maps each of the ids to its ancestors in the target tree and
+	// their nearest level, including the id itself on level 0
+	TargetLevels(ctx context.Context, q Querier, ids []string) (map[string]map[string]int, error)
+	// AuditLog lists the entries of the audit log matching the query ordered
+	// by id. The changes to grants, actor and target tree edges and roles made
+	// with a context from WithChangedBy must be recorded along with the actor
+	AuditLog(ctx context.Context, q Querier, query AuditQuery) ([]AuditEntry, error)
+}
+
+// sortCandidates orders the candidates by precedence: nearest level first, then
+// specific targets before EMPTY_RESOURCE, then nearest target level, then most
+// specific action, then rows in the ACL table before roles and last denies
+// before allows
+func sortCandidates(candidates []Candidate) {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+
+		if a.Level != b.Level {
+			return a.Level < b.Level
+		}
+
+		if (a.TargetId != EMPTY_RESOURCE) != (b.TargetId != EMPTY_RESOURCE) {
+			return a.TargetId != EMPTY_RESOURCE
+		}
+
+		if a.TargetLevel != b.TargetLevel {
+			return a.TargetLevel < b.TargetLevel
+		}
+
+		if a.ActionLevel != b.ActionLevel {
+			return a.ActionLevel < b.ActionLevel
+		}
+
+		if (a.Role == "") != (b.Role == "") {
+			return a.Role == ""
+		}
+
+		return !a.Allowed && b.Allowed
+	})
+}
+
+// validAt returns true if at is within the window from validFrom to
+// validUntil, validUntil excluded, zero times leave the window open and a
+// zero at is always within the window
+func validAt(validFrom time.Time, validUntil time.Time, at time.Time) bool {
+	if at.IsZero() {
+		return true
+	}
+
+	return (validFrom.IsZero() || !validFrom.After(at)) && (validUntil.IsZero() || validUntil.After(at))
+}
+
+// historyWhere returns an SQL condition matching the rows of a history table
+// recorded at the time in the literal p, see AllowsActionOnAt
+func historyWhere(p string) string {
+	return "(recorded_from IS NULL OR recorded_from <= " + p + ") AND (recorded_until IS NULL OR recorded_until > " + p + ")"
+}
+
+// validWhere returns an SQL condition matching the rows of the alias which
+// are valid at the time in the placeholder p, see validAt
+func validWhere(alias string, p string) string {
+	return "(" + alias + ".valid_from IS NULL OR " + alias + ".valid_from <= " + p + ") AND (" + alias + ".valid_until IS NULL OR " + alias + ".valid_until > " + p + ")"
+}
+
+// nullTime returns nil for a zero time, to be stored as NULL
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t
+}
+
+// targetLevels returns the levels of the ids on their own, used by stores
+// without a target tree
+func targetLevels(ids []string) map[string]map[string]int {
+	ret := make(map[string]map[string]int, len(ids))
+
+	for _, id := range ids {
+		ret[id] = map[string]int{id: 0}
+	}
+
+	return ret
+}
+
+// pageTargetLevels keeps the levels of the targets on the page
+func pageTargetLevels(levels map[string]map[string]int, page Page) map[string]map[string]int {
+	ids := make([]string, 0, len(levels))
+
+	for id := range levels {
+		ids = append(ids, id)
+	}
+
+	ids, _ = paginate(ids, page)
+	ret := make(map[string]map[string]int, len(ids))
+
+	for _, id := range ids {
+		ret[id] = levels[id]
+	}
+
+	return ret
+}
+
+// queryTargetLevels runs a query selecting the start id, an ancestor id and
+// its level, adding them to the levels of the ids
+func queryTargetLevels(ctx context.Context, q Querier, ids []string, query string, args ...interface{}) (map[string]map[string]int, error) {
+	ret := targetLevels(ids)
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		startId, ancestorId, level := "", "", 0
+
+		if err := rows.Scan(&startId, &ancestorId, &level); err != nil {
+			return nil, err
+		}
+
+		if ret[startId] == nil {
+			ret[startId] = make(map[string]int)
+		}
+
+		ret[startId][ancestorId] = level
+	}
+
+	return ret, rows.Err()
+}
@@ -0,0 +1,118 @@
+package acl
+
+import (
+	"sort"
+)
+
+// Strategy resolves the conflicts between the rows applying to a check. The
+// candidates are never empty and are given in the order of precedence used by
+// NearestWins, see ACL.WithStrategy
+type Strategy interface {
+	Resolve(candidates []Candidate) Decision
+}
+
+var (
+	// NearestWins is the default strategy, the row on the nearest level in the
+	// tree decides, then a specific target wins over EMPTY_RESOURCE, then the
+	// nearest target level, then the most specific action and last a deny
+	// wins over an allow
+	NearestWins Strategy = nearestWins{}
+	// DenyOverrides denies if any row applying to the check denies, anywhere
+	// in the ancestry, the deny with the highest precedence decides
+	DenyOverrides Strategy = denyOverrides{}
+	// AllowOverrides allows if any row applying to the check allows, anywhere
+	// in the ancestry, the allow with the highest precedence decides
+	AllowOverrides Strategy = allowOverrides{}
+	// FirstApplicable ranks how specifically a row matches the check before
+	// its level in the tree, the first row decides. A specific target wins
+	// over EMPTY_RESOURCE, then the nearest target level, then the most
+	// specific action and only then the nearest level in the tree, a deny
+	// winning ties
+	FirstApplicable Strategy = firstApplicable{}
+)
+
+// WithStrategy returns a copy of the ACL which resolves the conflicts between
+// rows using the strategy, every check, listing and explanation uses it. A nil
+// strategy restores NearestWins
+func (acl *ACL) WithStrategy(strategy Strategy) *ACL {
+	ret := *acl
+	ret.strategy = strategy
+
+	return &ret
+}
+
+type nearestWins struct{}
+
+func (nearestWins) Resolve(candidates []Candidate) Decision {
+	return decisionFrom(candidates, 0)
+}
+
+type denyOverrides struct{}
+
+func (denyOverrides) Resolve(candidates []Candidate) Decision {
+	for i, c := range candidates {
+		if !c.Allowed {
+			return decisionFrom(candidates, i)
+		}
+	}
+
+	return decisionFrom(candidates, 0)
+}
+
+type allowOverrides struct{}
+
+func (allowOverrides) Resolve(candidates []Candidate) Decision {
+	for i, c := range candidates {
+		if c.Allowed {
+			return decisionFrom(candidates, i)
+		}
+	}
+
+	return decisionFrom(candidates, 0)
+}
+
+type firstApplicable struct{}
+
+func (firstApplicable) Resolve(candidates []Candidate) Decision {
+	ordered := make([]Candidate, len(candidates))
+	copy(ordered, candidates)
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+
+		if (a.TargetId != EMPTY_RESOURCE) != (b.TargetId != EMPTY_RESOURCE) {
+			return a.TargetId != EMPTY_RESOURCE
+		}
+
+		if a.TargetLevel != b.TargetLevel {
+			return a.TargetLevel < b.TargetLevel
+		}
+
+		if a.ActionLevel != b.ActionLevel {
+			return a.ActionLevel < b.ActionLevel
+		}
+
+		if a.Level != b.Level {
+			return a.Level < b.Level
+		}
+
+		if (a.Role == "") != (b.Role == "") {
+			return a.Role == ""
+		}
+
+		return !a.Allowed && b.Allowed
+	})
+
+	return decisionFrom(ordered, 0)
+}
+
+// decisionFrom returns the decision of the candidate at index i, shadowing
+// the other candidates in their order
+func decisionFrom(candidates []Candidate, i int) Decision {
+	source := candidates[i]
+	shadowed := make([]Candidate, 0, len(candidates)-1)
+	shadowed = append(shadowed, candidates[:i]...)
+	shadowed = append(shadowed, candidates[i+1:]...)
+
+	return Decision{Allowed: source.Allowed, Source: &source, Shadowed: shadowed}
+}
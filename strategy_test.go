@@ -0,0 +1,125 @@
+package acl
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestStrategy(t *testing.T) {
+	ctx := context.Background()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+	userC := idAble{id: "7be24c16-6376-478d-91c9-f879116d1d49"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+	testResourceB := idAble{id: "b74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	Convey("When A inherits from B and B inherits from C", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+		So(acl.SetActorInherits(nil, userB, userC), ShouldBeNil)
+
+		Convey("And C denies while A allows", func() {
+			So(acl.SetActionAllowed(nil, userC, "testing", false), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userA, "testing", true), ShouldBeNil)
+
+			Convey("NearestWins should allow A", func() {
+				allowed, err := acl.WithStrategy(NearestWins).AllowsAction(nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("A nil strategy should behave like NearestWins", func() {
+				allowed, err := acl.WithStrategy(nil).AllowsAction(nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("DenyOverrides should deny A and explain it with the deny of C", func() {
+				denyAcl := acl.WithStrategy(DenyOverrides)
+
+				allowed, err := denyAcl.AllowsActionOn(nil, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+
+				decision, err := denyAcl.ExplainAction(ctx, nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(decision.Allowed, ShouldEqual, false)
+				So(decision.Source.ActorId, ShouldEqual, userC.GetId())
+				So(len(decision.Shadowed), ShouldEqual, 1)
+				So(decision.Shadowed[0].ActorId, ShouldEqual, userA.GetId())
+
+				Convey("And the original ACL should be unaffected", func() {
+					allowed, err := acl.AllowsAction(nil, userA, "testing")
+					So(err, ShouldBeNil)
+					So(allowed, ShouldEqual, true)
+				})
+			})
+
+			Convey("DenyOverrides should apply to the listings", func() {
+				denyAcl := acl.WithStrategy(DenyOverrides)
+
+				list, err := denyAcl.ListTargets(ctx, nil, userA, "testing", Page{})
+				So(err, ShouldBeNil)
+				So(list.All, ShouldEqual, false)
+
+				actors, err := denyAcl.ListActors(ctx, nil, testResourceA, "testing", false)
+				So(err, ShouldBeNil)
+				So(len(actors), ShouldEqual, 0)
+
+				results, err := denyAcl.AllowsActionOnMany(ctx, nil, userA, "testing", []Resource{testResourceA, testResourceB})
+				So(err, ShouldBeNil)
+				So(results, ShouldResemble, map[string]bool{testResourceA.GetId(): false, testResourceB.GetId(): false})
+			})
+		})
+
+		Convey("And C allows while A denies", func() {
+			So(acl.SetActionAllowed(nil, userC, "testing", true), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userA, "testing", false), ShouldBeNil)
+
+			Convey("NearestWins should deny A", func() {
+				allowed, err := acl.AllowsAction(nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("AllowOverrides should allow A", func() {
+				allowed, err := acl.WithStrategy(AllowOverrides).AllowsAction(nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+		})
+
+		Convey("And A denies while C allows on a specific target", func() {
+			So(acl.SetActionAllowed(nil, userA, "testing", false), ShouldBeNil)
+			So(acl.SetActionAllowedOn(nil, userC, "testing", testResourceA, true), ShouldBeNil)
+
+			Convey("NearestWins should deny A on the target", func() {
+				allowed, err := acl.AllowsActionOn(nil, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("FirstApplicable should allow A on the target only", func() {
+				firstAcl := acl.WithStrategy(FirstApplicable)
+
+				allowed, err := firstAcl.AllowsActionOn(nil, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+
+				allowed, err = firstAcl.AllowsActionOn(nil, userA, "testing", testResourceB)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+
+				decision, err := firstAcl.ExplainActionOn(ctx, nil, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(decision.Source.ActorId, ShouldEqual, userC.GetId())
+				So(decision.Shadowed[0].ActorId, ShouldEqual, userA.GetId())
+			})
+		})
+	})
+}
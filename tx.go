@@ -0,0 +1,183 @@
+package acl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// TxAuthorizer memoizes the checks made within a transaction, forgetting them
+// whenever the transaction changes the ACL through it so later checks see the
+// changes. Changes made to the ACL in the transaction by other means require
+// a call to Invalidate. The checks accept either nil or the transaction of the
+// TxAuthorizer, any other Querier is refused
+type TxAuthorizer struct {
+	acl *ACL
+	tx  *sql.Tx
+
+	mu         sync.Mutex
+	decisions  map[cacheKey]bool
+	generation uint64
+}
+
+var (
+	_ ActionAuthorizer        = (*TxAuthorizer)(nil)
+	_ ContextActionAuthorizer = (*TxAuthorizer)(nil)
+)
+
+// errOtherTx is returned by the checks of a TxAuthorizer given another Querier
+// than its transaction
+var errOtherTx = errors.New("acl: the TxAuthorizer is bound to another transaction")
+
+// NewTxAuthorizer creates a TxAuthorizer checking the ACL within tx
+func NewTxAuthorizer(acl *ACL, tx *sql.Tx) *TxAuthorizer {
+	return &TxAuthorizer{acl: acl, tx: tx, decisions: make(map[cacheKey]bool)}
+}
+
+// Invalidate forgets every memoized check
+func (t *TxAuthorizer) Invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.generation++
+	t.decisions = make(map[cacheKey]bool)
+}
+
+// AllowsAction is like ACL.AllowsAction within the transaction, q must be
+// nil or the transaction of the TxAuthorizer
+func (t *TxAuthorizer) AllowsAction(q Querier, actor Resource, action string) (bool, error) {
+	return t.AllowsActionContext(context.Background(), q, actor, action)
+}
+
+// AllowsActionContext is like AllowsAction but uses the supplied context
+func (t *TxAuthorizer) AllowsActionContext(ctx context.Context, q Querier, actor Resource, action string) (bool, error) {
+	return t.check(ctx, q, actor, action, nil)
+}
+
+// AllowsActionOn is like ACL.AllowsActionOn within the transaction, q must
+// be nil or the transaction of the TxAuthorizer
+func (t *TxAuthorizer) AllowsActionOn(q Querier, actor Resource, action string, target Resource) (bool, error) {
+	return t.AllowsActionOnContext(context.Background(), q, actor, action, target)
+}
+
+// AllowsActionOnContext is like AllowsActionOn but uses the supplied context
+func (t *TxAuthorizer) AllowsActionOnContext(ctx context.Context, q Querier, actor Resource, action string, target Resource) (bool, error) {
+	return t.check(ctx, q, actor, action, target)
+}
+
+// check is like ACL.check using the memoized decisions
+func (t *TxAuthorizer) check(ctx context.Context, q Querier, actor Resource, action string, target Resource) (bool, error) {
+	if tx, ok := q.(*sql.Tx); q != nil && (!ok || tx != nil && tx != t.tx) {
+		return false, errOtherTx
+	}
+
+	decision, err := t.acl.check(actor, action, target, func(targetId string) (Decision, error) {
+		allowed, err := t.allows(ctx, actor.GetId(), action, targetId)
+
+		return Decision{Allowed: allowed}, err
+	})
+
+	return decision.Allowed, err
+}
+
+// allows returns the memoized decision, resolving it on the first check. The
+// lock is not held while resolving, a decision resolved while the checks were
+// invalidated is not memoized as it might predate the change
+func (t *TxAuthorizer) allows(ctx context.Context, actorId string, action string, targetId string) (bool, error) {
+	key := cacheKey{actorId, action, targetId}
+
+	t.mu.Lock()
+
+	if allowed, ok := t.decisions[key]; ok {
+		t.mu.Unlock()
+
+		return allowed, nil
+	}
+
+	generation := t.generation
+
+	t.mu.Unlock()
+
+	allowed, err := t.acl.allows(ctx, querier(t.tx), actorId, action, targetId)
+	if err != nil {
+		return false, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.generation == generation {
+		t.decisions[key] = allowed
+	}
+
+	return allowed, nil
+}
+
+// invalidateAfter forgets every memoized check once the change is made,
+// regardless of its outcome as a failed change can still have had effects
+func (t *TxAuthorizer) invalidateAfter(err error) error {
+	t.Invalidate()
+
+	return err
+}
+
+// SetActionAllowed is like ACL.SetActionAllowed within the transaction
+func (t *TxAuthorizer) SetActionAllowed(actor Resource, action string, allowed bool) error {
+	return t.SetActionAllowedContext(context.Background(), actor, action, allowed)
+}
+
+// SetActionAllowedContext is like SetActionAllowed but uses the supplied context
+func (t *TxAuthorizer) SetActionAllowedContext(ctx context.Context, actor Resource, action string, allowed bool) error {
+	return t.invalidateAfter(t.acl.SetActionAllowedContext(ctx, querier(t.tx), actor, action, allowed))
+}
+
+// UnsetActionAllowed is like ACL.UnsetActionAllowed within the transaction
+func (t *TxAuthorizer) UnsetActionAllowed(actor Resource, action string) error {
+	return t.UnsetActionAllowedContext(context.Background(), actor, action)
+}
+
+// UnsetActionAllowedContext is like UnsetActionAllowed but uses the supplied context
+func (t *TxAuthorizer) UnsetActionAllowedContext(ctx context.Context, actor Resource, action string) error {
+	return t.invalidateAfter(t.acl.UnsetActionAllowedContext(ctx, querier(t.tx), actor, action))
+}
+
+// SetActionAllowedOn is like ACL.SetActionAllowedOn within the transaction
+func (t *TxAuthorizer) SetActionAllowedOn(actor Resource, action string, target Resource, allowed bool) error {
+	return t.SetActionAllowedOnContext(context.Background(), actor, action, target, allowed)
+}
+
+// SetActionAllowedOnContext is like SetActionAllowedOn but uses the supplied context
+func (t *TxAuthorizer) SetActionAllowedOnContext(ctx context.Context, actor Resource, action string, target Resource, allowed bool) error {
+	return t.invalidateAfter(t.acl.SetActionAllowedOnContext(ctx, querier(t.tx), actor, action, target, allowed))
+}
+
+// UnsetActionAllowedOn is like ACL.UnsetActionAllowedOn within the transaction
+func (t *TxAuthorizer) UnsetActionAllowedOn(actor Resource, action string, target Resource) error {
+	return t.UnsetActionAllowedOnContext(context.Background(), actor, action, target)
+}
+
+// UnsetActionAllowedOnContext is like UnsetActionAllowedOn but uses the supplied context
+func (t *TxAuthorizer) UnsetActionAllowedOnContext(ctx context.Context, actor Resource, action string, target Resource) error {
+	return t.invalidateAfter(t.acl.UnsetActionAllowedOnContext(ctx, querier(t.tx), actor, action, target))
+}
+
+// SetActorInherits is like ACL.SetActorInherits within the transaction
+func (t *TxAuthorizer) SetActorInherits(actor Resource, parentActor Resource) error {
+	return t.SetActorInheritsContext(context.Background(), actor, parentActor)
+}
+
+// SetActorInheritsContext is like SetActorInherits but uses the supplied context
+func (t *TxAuthorizer) SetActorInheritsContext(ctx context.Context, actor Resource, parentActor Resource) error {
+	return t.invalidateAfter(t.acl.SetActorInheritsContext(ctx, querier(t.tx), actor, parentActor))
+}
+
+// RemoveActorInherits is like ACL.RemoveActorInherits within the transaction
+func (t *TxAuthorizer) RemoveActorInherits(actor Resource, parentActor Resource) error {
+	return t.RemoveActorInheritsContext(context.Background(), actor, parentActor)
+}
+
+// RemoveActorInheritsContext is like RemoveActorInherits but uses the supplied context
+func (t *TxAuthorizer) RemoveActorInheritsContext(ctx context.Context, actor Resource, parentActor Resource) error {
+	return t.invalidateAfter(t.acl.RemoveActorInheritsContext(ctx, querier(t.tx), actor, parentActor))
+}
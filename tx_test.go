@@ -0,0 +1,118 @@
+package acl
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestTxAuthorizer(t *testing.T) {
+	dir, err := os.MkdirTemp("", "acl-tx")
+	if err != nil {
+		panic(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "acl.db"))
+	if err != nil {
+		panic(err)
+	}
+
+	defer db.Close()
+
+	if err := EnsureSQLiteTablesAndRulesExist(db, "ACL_TestTree", "ACL_Test", Cascades{}); err != nil {
+		panic(err)
+	}
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "e74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	acl := NewWithStore(NewSQLiteStore("ACL_TestTree", "ACL_Test"), nil)
+
+	Convey("Within a transaction", t, withSQLiteTransaction(db, func(tx *sql.Tx) {
+		authorizer := NewTxAuthorizer(acl, tx)
+
+		allowed, err := authorizer.AllowsActionOn(tx, userA, "testing", testResourceA)
+		So(err, ShouldBeNil)
+		So(allowed, ShouldEqual, false)
+
+		Convey("Checks should be memoized", func() {
+			So(acl.SetActionAllowed(tx, userA, "testing", true), ShouldBeNil)
+
+			allowed, err := authorizer.AllowsActionOn(tx, userA, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			Convey("And Invalidate() should forget them", func() {
+				authorizer.Invalidate()
+
+				allowed, err := authorizer.AllowsActionOn(tx, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+		})
+
+		Convey("Checks with another transaction should be refused", func() {
+			other, err := db.Begin()
+			So(err, ShouldBeNil)
+
+			defer other.Rollback()
+
+			_, err = authorizer.AllowsAction(other, userA, "testing")
+			So(err, ShouldNotBeNil)
+
+			_, err = authorizer.AllowsActionContext(context.Background(), db, userA, "testing")
+			So(err, ShouldNotBeNil)
+
+			allowed, err := authorizer.AllowsActionContext(context.Background(), nil, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("SetActionAllowedOn() should be seen by later checks", func() {
+			So(authorizer.SetActionAllowedOn(userA, "testing", testResourceA, true), ShouldBeNil)
+
+			allowed, err := authorizer.AllowsActionOn(tx, userA, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			Convey("And UnsetActionAllowedOn() too", func() {
+				So(authorizer.UnsetActionAllowedOn(userA, "testing", testResourceA), ShouldBeNil)
+
+				allowed, err := authorizer.AllowsActionOn(tx, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+		})
+
+		Convey("SetActorInherits() should be seen by later checks", func() {
+			So(authorizer.SetActionAllowed(userB, "testing", true), ShouldBeNil)
+
+			allowed, err := authorizer.AllowsAction(tx, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			So(authorizer.SetActorInherits(userA, userB), ShouldBeNil)
+
+			allowed, err = authorizer.AllowsAction(tx, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+
+			Convey("And RemoveActorInherits() too", func() {
+				So(authorizer.RemoveActorInherits(userA, userB), ShouldBeNil)
+
+				allowed, err := authorizer.AllowsAction(tx, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+		})
+	}))
+}
@@ -0,0 +1,82 @@
+package acl
+
+import (
+	"context"
+	"time"
+)
+
+// SetActionAllowedUntil is like SetActionAllowed but the setting only applies
+// until the given time, after which checks ignore it
+func (acl *ACL) SetActionAllowedUntil(q Querier, actor Resource, action string, allowed bool, until time.Time) error {
+	return acl.SetActionAllowedUntilContext(context.Background(), q, actor, action, allowed, until)
+}
+
+// SetActionAllowedUntilContext is like SetActionAllowedUntil but uses the supplied context
+func (acl *ACL) SetActionAllowedUntilContext(ctx context.Context, q Querier, actor Resource, action string, allowed bool, until time.Time) error {
+	return acl.SetActionAllowedBetweenContext(ctx, q, actor, action, allowed, time.Time{}, until)
+}
+
+// SetActionAllowedBetween is like SetActionAllowed but the setting only
+// applies from the time from until the time until, a zero time leaves that end
+// of the window open
+func (acl *ACL) SetActionAllowedBetween(q Querier, actor Resource, action string, allowed bool, from time.Time, until time.Time) error {
+	return acl.SetActionAllowedBetweenContext(context.Background(), q, actor, action, allowed, from, until)
+}
+
+// SetActionAllowedBetweenContext is like SetActionAllowedBetween but uses the supplied context
+func (acl *ACL) SetActionAllowedBetweenContext(ctx context.Context, q Querier, actor Resource, action string, allowed bool, from time.Time, until time.Time) error {
+	return acl.store.SetGrant(ctx, q, Grant{ActorId: actor.GetId(), Action: action, TargetId: EMPTY_RESOURCE, Allowed: allowed, ValidFrom: from, ValidUntil: until})
+}
+
+// SetActionAllowedOnUntil is like SetActionAllowedOn but the setting only
+// applies until the given time, after which checks ignore it
+func (acl *ACL) SetActionAllowedOnUntil(q Querier, actor Resource, action string, target Resource, allowed bool, until time.Time) error {
+	return acl.SetActionAllowedOnUntilContext(context.Background(), q, actor, action, target, allowed, until)
+}
+
+// SetActionAllowedOnUntilContext is like SetActionAllowedOnUntil but uses the supplied context
+func (acl *ACL) SetActionAllowedOnUntilContext(ctx context.Context, q Querier, actor Resource, action string, target Resource, allowed bool, until time.Time) error {
+	return acl.SetActionAllowedOnBetweenContext(ctx, q, actor, action, target, allowed, time.Time{}, until)
+}
+
+// SetActionAllowedOnBetween is like SetActionAllowedOn but the setting only
+// applies from the time from until the time until, a zero time leaves that end
+// of the window open
+func (acl *ACL) SetActionAllowedOnBetween(q Querier, actor Resource, action string, target Resource, allowed bool, from time.Time, until time.Time) error {
+	return acl.SetActionAllowedOnBetweenContext(context.Background(), q, actor, action, target, allowed, from, until)
+}
+
+// SetActionAllowedOnBetweenContext is like SetActionAllowedOnBetween but uses the supplied context
+func (acl *ACL) SetActionAllowedOnBetweenContext(ctx context.Context, q Querier, actor Resource, action string, target Resource, allowed bool, from time.Time, until time.Time) error {
+	return acl.store.SetGrant(ctx, q, Grant{ActorId: actor.GetId(), Action: action, TargetId: target.GetId(), Allowed: allowed, ValidFrom: from, ValidUntil: until})
+}
+
+// SetActorInheritsUntil is like SetActorInherits but the inheritance only
+// applies until the given time, eg. for an on-call rotation
+func (acl *ACL) SetActorInheritsUntil(q Querier, actor Resource, parentActor Resource, until time.Time) error {
+	return acl.SetActorInheritsUntilContext(context.Background(), q, actor, parentActor, until)
+}
+
+// SetActorInheritsUntilContext is like SetActorInheritsUntil but uses the supplied context
+func (acl *ACL) SetActorInheritsUntilContext(ctx context.Context, q Querier, actor Resource, parentActor Resource, until time.Time) error {
+	return acl.SetActorInheritsBetweenContext(ctx, q, actor, parentActor, time.Time{}, until)
+}
+
+// SetActorInheritsBetween is like SetActorInherits but the inheritance only
+// applies from the time from until the time until, a zero time leaves that end
+// of the window open. Cycles are refused regardless of the windows
+func (acl *ACL) SetActorInheritsBetween(q Querier, actor Resource, parentActor Resource, from time.Time, until time.Time) error {
+	return acl.SetActorInheritsBetweenContext(context.Background(), q, actor, parentActor, from, until)
+}
+
+// SetActorInheritsBetweenContext is like SetActorInheritsBetween but uses the supplied context
+func (acl *ACL) SetActorInheritsBetweenContext(ctx context.Context, q Querier, actor Resource, parentActor Resource, from time.Time, until time.Time) error {
+	return acl.store.SetParent(ctx, q, actor.GetId(), parentActor.GetId(), from, until)
+}
+
+// RemoveExpired deletes the settings and actor inheritances which have
+// expired, returning how many were removed. Expired rows are already ignored
+// by the checks, this only keeps the tables from growing
+func (acl *ACL) RemoveExpired(ctx context.Context, q Querier) (int64, error) {
+	return acl.store.RemoveExpired(ctx, q, time.Now())
+}
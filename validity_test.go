@@ -0,0 +1,151 @@
+package acl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidity(t *testing.T) {
+	ctx := context.Background()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	Convey("validAt() should treat zero times as open ends", t, func() {
+		So(validAt(time.Time{}, time.Time{}, past), ShouldEqual, true)
+		So(validAt(past, time.Time{}, future), ShouldEqual, true)
+		So(validAt(time.Time{}, past, future), ShouldEqual, false)
+		So(validAt(future, time.Time{}, past), ShouldEqual, false)
+		So(validAt(past, future, past), ShouldEqual, true)
+		So(validAt(past, future, future), ShouldEqual, false)
+		So(validAt(future, past, time.Time{}), ShouldEqual, true)
+	})
+
+	Convey("With an empty MemoryStore", t, func() {
+		acl := NewMemory()
+
+		Convey("SetActionAllowedOnUntil() in the future should allow A", func() {
+			So(acl.SetActionAllowedOnUntil(nil, userA, "testing", testResourceA, true, future), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOn(nil, userA, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, true)
+		})
+
+		Convey("SetActionAllowedOnUntil() in the past should be ignored", func() {
+			So(acl.SetActionAllowedOnUntil(nil, userA, "testing", testResourceA, true, past), ShouldBeNil)
+
+			allowed, err := acl.AllowsActionOn(nil, userA, "testing", testResourceA)
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+
+			Convey("And SetActionAllowedOn() should keep the window", func() {
+				So(acl.SetActionAllowedOn(nil, userA, "testing", testResourceA, true), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(nil, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+
+			Convey("And SetActionAllowedOnUntil() in the future should replace the window", func() {
+				So(acl.SetActionAllowedOnUntil(nil, userA, "testing", testResourceA, true, future), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(nil, userA, "testing", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+		})
+
+		Convey("SetActionAllowedBetween() starting in the future should be ignored", func() {
+			So(acl.SetActionAllowedBetween(nil, userA, "testing", true, future, time.Time{}), ShouldBeNil)
+
+			allowed, err := acl.AllowsAction(nil, userA, "testing")
+			So(err, ShouldBeNil)
+			So(allowed, ShouldEqual, false)
+		})
+
+		Convey("An expired deny should not shadow the allow of the parent", func() {
+			So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+			So(acl.SetActionAllowed(nil, userB, "testing", true), ShouldBeNil)
+			So(acl.SetActionAllowedUntil(nil, userA, "testing", false, past), ShouldBeNil)
+
+			decision, err := acl.ExplainAction(ctx, nil, userA, "testing")
+			So(err, ShouldBeNil)
+			So(decision.Allowed, ShouldEqual, true)
+			So(len(decision.Shadowed), ShouldEqual, 0)
+		})
+
+		Convey("When B has an allow", func() {
+			So(acl.SetActionAllowed(nil, userB, "testing", true), ShouldBeNil)
+
+			Convey("SetActorInheritsUntil() in the future should allow A", func() {
+				So(acl.SetActorInheritsUntil(nil, userA, userB, future), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("SetActorInheritsUntil() in the past should not allow A", func() {
+				So(acl.SetActorInheritsUntil(nil, userA, userB, past), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(nil, userA, "testing")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+
+				parents, err := acl.GetActorInherits(nil, userA)
+				So(err, ShouldBeNil)
+				So(parents, ShouldResemble, []string{userB.GetId()})
+
+				Convey("And SetActorInherits() should keep the window", func() {
+					So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+
+					allowed, err := acl.AllowsAction(nil, userA, "testing")
+					So(err, ShouldBeNil)
+					So(allowed, ShouldEqual, false)
+				})
+
+				Convey("And B -> A should still be refused as a cycle", func() {
+					So(acl.SetActorInherits(nil, userB, userA), ShouldNotBeNil)
+				})
+
+				Convey("And ListActors() should not include A", func() {
+					actors, err := acl.ListActors(ctx, nil, testResourceA, "testing", false)
+					So(err, ShouldBeNil)
+					So(actors, ShouldResemble, []string{userB.GetId()})
+				})
+			})
+		})
+
+		Convey("RemoveExpired() should remove only the expired rows", func() {
+			So(acl.SetActionAllowedUntil(nil, userA, "expired", true, past), ShouldBeNil)
+			So(acl.SetActionAllowedUntil(nil, userA, "valid", true, future), ShouldBeNil)
+			So(acl.SetActionAllowedBetween(nil, userA, "later", true, future, time.Time{}), ShouldBeNil)
+			So(acl.SetActorInheritsUntil(nil, userA, userB, past), ShouldBeNil)
+
+			n, err := acl.RemoveExpired(ctx, nil)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, int64(2))
+
+			parents, err := acl.GetActorInherits(nil, userA)
+			So(err, ShouldBeNil)
+			So(len(parents), ShouldEqual, 0)
+
+			grants, err := acl.store.Grants(ctx, nil, Query{ActorId: userA.GetId()})
+			So(err, ShouldBeNil)
+			So(len(grants), ShouldEqual, 2)
+
+			n, err = acl.RemoveExpired(ctx, nil)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, int64(0))
+		})
+	})
+}
@@ -0,0 +1,35 @@
+package acl
+
+import (
+	"strings"
+)
+
+const (
+	// ACTION_SEPARATOR separates the namespaces of an action, eg.
+	// "document:edit" is the action edit in the namespace document
+	ACTION_SEPARATOR = ":"
+	// ACTION_WILDCARD matches any action when used as an action or as the last
+	// part of a namespaced action, eg. "document:*" matches "document:edit"
+	ACTION_WILDCARD = "*"
+)
+
+// actionPatterns returns the wildcard patterns matching action, the most
+// specific first, eg. "document:*" and "*" for "document:edit"
+func actionPatterns(action string) []string {
+	segments := strings.Split(action, ACTION_SEPARATOR)
+	var ret []string
+
+	for i := len(segments) - 1; i > 0; i-- {
+		pattern := strings.Join(segments[:i], ACTION_SEPARATOR) + ACTION_SEPARATOR + ACTION_WILDCARD
+
+		if pattern != action {
+			ret = append(ret, pattern)
+		}
+	}
+
+	if action != ACTION_WILDCARD {
+		ret = append(ret, ACTION_WILDCARD)
+	}
+
+	return ret
+}
@@ -0,0 +1,106 @@
+package acl
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWildcards(t *testing.T) {
+	ctx := context.Background()
+
+	userA := idAble{id: "3eb9e0dc-72fa-4e8f-a188-dcca409220f9"}
+	userB := idAble{id: "4a567886-2de1-4b0b-9508-5e3125da30f8"}
+
+	testResourceA := idAble{id: "a74dc49c-e663-4144-9383-1a09c6c7ddfd"}
+
+	Convey("actionPatterns() should list the patterns from the most specific", t, func() {
+		So(actionPatterns("document:edit"), ShouldResemble, []string{"document:*", "*"})
+		So(actionPatterns("a:b:c"), ShouldResemble, []string{"a:b:*", "a:*", "*"})
+		So(actionPatterns("document:*"), ShouldResemble, []string{"*"})
+		So(actionPatterns("edit"), ShouldResemble, []string{"*"})
+		So(len(actionPatterns("*")), ShouldEqual, 0)
+	})
+
+	Convey("When A inherits from B", t, func() {
+		acl := NewMemory()
+
+		So(acl.SetActorInherits(nil, userA, userB), ShouldBeNil)
+
+		Convey("An allow on document:* should allow every document action", func() {
+			So(acl.SetActionAllowed(nil, userA, "document:*", true), ShouldBeNil)
+
+			for action, expected := range map[string]bool{"document:edit": true, "document:delete": true, "billing:read": false} {
+				allowed, err := acl.AllowsActionOn(nil, userA, action, testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, expected)
+			}
+
+			Convey("And an exact deny should win over the namespace wildcard", func() {
+				So(acl.SetActionAllowed(nil, userA, "document:delete", false), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(nil, userA, "document:delete")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+
+				allowed, err = acl.AllowsAction(nil, userA, "document:edit")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("And a global deny should lose to the namespace wildcard", func() {
+				So(acl.SetActionAllowed(nil, userA, "*", false), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(nil, userA, "document:edit")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+
+				allowed, err = acl.AllowsAction(nil, userA, "billing:read")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+
+				decision, err := acl.ExplainAction(ctx, nil, userA, "document:edit")
+				So(err, ShouldBeNil)
+				So(decision.Source.Action, ShouldEqual, "document:*")
+				So(decision.Source.ActionLevel, ShouldEqual, 1)
+				So(decision.Shadowed[0].Action, ShouldEqual, "*")
+				So(decision.Shadowed[0].ActionLevel, ShouldEqual, 2)
+			})
+
+			Convey("And a nearer wildcard should win over an exact row from B", func() {
+				So(acl.SetActionAllowed(nil, userB, "document:edit", false), ShouldBeNil)
+
+				allowed, err := acl.AllowsAction(nil, userA, "document:edit")
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, true)
+			})
+
+			Convey("And a wildcard deny on a specific target should win over the actor-wide row", func() {
+				So(acl.SetActionAllowedOn(nil, userA, "*", testResourceA, false), ShouldBeNil)
+
+				allowed, err := acl.AllowsActionOn(nil, userA, "document:edit", testResourceA)
+				So(err, ShouldBeNil)
+				So(allowed, ShouldEqual, false)
+			})
+		})
+
+		Convey("An allow on * should be reflected by the listings", func() {
+			So(acl.SetActionAllowedOn(nil, userB, "*", testResourceA, true), ShouldBeNil)
+
+			list, err := acl.ListTargets(ctx, nil, userA, "billing:read", Page{})
+			So(err, ShouldBeNil)
+			So(list.Ids, ShouldResemble, []string{testResourceA.GetId()})
+
+			actors, err := acl.ListActors(ctx, nil, testResourceA, "document:edit", false)
+			So(err, ShouldBeNil)
+			So(actors, ShouldResemble, []string{userA.GetId(), userB.GetId()})
+
+			permissions, err := acl.EffectivePermissions(ctx, nil, userA)
+			So(err, ShouldBeNil)
+			So(len(permissions), ShouldEqual, 1)
+			So(permissions[0].Action, ShouldEqual, "*")
+			So(permissions[0].Allowed, ShouldEqual, true)
+		})
+	})
+}